@@ -2,18 +2,23 @@ package main
 
 import (
     "log"
+    "net/http"
     "os"
+    "strconv"
     "strings"
     "github.com/gin-gonic/gin"
     "github.com/xpokorny/ambulance-webapi/api"
 	"github.com/xpokorny/ambulance-webapi/internal/ambulance_wl"
+	"github.com/xpokorny/ambulance-webapi/internal/auth"
 	"github.com/xpokorny/ambulance-webapi/internal/db_service"
     "context"
     "time"
     "github.com/gin-contrib/cors"
+    // time zones of working hours must be resolvable in the scratch image
+    _ "time/tzdata"
 )
 
-func initializeTestData(dbService db_service.DbService[ambulance_wl.User], locationService db_service.DbService[ambulance_wl.Location]) {
+func initializeTestData(dbService db_service.DbService[ambulance_wl.User], locationService db_service.DbService[ambulance_wl.Location], availabilityService db_service.DbService[ambulance_wl.Availability]) {
     // Check if data already exists by trying to find a known user
     _, err := dbService.FindDocument(context.Background(), "user1")
     if err == nil {
@@ -100,9 +105,61 @@ func initializeTestData(dbService db_service.DbService[ambulance_wl.User], locat
         }
     }
 
+    // Doctors work on weekdays from 8:00 to 16:00
+    for _, user := range users {
+        if user.Role != "doctor" {
+            continue
+        }
+        availability := ambulance_wl.DefaultAvailability(user.Id)
+        err := availabilityService.CreateDocument(context.Background(), user.Id, &availability)
+        if err != nil && err != db_service.ErrConflict {
+            log.Printf("Failed to create availability of %s: %v", user.Id, err)
+        }
+    }
+
     log.Printf("Database initialization completed")
 }
 
+// newDbService creates the storage for one collection. The in-memory backend
+// is selected with AMBULANCE_API_DB_BACKEND=memory and is intended for tests
+// and local runs without MongoDB, anything else falls back to MongoDB.
+func newDbService[DocType interface{}](backend string, config db_service.MongoServiceConfig) db_service.DbService[DocType] {
+    if strings.EqualFold(backend, "memory") {
+        log.Printf("Using in-memory storage for collection %v", config.Collection)
+        return db_service.NewMemoryService[DocType]()
+    }
+    return db_service.NewMongoService[DocType](config)
+}
+
+// purgeDeletedAppointments permanently removes appointments deleted longer
+// than AMBULANCE_API_DELETED_RETENTION_DAYS ago. Deleted appointments are
+// kept forever when the retention is not set.
+func purgeDeletedAppointments(ctx context.Context, appointmentService db_service.DbService[ambulance_wl.Appointment]) {
+    days, err := strconv.Atoi(os.Getenv("AMBULANCE_API_DELETED_RETENTION_DAYS"))
+    if err != nil || days <= 0 {
+        log.Printf("Retention of deleted appointments is not set, deleted appointments are kept")
+        return
+    }
+    retention := time.Duration(days) * 24 * time.Hour
+
+    ticker := time.NewTicker(time.Hour)
+    defer ticker.Stop()
+    for {
+        purged, err := appointmentService.PurgeDeletedDocuments(ctx, time.Now().Add(-retention))
+        if err != nil {
+            log.Printf("Failed to purge deleted appointments: %v", err)
+        } else if purged > 0 {
+            log.Printf("Purged %v appointments deleted more than %v days ago", purged, days)
+        }
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
 func main() {
     log.Printf("Server started")
     port := os.Getenv("AMBULANCE_API_PORT")
@@ -118,44 +175,173 @@ func main() {
 	corsMiddleware := cors.New(cors.Config{
         AllowOrigins:     []string{"*"},
         AllowMethods:     []string{"GET", "PUT", "POST", "DELETE", "PATCH"},
-        AllowHeaders:     []string{"Origin", "Authorization", "Content-Type"},
-        ExposeHeaders:    []string{""},
+        AllowHeaders:     []string{"Origin", "Authorization", "Content-Type", "If-Match", "X-API-Key"},
+        ExposeHeaders:    []string{"Link", "ETag"},
         AllowCredentials: false,
         MaxAge: 12 * time.Hour,
     })
     engine.Use(corsMiddleware)
 
     // setup context update middleware
-    appointmentService := db_service.NewMongoService[ambulance_wl.Appointment](db_service.MongoServiceConfig{
+    backend := os.Getenv("AMBULANCE_API_DB_BACKEND")
+    // the audit log is append-only, the writes to the other collections are
+    // recorded in it together with the changed fields
+    auditService := db_service.NewAppendOnly(newDbService[ambulance_wl.AuditEntry](backend, db_service.MongoServiceConfig{
+        Collection: "audit",
+        Indexes: []db_service.MongoIndex{
+            {Keys: []string{"timestamp"}},
+            {Keys: []string{"actorid", "timestamp"}},
+            {Keys: []string{"resourceids", "timestamp"}},
+        },
+    }))
+    recordChange := ambulance_wl.NewAuditRecorder(auditService)
+    appointmentService := newDbService[ambulance_wl.Appointment](backend, db_service.MongoServiceConfig{
         Collection: "appointments",
+        Indexes: []db_service.MongoIndex{
+            {Keys: []string{"datetime"}},
+            {Keys: []string{"patient.id", "datetime"}},
+            {Keys: []string{"doctor.id", "datetime"}},
+            {Keys: []string{"location.id", "datetime"}},
+            {Keys: []string{"createdby.id", "datetime"}},
+            {Keys: []string{"updatedby.id"}},
+            {Keys: []string{"status", "datetime"}},
+            {Keys: []string{"seriesid", "datetime"}},
+            {Keys: []string{"doctor.id", "priority", "datetime"}},
+            {Keys: []string{"deletedat"}},
+        },
+    })
+    appointmentService = db_service.NewAuditing(appointmentService, "appointments", recordChange)
+    seriesService := newDbService[ambulance_wl.AppointmentSeries](backend, db_service.MongoServiceConfig{
+        Collection: "appointment_series",
     })
-    userService := db_service.NewMongoService[ambulance_wl.User](db_service.MongoServiceConfig{
+    seriesService = db_service.NewAuditing(seriesService, "appointment_series", recordChange)
+    userService := newDbService[ambulance_wl.User](backend, db_service.MongoServiceConfig{
         Collection: "users",
+        Indexes: []db_service.MongoIndex{
+            {Keys: []string{"role"}},
+        },
     })
-    locationService := db_service.NewMongoService[ambulance_wl.Location](db_service.MongoServiceConfig{
+    userService = db_service.NewAuditing(userService, "users", recordChange)
+    locationService := newDbService[ambulance_wl.Location](backend, db_service.MongoServiceConfig{
         Collection: "locations",
     })
+    locationService = db_service.NewAuditing(locationService, "locations", recordChange)
+    availabilityService := newDbService[ambulance_wl.Availability](backend, db_service.MongoServiceConfig{
+        Collection: "availability",
+    })
+    availabilityService = db_service.NewAuditing(availabilityService, "availability", recordChange)
+    waitingListService := newDbService[ambulance_wl.WaitingListEntry](backend, db_service.MongoServiceConfig{
+        Collection: "waiting_list",
+        Indexes: []db_service.MongoIndex{
+            {Keys: []string{"doctor.id", "location.id", "status"}},
+        },
+    })
+    waitingListService = db_service.NewAuditing(waitingListService, "waiting_list", recordChange)
+    waitlistService := newDbService[ambulance_wl.WaitlistRequest](backend, db_service.MongoServiceConfig{
+        Collection: "waitlist",
+        Indexes: []db_service.MongoIndex{
+            {Keys: []string{"doctor.id", "status", "createdat"}},
+            {Keys: []string{"patient.id", "createdat"}},
+            {Keys: []string{"status", "offer.expiresat"}},
+        },
+    })
+    waitlistService = db_service.NewAuditing(waitlistService, "waitlist", recordChange)
+    notificationService := newDbService[ambulance_wl.Notification](backend, db_service.MongoServiceConfig{
+        Collection: "notifications",
+        Indexes: []db_service.MongoIndex{
+            {Keys: []string{"recipientid", "createdat"}},
+        },
+    })
+    notificationService = db_service.NewAuditing(notificationService, "notifications", recordChange)
+    apiKeyService := newDbService[ambulance_wl.ApiKeyRecord](backend, db_service.MongoServiceConfig{
+        Collection: "api_keys",
+        Indexes: []db_service.MongoIndex{
+            {Keys: []string{"keyhash"}},
+        },
+    })
+    apiKeyService = db_service.NewAuditing(apiKeyService, "api_keys", recordChange, "keyhash")
+    // leases of the booking locks exclude conflicting bookings across all
+    // replicas of the service
+    bookingLeaseService := newDbService[ambulance_wl.BookingLease](backend, db_service.MongoServiceConfig{
+        Collection: "booking_leases",
+    })
+    ambulance_wl.UseBookingLeases(bookingLeaseService)
     defer appointmentService.Disconnect(context.Background())
+    defer seriesService.Disconnect(context.Background())
     defer userService.Disconnect(context.Background())
     defer locationService.Disconnect(context.Background())
+    defer availabilityService.Disconnect(context.Background())
+    defer waitingListService.Disconnect(context.Background())
+    defer waitlistService.Disconnect(context.Background())
+    defer notificationService.Disconnect(context.Background())
+    defer apiKeyService.Disconnect(context.Background())
+    defer auditService.Disconnect(context.Background())
+    defer bookingLeaseService.Disconnect(context.Background())
 
     // Initialize test data
-    initializeTestData(userService, locationService)
+    initializeTestData(userService, locationService, availabilityService)
+
+    backgroundContext, stopBackground := context.WithCancel(context.Background())
+    defer stopBackground()
+    go purgeDeletedAppointments(backgroundContext, appointmentService)
+    go ambulance_wl.ExpireSlotOffers(backgroundContext, waitlistService, appointmentService, notificationService)
+
+    // request routings
+	handleFunctions := &ambulance_wl.ApiHandleFunctions{
+		AppointmentsAPI:  ambulance_wl.NewAppointmentsAPI(),
+		UsersAPI:         ambulance_wl.NewUsersAPI(),
+		LocationsAPI:     ambulance_wl.NewLocationsAPI(),
+		AvailabilityAPI:  ambulance_wl.NewAvailabilityAPI(),
+		SlotsAPI:         ambulance_wl.NewSlotsAPI(),
+		WaitingListAPI:   ambulance_wl.NewWaitingListAPI(),
+		NotificationsAPI: ambulance_wl.NewNotificationsAPI(),
+		WaitlistAPI:      ambulance_wl.NewWaitlistAPI(),
+		ApiKeysAPI:       ambulance_wl.NewApiKeysAPI(),
+		AuditAPI:         ambulance_wl.NewAuditAPI(),
+	}
+    // calls of the API routes are audited, including the ones rejected by
+    // the authentication or the authorization
+    engine.Use(ambulance_wl.NewAuditMiddleware(*handleFunctions, auditService))
+
+    // every API route requires a bearer token or an API key, authentication
+    // can be turned off only explicitly, e.g. for local runs with the
+    // in-memory backend
+    authDisabled := strings.EqualFold(os.Getenv("AMBULANCE_API_AUTH_DISABLED"), "true")
+    if authDisabled {
+        log.Printf("Authentication is disabled, anyone can call the API")
+    } else {
+        authenticator, err := auth.NewAuthenticator(auth.AuthenticatorConfig{
+            APIKeys: ambulance_wl.NewApiKeyVerifier(apiKeyService),
+        })
+        if err != nil {
+            log.Fatalf("Cannot set up authentication: %v", err)
+        }
+        engine.Use(authenticator.Middleware("/openapi", "/health"))
+    }
 
     engine.Use(func(ctx *gin.Context) {
         ctx.Set("appointment_service", appointmentService)
+        ctx.Set("appointment_series_service", seriesService)
         ctx.Set("user_service", userService)
         ctx.Set("location_service", locationService)
+        ctx.Set("availability_service", availabilityService)
+        ctx.Set("waiting_list_service", waitingListService)
+        ctx.Set("waitlist_service", waitlistService)
+        ctx.Set("notification_service", notificationService)
+        ctx.Set("api_key_service", apiKeyService)
+        ctx.Set("audit_service", auditService)
         ctx.Next()
     })
 
-    // request routings
-	handleFunctions := &ambulance_wl.ApiHandleFunctions{
-		AppointmentsAPI: ambulance_wl.NewAppointmentsAPI(),
-		UsersAPI:        ambulance_wl.NewUsersAPI(),
-		LocationsAPI:    ambulance_wl.NewLocationsAPI(),
-	}
+    // roles of the authenticated callers are checked against the policies
+    // of the routes
+    if !authDisabled {
+        engine.Use(ambulance_wl.NewAuthorizationMiddleware(*handleFunctions))
+    }
 	ambulance_wl.NewRouterWithGinEngine(engine, *handleFunctions)
     engine.GET("/openapi", api.HandleOpenApi)
+    engine.GET("/health", func(c *gin.Context) {
+        c.JSON(http.StatusOK, gin.H{"status": "UP"})
+    })
     engine.Run(":" + port)
 }
\ No newline at end of file
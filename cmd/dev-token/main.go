@@ -0,0 +1,110 @@
+// Command dev-token signs bearer tokens for local testing of the API.
+//
+// The development key pair is generated into the directory given by -dir on
+// first use and reused afterwards, its public part is written there as
+// jwks.json for AMBULANCE_API_AUTH_JWKS. No key is kept in the repository.
+//
+//    dev-token -dir .dev-auth                 # only creates the key pair
+//    dev-token -dir .dev-auth user1 patient   # prints a token of user1
+package main
+
+import (
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/x509"
+    "encoding/base64"
+    "encoding/json"
+    "encoding/pem"
+    "errors"
+    "flag"
+    "fmt"
+    "log"
+    "math/big"
+    "os"
+    "path/filepath"
+    "time"
+
+    "github.com/golang-jwt/jwt/v5"
+)
+
+const keyId = "dev"
+
+func main() {
+    dir := flag.String("dir", ".dev-auth", "directory of the development key pair")
+    validity := flag.Duration("validity", 24*time.Hour, "validity of the token")
+    flag.Usage = func() {
+        fmt.Fprintf(flag.CommandLine.Output(), "usage: dev-token [flags] [user [role]]\n")
+        flag.PrintDefaults()
+    }
+    flag.Parse()
+
+    key, err := loadKeyPair(*dir)
+    if err != nil {
+        log.Fatalf("Failed to prepare the development key pair in %v: %v", *dir, err)
+    }
+    if flag.NArg() == 0 {
+        return
+    }
+
+    user, role := flag.Arg(0), "admin"
+    if flag.NArg() > 1 {
+        role = flag.Arg(1)
+    }
+    token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+        "sub":  user,
+        "role": role,
+        "exp":  time.Now().Add(*validity).Unix(),
+    })
+    token.Header["kid"] = keyId
+    signed, err := token.SignedString(key)
+    if err != nil {
+        log.Fatalf("Failed to sign the token: %v", err)
+    }
+    fmt.Println(signed)
+}
+
+// loadKeyPair reads the private key from the directory, or generates it
+// together with the JWKS of its public key when it does not exist yet.
+func loadKeyPair(dir string) (*rsa.PrivateKey, error) {
+    keyFile := filepath.Join(dir, "private-key.pem")
+    raw, err := os.ReadFile(keyFile)
+    if err == nil {
+        block, _ := pem.Decode(raw)
+        if block == nil {
+            return nil, fmt.Errorf("%v is not a PEM file", keyFile)
+        }
+        return x509.ParsePKCS1PrivateKey(block.Bytes)
+    }
+    if !errors.Is(err, os.ErrNotExist) {
+        return nil, err
+    }
+
+    key, err := rsa.GenerateKey(rand.Reader, 2048)
+    if err != nil {
+        return nil, err
+    }
+    jwks, err := json.MarshalIndent(map[string]interface{}{
+        "keys": []map[string]string{{
+            "kty": "RSA",
+            "kid": keyId,
+            "use": "sig",
+            "alg": "RS256",
+            "n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+            "e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+        }},
+    }, "", "  ")
+    if err != nil {
+        return nil, err
+    }
+    if err := os.MkdirAll(dir, 0o700); err != nil {
+        return nil, err
+    }
+    if err := os.WriteFile(filepath.Join(dir, "jwks.json"), jwks, 0o644); err != nil {
+        return nil, err
+    }
+    encoded := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+    if err := os.WriteFile(keyFile, encoded, 0o600); err != nil {
+        return nil, err
+    }
+    return key, nil
+}
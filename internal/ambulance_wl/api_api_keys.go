@@ -0,0 +1,32 @@
+/*
+ * Appointment Scheduling Api
+ *
+ * Medical Appointment Scheduling System
+ *
+ * API version: 1.0.0
+ * Contact: xpokorny@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+type ApiKeysAPI interface {
+
+
+    // GetApiKeys Get /api/api-keys
+    // Get API keys 
+     GetApiKeys(c *gin.Context)
+
+    // IssueApiKey Post /api/api-keys
+    // Issue an API key 
+     IssueApiKey(c *gin.Context)
+
+    // RevokeApiKey Delete /api/api-keys/:keyId
+    // Revoke an API key 
+     RevokeApiKey(c *gin.Context)
+
+}
\ No newline at end of file
@@ -17,10 +17,30 @@ import (
 type AppointmentsAPI interface {
 
 
+    // CancelAppointment Post /api/appointments/:appointmentId/cancel
+    // Cancel an appointment 
+     CancelAppointment(c *gin.Context)
+
+    // CancelAppointmentSeries Post /api/appointment-series/:seriesId/cancel
+    // Cancel a recurring series 
+     CancelAppointmentSeries(c *gin.Context)
+
+    // CheckInAppointment Post /api/appointments/:appointmentId/check-in
+    // Check in a patient 
+     CheckInAppointment(c *gin.Context)
+
+    // CompleteAppointment Post /api/appointments/:appointmentId/complete
+    // Complete an appointment 
+     CompleteAppointment(c *gin.Context)
+
     // CreateAppointment Post /api/appointments
     // Create a new appointment 
      CreateAppointment(c *gin.Context)
 
+    // CreateAppointmentSeries Post /api/appointment-series
+    // Create a recurring series of appointments 
+     CreateAppointmentSeries(c *gin.Context)
+
     // DeleteAppointment Delete /api/appointments/:appointmentId
     // Delete an appointment 
      DeleteAppointment(c *gin.Context)
@@ -29,12 +49,36 @@ type AppointmentsAPI interface {
     // Get appointment details 
      GetAppointment(c *gin.Context)
 
+    // GetAppointmentSeries Get /api/appointment-series/:seriesId
+    // Get a recurring series 
+     GetAppointmentSeries(c *gin.Context)
+
     // GetAppointments Get /api/appointments
     // Get all appointments 
      GetAppointments(c *gin.Context)
 
+    // InsertUrgentAppointment Post /api/appointments/urgent
+    // Insert an urgent appointment 
+     InsertUrgentAppointment(c *gin.Context)
+
+    // MarkAppointmentNoShow Post /api/appointments/:appointmentId/no-show
+    // Mark an appointment as no-show 
+     MarkAppointmentNoShow(c *gin.Context)
+
+    // RestoreAppointment Post /api/appointments/:appointmentId/restore
+    // Restore a deleted appointment 
+     RestoreAppointment(c *gin.Context)
+
+    // StartAppointment Post /api/appointments/:appointmentId/start
+    // Start an appointment 
+     StartAppointment(c *gin.Context)
+
     // UpdateAppointment Put /api/appointments/:appointmentId
     // Update an appointment 
      UpdateAppointment(c *gin.Context)
 
+    // UpdateAppointmentSeries Put /api/appointment-series/:seriesId
+    // Change a recurring series 
+     UpdateAppointmentSeries(c *gin.Context)
+
 }
\ No newline at end of file
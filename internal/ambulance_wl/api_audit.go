@@ -0,0 +1,24 @@
+/*
+ * Appointment Scheduling Api
+ *
+ * Medical Appointment Scheduling System
+ *
+ * API version: 1.0.0
+ * Contact: xpokorny@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+type AuditAPI interface {
+
+
+    // GetAuditEntries Get /api/audit
+    // Get audit log entries 
+     GetAuditEntries(c *gin.Context)
+
+}
\ No newline at end of file
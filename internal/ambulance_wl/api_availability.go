@@ -0,0 +1,40 @@
+/*
+ * Appointment Scheduling Api
+ *
+ * Medical Appointment Scheduling System
+ *
+ * API version: 1.0.0
+ * Contact: xpokorny@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+type AvailabilityAPI interface {
+
+
+    // CreateAvailabilityException Post /api/users/:userId/availability/exceptions
+    // Add an absence of a doctor 
+     CreateAvailabilityException(c *gin.Context)
+
+    // DeleteAvailability Delete /api/users/:userId/availability
+    // Delete availability of a doctor 
+     DeleteAvailability(c *gin.Context)
+
+    // DeleteAvailabilityException Delete /api/users/:userId/availability/exceptions/:exceptionId
+    // Remove an absence of a doctor 
+     DeleteAvailabilityException(c *gin.Context)
+
+    // GetAvailability Get /api/users/:userId/availability
+    // Get availability of a doctor 
+     GetAvailability(c *gin.Context)
+
+    // UpdateAvailability Put /api/users/:userId/availability
+    // Set availability of a doctor 
+     UpdateAvailability(c *gin.Context)
+
+}
\ No newline at end of file
@@ -17,8 +17,24 @@ import (
 type LocationsAPI interface {
 
 
+    // CreateLocation Post /api/locations
+    // Create a new location 
+     CreateLocation(c *gin.Context)
+
+    // DeleteLocation Delete /api/locations/:locationId
+    // Retire a location 
+     DeleteLocation(c *gin.Context)
+
+    // GetLocation Get /api/locations/:locationId
+    // Get location details 
+     GetLocation(c *gin.Context)
+
     // GetLocations Get /api/locations
     // Get all locations 
      GetLocations(c *gin.Context)
 
+    // UpdateLocation Put /api/locations/:locationId
+    // Update a location 
+     UpdateLocation(c *gin.Context)
+
 }
\ No newline at end of file
@@ -0,0 +1,28 @@
+/*
+ * Appointment Scheduling Api
+ *
+ * Medical Appointment Scheduling System
+ *
+ * API version: 1.0.0
+ * Contact: xpokorny@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+type NotificationsAPI interface {
+
+
+    // GetNotifications Get /api/users/:userId/notifications
+    // Get notifications of a user 
+     GetNotifications(c *gin.Context)
+
+    // MarkNotificationRead Post /api/users/:userId/notifications/:notificationId/read
+    // Mark a notification as read 
+     MarkNotificationRead(c *gin.Context)
+
+}
\ No newline at end of file
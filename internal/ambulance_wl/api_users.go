@@ -17,6 +17,18 @@ import (
 type UsersAPI interface {
 
 
+    // ActivateUser Post /api/users/:userId/activate
+    // Activate a user 
+     ActivateUser(c *gin.Context)
+
+    // CreateUser Post /api/users
+    // Create a new user 
+     CreateUser(c *gin.Context)
+
+    // DeactivateUser Post /api/users/:userId/deactivate
+    // Deactivate a user 
+     DeactivateUser(c *gin.Context)
+
     // GetUser Get /api/users/:userId
     // Get user details 
      GetUser(c *gin.Context)
@@ -25,4 +37,12 @@ type UsersAPI interface {
     // Get all users 
      GetUsers(c *gin.Context)
 
+    // PatchUser Patch /api/users/:userId
+    // Partially update a user 
+     PatchUser(c *gin.Context)
+
+    // UpdateUser Put /api/users/:userId
+    // Update a user 
+     UpdateUser(c *gin.Context)
+
 }
\ No newline at end of file
@@ -0,0 +1,40 @@
+/*
+ * Appointment Scheduling Api
+ *
+ * Medical Appointment Scheduling System
+ *
+ * API version: 1.0.0
+ * Contact: xpokorny@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+type WaitingListAPI interface {
+
+
+    // CallNextWaitingPatient Post /api/waiting-list/call-next
+    // Call the next patient 
+     CallNextWaitingPatient(c *gin.Context)
+
+    // CreateWaitingListEntry Post /api/waiting-list
+    // Add a walk-in patient to the queue 
+     CreateWaitingListEntry(c *gin.Context)
+
+    // DeleteWaitingListEntry Delete /api/waiting-list/:entryId
+    // Remove a patient from the queue 
+     DeleteWaitingListEntry(c *gin.Context)
+
+    // GetWaitingList Get /api/waiting-list
+    // Get waiting patients 
+     GetWaitingList(c *gin.Context)
+
+    // GetWaitingListEntry Get /api/waiting-list/:entryId
+    // Get a waiting list entry 
+     GetWaitingListEntry(c *gin.Context)
+
+}
\ No newline at end of file
@@ -0,0 +1,44 @@
+/*
+ * Appointment Scheduling Api
+ *
+ * Medical Appointment Scheduling System
+ *
+ * API version: 1.0.0
+ * Contact: xpokorny@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+type WaitlistAPI interface {
+
+
+    // AcceptSlotOffer Post /api/waitlist/:requestId/accept
+    // Accept the offered slot 
+     AcceptSlotOffer(c *gin.Context)
+
+    // CancelWaitlistRequest Delete /api/waitlist/:requestId
+    // Leave the waitlist 
+     CancelWaitlistRequest(c *gin.Context)
+
+    // CreateWaitlistRequest Post /api/waitlist
+    // Join the waitlist of a doctor 
+     CreateWaitlistRequest(c *gin.Context)
+
+    // DeclineSlotOffer Post /api/waitlist/:requestId/decline
+    // Decline the offered slot 
+     DeclineSlotOffer(c *gin.Context)
+
+    // GetWaitlistRequest Get /api/waitlist/:requestId
+    // Get a waitlist request 
+     GetWaitlistRequest(c *gin.Context)
+
+    // GetWaitlistRequests Get /api/waitlist
+    // Get waitlist requests 
+     GetWaitlistRequests(c *gin.Context)
+
+}
\ No newline at end of file
@@ -0,0 +1,192 @@
+package ambulance_wl
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/google/uuid"
+    "github.com/xpokorny/ambulance-webapi/internal/auth"
+    "github.com/xpokorny/ambulance-webapi/internal/db_service"
+)
+
+// ApiKeyRecord is the stored API key, the key itself is never stored, only
+// its hash.
+type ApiKeyRecord struct {
+    ApiKey  `bson:",inline"`
+    KeyHash string
+}
+
+type implApiKeysAPI struct {
+}
+
+func NewApiKeysAPI() ApiKeysAPI {
+    return &implApiKeysAPI{}
+}
+
+func (api *implApiKeysAPI) GetApiKeys(c *gin.Context) {
+    apiKeyService, ok := dbServiceFromContext[ApiKeyRecord](c, "api_key_service")
+    if !ok {
+        return
+    }
+
+    records, err := apiKeyService.FindDocuments(c.Request.Context(), db_service.Query{
+        Sort: []db_service.SortField{{Field: "createdat"}, {Field: "id"}},
+    })
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{
+            "error":   err.Error(),
+            "message": "failed to get API keys",
+            "status":  "Internal Server Error",
+        })
+        return
+    }
+
+    apiKeys := make([]ApiKey, 0, len(records))
+    for _, record := range records {
+        apiKeys = append(apiKeys, record.ApiKey)
+    }
+    recordReads(c, apiKeys, func(apiKey *ApiKey) string { return apiKey.Id })
+    c.JSON(http.StatusOK, apiKeys)
+}
+
+func (api *implApiKeysAPI) IssueApiKey(c *gin.Context) {
+    apiKeyService, ok := dbServiceFromContext[ApiKeyRecord](c, "api_key_service")
+    if !ok {
+        return
+    }
+
+    apiKey := ApiKey{}
+    if err := c.ShouldBindJSON(&apiKey); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{
+            "error":   err.Error(),
+            "message": "invalid request body",
+            "status":  "Bad Request",
+        })
+        return
+    }
+    if err := validateApiKey(&apiKey); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{
+            "error":   err.Error(),
+            "message": "invalid API key",
+            "status":  "Bad Request",
+        })
+        return
+    }
+
+    key, shown, hash, err := auth.GenerateAPIKey()
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{
+            "error":   err.Error(),
+            "message": "failed to generate API key",
+            "status":  "Internal Server Error",
+        })
+        return
+    }
+    issuer, _, _ := auth.Caller(c)
+    apiKey.Id = uuid.New().String()
+    apiKey.Key = ""
+    apiKey.Prefix = shown
+    apiKey.CreatedAt = time.Now().UTC()
+    apiKey.CreatedBy = issuer
+    apiKey.RevokedAt = nil
+
+    record := ApiKeyRecord{ApiKey: apiKey, KeyHash: hash}
+    if err := apiKeyService.CreateDocument(c.Request.Context(), apiKey.Id, &record); err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{
+            "error":   err.Error(),
+            "message": "failed to store API key",
+            "status":  "Internal Server Error",
+        })
+        return
+    }
+
+    // the key is shown only now, it cannot be recovered from its hash
+    apiKey = record.ApiKey
+    apiKey.Key = key
+    c.JSON(http.StatusCreated, apiKey)
+}
+
+func (api *implApiKeysAPI) RevokeApiKey(c *gin.Context) {
+    apiKeyService, ok := dbServiceFromContext[ApiKeyRecord](c, "api_key_service")
+    if !ok {
+        return
+    }
+
+    keyId := c.Param("keyId")
+    record, err := apiKeyService.FindDocument(c.Request.Context(), keyId)
+    if err == nil && record.RevokedAt != nil {
+        c.JSON(http.StatusConflict, gin.H{
+            "error":   "API key is already revoked",
+            "message": "API key " + keyId + " was revoked at " + record.RevokedAt.Format(time.RFC3339),
+            "status":  "Conflict",
+        })
+        return
+    }
+    if err == nil {
+        now := time.Now().UTC()
+        record.RevokedAt = &now
+        err = apiKeyService.ReplaceDocumentIfVersion(c.Request.Context(), keyId, record.Version, record)
+    }
+
+    switch err {
+    case nil:
+        c.AbortWithStatus(http.StatusNoContent)
+    case db_service.ErrNotFound:
+        c.JSON(http.StatusNotFound, gin.H{
+            "error":   "API key not found",
+            "message": "API key with id " + keyId + " not found",
+            "status":  "Not Found",
+        })
+    case db_service.ErrPreconditionFailed:
+        concurrentChangeResponse(c)
+    default:
+        c.JSON(http.StatusInternalServerError, gin.H{
+            "error":   err.Error(),
+            "message": "failed to revoke API key",
+            "status":  "Internal Server Error",
+        })
+    }
+}
+
+// NewApiKeyVerifier returns the verifier of API keys stored in the service
+// for the authentication middleware.
+func NewApiKeyVerifier(apiKeyService db_service.DbService[ApiKeyRecord]) auth.APIKeyVerifier {
+    return func(ctx context.Context, hash string) (*auth.APIKey, error) {
+        records, err := apiKeyService.FindDocuments(ctx, db_service.Query{
+            Filter: db_service.Eq("keyhash", hash),
+            Limit:  1,
+        })
+        if err != nil {
+            return nil, err
+        }
+        if len(records) == 0 {
+            return nil, nil
+        }
+        record := records[0]
+        if record.RevokedAt != nil || (record.ExpiresAt != nil && !time.Now().Before(*record.ExpiresAt)) {
+            return nil, nil
+        }
+        return &auth.APIKey{Id: record.Id, Scopes: record.Scopes}, nil
+    }
+}
+
+func validateApiKey(apiKey *ApiKey) error {
+    if apiKey.Name == "" {
+        return fmt.Errorf("name is required")
+    }
+    if len(apiKey.Scopes) == 0 {
+        return fmt.Errorf("at least one scope is required")
+    }
+    for _, scope := range apiKey.Scopes {
+        if !apiKeyScopes[scope] {
+            return fmt.Errorf("unknown scope %q", scope)
+        }
+    }
+    if apiKey.ExpiresAt != nil && !apiKey.ExpiresAt.After(time.Now()) {
+        return fmt.Errorf("expiresAt must be in the future")
+    }
+    return nil
+}
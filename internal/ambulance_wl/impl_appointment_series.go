@@ -0,0 +1,788 @@
+package ambulance_wl
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/google/uuid"
+    "github.com/xpokorny/ambulance-webapi/internal/db_service"
+)
+
+const (
+    seriesStatusActive    = "active"
+    seriesStatusCancelled = "cancelled"
+
+    maxSeriesOccurrences = 100
+)
+
+var recurrenceFrequencies = map[string]func(t time.Time, n int) time.Time{
+    "daily":   func(t time.Time, n int) time.Time { return t.AddDate(0, 0, n) },
+    "weekly":  func(t time.Time, n int) time.Time { return t.AddDate(0, 0, 7*n) },
+    "monthly": func(t time.Time, n int) time.Time { return t.AddDate(0, n, 0) },
+}
+
+// seriesServices are the db services needed to book a series.
+type seriesServices struct {
+    appointments db_service.DbService[Appointment]
+    series       db_service.DbService[AppointmentSeries]
+    users        db_service.DbService[User]
+    locations    db_service.DbService[Location]
+    availability db_service.DbService[Availability]
+}
+
+func seriesServicesFromContext(c *gin.Context) (*seriesServices, bool) {
+    var ok bool
+    services := &seriesServices{}
+    if services.appointments, ok = dbServiceFromContext[Appointment](c, "appointment_service"); !ok {
+        return nil, false
+    }
+    if services.series, ok = dbServiceFromContext[AppointmentSeries](c, "appointment_series_service"); !ok {
+        return nil, false
+    }
+    if services.users, ok = dbServiceFromContext[User](c, "user_service"); !ok {
+        return nil, false
+    }
+    if services.locations, ok = dbServiceFromContext[Location](c, "location_service"); !ok {
+        return nil, false
+    }
+    if services.availability, ok = dbServiceFromContext[Availability](c, "availability_service"); !ok {
+        return nil, false
+    }
+    return services, true
+}
+
+func (o implAppointmentsAPI) CreateAppointmentSeries(c *gin.Context) {
+    services, ok := seriesServicesFromContext(c)
+    if !ok {
+        return
+    }
+
+    series := AppointmentSeries{}
+    if err := c.ShouldBindJSON(&series); err != nil {
+        c.JSON(
+            http.StatusBadRequest,
+            gin.H{
+                "status":  "Bad Request",
+                "message": "Invalid request body",
+                "error":   err.Error(),
+            })
+        return
+    }
+    if series.Id == "" || series.Id == "@new" {
+        series.Id = uuid.New().String()
+    }
+    series.Status = seriesStatusActive
+    series.PreviousSeriesId = ""
+    creator, err := creatorReference(c, services.users, series.CreatedBy)
+    if err != nil {
+        referencesErrorResponse(c, err)
+        return
+    }
+    series.CreatedBy = creator
+
+    occurrences, ok := o.prepareSeries(c, services, &series, nil)
+    if !ok {
+        return
+    }
+
+    unlock, err := lockSeries(c, &series)
+    if err != nil {
+        bookingLockResponse(c, err)
+        return
+    }
+    defer unlock()
+
+    if !o.checkOccurrences(c, services, occurrences, nil) {
+        return
+    }
+
+    if err := services.series.CreateDocument(c, series.Id, &series); err != nil {
+        seriesWriteErrorResponse(c, err)
+        return
+    }
+    if err := createOccurrences(c, services.appointments, occurrences); err != nil {
+        // a series without all its occurrences is not left behind
+        undoCreated(c, services.series, series.Id)
+        occurrencesWriteErrorResponse(c, err)
+        return
+    }
+    c.JSON(http.StatusCreated, series)
+}
+
+func (o implAppointmentsAPI) GetAppointmentSeries(c *gin.Context) {
+    db, ok := dbServiceFromContext[AppointmentSeries](c, "appointment_series_service")
+    if !ok {
+        return
+    }
+
+    series, err := db.FindDocument(c, c.Param("seriesId"))
+    if err == nil && !takesPart(c, series.Patient.Id, series.Doctor.Id) {
+        err = db_service.ErrNotFound
+    }
+    if err != nil {
+        seriesReadErrorResponse(c, err)
+        return
+    }
+    c.JSON(http.StatusOK, series)
+}
+
+// UpdateAppointmentSeries replaces the scheduled occurrences starting at the
+// from query parameter, or from now, by occurrences of the new rule. The
+// replaced occurrences are cancelled, occurrences already checked in,
+// completed or cancelled are kept as they are.
+func (o implAppointmentsAPI) UpdateAppointmentSeries(c *gin.Context) {
+    services, ok := seriesServicesFromContext(c)
+    if !ok {
+        return
+    }
+
+    from, err := seriesFromParameter(c, time.Now().UTC())
+    if err != nil {
+        c.JSON(
+            http.StatusBadRequest,
+            gin.H{
+                "status":  "Bad Request",
+                "message": "Invalid from parameter",
+                "error":   err.Error(),
+            })
+        return
+    }
+
+    changed := AppointmentSeries{}
+    if err := c.ShouldBindJSON(&changed); err != nil {
+        c.JSON(
+            http.StatusBadRequest,
+            gin.H{
+                "status":  "Bad Request",
+                "message": "Invalid request body",
+                "error":   err.Error(),
+            })
+        return
+    }
+
+    series, err := services.series.FindDocument(c, c.Param("seriesId"))
+    if err == nil && !takesPart(c, series.Patient.Id, series.Doctor.Id) {
+        err = db_service.ErrNotFound
+    }
+    if err != nil {
+        seriesReadErrorResponse(c, err)
+        return
+    }
+    if series.Status == seriesStatusCancelled {
+        seriesCancelledResponse(c)
+        return
+    }
+
+    // changing the series from its first occurrence changes it in place,
+    // a later start splits it into two series
+    split := from.After(series.DateTime)
+    if !split {
+        from = series.DateTime
+        changed.Id = series.Id
+        changed.PreviousSeriesId = series.PreviousSeriesId
+        changed.CreatedBy = series.CreatedBy
+    } else {
+        changed.Id = uuid.New().String()
+        changed.PreviousSeriesId = series.Id
+        if changed.CreatedBy, err = creatorReference(c, services.users, changed.CreatedBy); err != nil {
+            referencesErrorResponse(c, err)
+            return
+        }
+    }
+    changed.Status = seriesStatusActive
+    if changed.DateTime.Before(from) {
+        c.JSON(
+            http.StatusBadRequest,
+            gin.H{
+                "status":  "Bad Request",
+                "message": "Invalid series",
+                "error":   "dateTime must not be before the first changed occurrence at " + from.Format(time.RFC3339),
+            })
+        return
+    }
+
+    occurrences, ok := o.prepareSeries(c, services, &changed, series)
+    if !ok {
+        return
+    }
+    editor, err := editorReference(c, services.users, changed.CreatedBy)
+    if err != nil {
+        referencesErrorResponse(c, err)
+        return
+    }
+    // the new occurrences are created by the caller even when the series
+    // keeps its creator
+    for i := range occurrences {
+        markCreated(&occurrences[i], editor)
+        occurrences[i].StatusHistory[0].ChangedBy = editor
+    }
+
+    unlock, err := bookingLocks.Lock(c,
+        "doctor/"+series.Doctor.Id, "location/"+series.Location.Id,
+        "doctor/"+changed.Doctor.Id, "location/"+changed.Location.Id)
+    if err != nil {
+        bookingLockResponse(c, err)
+        return
+    }
+    defer unlock()
+
+    replaced, err := services.appointments.FindDocuments(c, db_service.Query{
+        Filter: db_service.And(
+            db_service.Eq("seriesid", series.Id),
+            db_service.Gte("datetime", from),
+            db_service.In("status", statusScheduled, nil),
+        ),
+    })
+    if err != nil {
+        c.JSON(
+            http.StatusBadGateway,
+            gin.H{
+                "status":  "Bad Gateway",
+                "message": "Failed to get occurrences of the series",
+                "error":   err.Error(),
+            })
+        return
+    }
+    replacedIds := []string{}
+    for _, appointment := range replaced {
+        replacedIds = append(replacedIds, appointment.Id)
+    }
+
+    if !o.checkOccurrences(c, services, occurrences, replacedIds) {
+        return
+    }
+
+    // the new occurrences and series are written first, so that a failure is
+    // undone by removing them before the replaced occurrences are cancelled
+    if err := createOccurrences(c, services.appointments, occurrences); err != nil {
+        occurrencesWriteErrorResponse(c, err)
+        return
+    }
+    if split {
+        truncateSeries(series, from)
+        err = services.series.CreateDocument(c, changed.Id, &changed)
+        if err == nil {
+            err = services.series.ReplaceDocumentIfVersion(c, series.Id, series.Version, series)
+            if err != nil {
+                undoCreated(c, services.series, changed.Id)
+            }
+        }
+    } else {
+        err = services.series.ReplaceDocumentIfVersion(c, series.Id, series.Version, &changed)
+    }
+    if err != nil {
+        undoCreated(c, services.appointments, occurrenceIds(occurrences)...)
+        seriesWriteErrorResponse(c, err)
+        return
+    }
+
+    // replaced occurrences are cancelled rather than deleted, so that they
+    // stay in the records and their slots are offered to the waitlist
+    change := StatusChange{
+        Status:    statusCancelled,
+        ChangedAt: time.Now().UTC(),
+        ChangedBy: editor,
+        Reason:    "Replaced by the changed series",
+    }
+    failedIds := []string{}
+    compensated := true
+    for i := range replaced {
+        err := cancelOccurrence(c, services.appointments, &replaced[i], change, editor)
+        if err == nil {
+            continue
+        }
+        log.Printf("Failed to cancel replaced occurrence %v of series %v: %v", replaced[i].Id, series.Id, err)
+        failedIds = append(failedIds, replaced[i].Id)
+        // the replaced occurrence stays booked, the new occurrences at its
+        // time are cancelled instead, so that the time is not booked twice
+        withdrawal := StatusChange{
+            Status:    statusCancelled,
+            ChangedAt: time.Now().UTC(),
+            ChangedBy: editor,
+            Reason:    "Replaced occurrence " + replaced[i].Id + " could not be cancelled",
+        }
+        for _, j := range overlappingOccurrences(occurrences, &replaced[i]) {
+            if err := cancelOccurrence(c, services.appointments, &occurrences[j], withdrawal, editor); err != nil {
+                log.Printf("Failed to cancel occurrence %v of series %v overlapping %v: %v", occurrences[j].Id, changed.Id, replaced[i].Id, err)
+                compensated = false
+            }
+        }
+    }
+    if len(failedIds) > 0 {
+        occurrencesNotChangedResponse(c, compensated, failedIds)
+        return
+    }
+    c.JSON(http.StatusOK, changed)
+}
+
+// CancelAppointmentSeries cancels the occurrences starting at the from query
+// parameter, or from now, and ends the series before them.
+func (o implAppointmentsAPI) CancelAppointmentSeries(c *gin.Context) {
+    services, ok := seriesServicesFromContext(c)
+    if !ok {
+        return
+    }
+
+    from, err := seriesFromParameter(c, time.Now().UTC())
+    if err != nil {
+        c.JSON(
+            http.StatusBadRequest,
+            gin.H{
+                "status":  "Bad Request",
+                "message": "Invalid from parameter",
+                "error":   err.Error(),
+            })
+        return
+    }
+
+    request, ok := bindStatusChange(c)
+    if !ok {
+        return
+    }
+
+    series, err := services.series.FindDocument(c, c.Param("seriesId"))
+    if err == nil && !takesPart(c, series.Patient.Id, series.Doctor.Id) {
+        err = db_service.ErrNotFound
+    }
+    if err != nil {
+        seriesReadErrorResponse(c, err)
+        return
+    }
+    if series.Status == seriesStatusCancelled {
+        seriesCancelledResponse(c)
+        return
+    }
+    editor, err := changerReference(c, services.users, request.ChangedBy)
+    if err != nil {
+        referencesErrorResponse(c, err)
+        return
+    }
+
+    cancelled, err := services.appointments.FindDocuments(c, db_service.Query{
+        Filter: db_service.And(
+            db_service.Eq("seriesid", series.Id),
+            db_service.Gte("datetime", from),
+            db_service.In("status", append(statusesAllowing(statusCancelled), nil)...),
+        ),
+    })
+    if err != nil {
+        c.JSON(
+            http.StatusBadGateway,
+            gin.H{
+                "status":  "Bad Gateway",
+                "message": "Failed to get occurrences of the series",
+                "error":   err.Error(),
+            })
+        return
+    }
+
+    change := StatusChange{
+        Status:    statusCancelled,
+        ChangedAt: time.Now().UTC(),
+        ChangedBy: editor,
+        Reason:    request.Reason,
+    }
+    failedIds := []string{}
+    for i := range cancelled {
+        if err := cancelOccurrence(c, services.appointments, &cancelled[i], change, editor); err != nil {
+            log.Printf("Failed to cancel occurrence %v of series %v: %v", cancelled[i].Id, series.Id, err)
+            failedIds = append(failedIds, cancelled[i].Id)
+        }
+    }
+    // the series ends only when all its occurrences were cancelled, so that
+    // the cancellation can be repeated for the remaining ones
+    if len(failedIds) > 0 {
+        occurrencesNotChangedResponse(c, true, failedIds)
+        return
+    }
+
+    if from.After(series.DateTime) {
+        truncateSeries(series, from)
+    } else {
+        series.Status = seriesStatusCancelled
+    }
+    if err := services.series.ReplaceDocumentIfVersion(c, series.Id, series.Version, series); err != nil {
+        seriesWriteErrorResponse(c, err)
+        return
+    }
+    c.JSON(http.StatusOK, series)
+}
+
+// prepareSeries resolves the references of the series, validates its rule
+// and generates its occurrences. On failure it responds and returns false.
+func (o implAppointmentsAPI) prepareSeries(c *gin.Context, services *seriesServices, series *AppointmentSeries, previous *AppointmentSeries) ([]Appointment, bool) {
+    template := seriesTemplate(series)
+    var previousTemplate *Appointment
+    if previous != nil {
+        previousTemplate = seriesTemplate(previous)
+    }
+    if err := resolveAppointmentReferences(c, services.users, services.locations, template, previousTemplate); err != nil {
+        referencesErrorResponse(c, err)
+        return nil, false
+    }
+    if !takesPart(c, template.Patient.Id, template.Doctor.Id) {
+        forbiddenRecordResponse(c, "only series of the caller can be booked")
+        return nil, false
+    }
+    series.Patient = template.Patient
+    series.Doctor = template.Doctor
+    series.Location = template.Location
+    series.CreatedBy = template.CreatedBy
+
+    occurrences, err := o.seriesOccurrences(series)
+    if err != nil {
+        c.JSON(
+            http.StatusBadRequest,
+            gin.H{
+                "status":  "Bad Request",
+                "message": "Invalid series",
+                "error":   err.Error(),
+            })
+        return nil, false
+    }
+    return occurrences, true
+}
+
+// checkOccurrences verifies that every occurrence is within availability of
+// the doctor and the location and does not conflict with other appointments
+// than the ignored ones. On failure it responds and returns false.
+func (o implAppointmentsAPI) checkOccurrences(c *gin.Context, services *seriesServices, occurrences []Appointment, ignoredIds []string) bool {
+    for i := range occurrences {
+        occurrence := &occurrences[i]
+        if err := checkAvailability(c, services.availability, services.locations, occurrence); err != nil {
+            availabilityCheckResponse(c, err)
+            return false
+        }
+        conflicting, err := findConflictingAppointment(c, services.appointments, occurrence, ignoredIds...)
+        if err != nil {
+            c.JSON(
+                http.StatusBadGateway,
+                gin.H{
+                    "status":  "Bad Gateway",
+                    "message": "Failed to check conflicting appointments",
+                    "error":   err.Error(),
+                })
+            return false
+        }
+        if conflicting != nil {
+            bookingConflictResponse(c, occurrence, conflicting)
+            return false
+        }
+        if !checkSlotHolds(c, occurrence) {
+            return false
+        }
+    }
+    return true
+}
+
+// createOccurrences creates all occurrences or none of them, the occurrences
+// created before a failure are removed again.
+func createOccurrences(ctx context.Context, db db_service.DbService[Appointment], occurrences []Appointment) error {
+    for i := range occurrences {
+        if err := db.CreateDocument(ctx, occurrences[i].Id, &occurrences[i]); err != nil {
+            undoCreated(ctx, db, occurrenceIds(occurrences[:i])...)
+            return err
+        }
+    }
+    return nil
+}
+
+func occurrenceIds(occurrences []Appointment) []string {
+    ids := make([]string, 0, len(occurrences))
+    for _, occurrence := range occurrences {
+        ids = append(ids, occurrence.Id)
+    }
+    return ids
+}
+
+// overlappingOccurrences returns the indexes of the occurrences overlapping
+// the time of the appointment.
+func overlappingOccurrences(occurrences []Appointment, appointment *Appointment) []int {
+    end := appointment.EndDateTime
+    if end.IsZero() {
+        end = appointment.DateTime.Add(legacyAppointmentDuration())
+    }
+    indexes := []int{}
+    for i := range occurrences {
+        if occurrences[i].DateTime.Before(end) && appointment.DateTime.Before(occurrences[i].EndDateTime) {
+            indexes = append(indexes, i)
+        }
+    }
+    return indexes
+}
+
+// occurrencesNotChangedResponse reports occurrences which could not be
+// cancelled. Unless the failure was compensated, the time of some of them is
+// booked twice.
+func occurrencesNotChangedResponse(c *gin.Context, compensated bool, occurrenceIds []string) {
+    if compensated {
+        c.JSON(
+            http.StatusConflict,
+            gin.H{
+                "status":        "Conflict",
+                "message":       "Some occurrences could not be cancelled, they stay booked",
+                "error":         "failed to cancel occurrences " + strings.Join(occurrenceIds, ", "),
+                "occurrenceIds": occurrenceIds,
+            })
+        return
+    }
+    c.JSON(
+        http.StatusInternalServerError,
+        gin.H{
+            "status":        "Internal Server Error",
+            "message":       "Some occurrences could not be cancelled and the new occurrences at their time could not be withdrawn",
+            "error":         "failed to cancel occurrences " + strings.Join(occurrenceIds, ", "),
+            "occurrenceIds": occurrenceIds,
+        })
+}
+
+func occurrencesWriteErrorResponse(c *gin.Context, err error) {
+    c.JSON(
+        http.StatusBadGateway,
+        gin.H{
+            "status":  "Bad Gateway",
+            "message": "Failed to create occurrences of the series in database",
+            "error":   err.Error(),
+        })
+}
+
+// seriesOccurrences generates the appointments of the series.
+func (o implAppointmentsAPI) seriesOccurrences(series *AppointmentSeries) ([]Appointment, error) {
+    starts, err := occurrenceTimes(series)
+    if err != nil {
+        return nil, err
+    }
+
+    occurrences := []Appointment{}
+    for _, start := range starts {
+        occurrence := seriesTemplate(series)
+        occurrence.Id = uuid.New().String()
+        occurrence.DateTime = start
+        occurrence.Status = statusScheduled
+        occurrence.StatusHistory = []StatusChange{{
+            Status:    statusScheduled,
+            ChangedAt: time.Now().UTC(),
+            ChangedBy: series.CreatedBy,
+        }}
+        markCreated(occurrence, series.CreatedBy)
+        if err := o.types.applyDuration(occurrence); err != nil {
+            return nil, err
+        }
+        if err := applyUrgency(occurrence); err != nil {
+            return nil, err
+        }
+        occurrences = append(occurrences, *occurrence)
+    }
+    return occurrences, nil
+}
+
+// occurrenceTimes expands the recurrence rule of the series into start times
+// of its occurrences. The occurrences keep the time of day in the time zone
+// of the series. Monthly occurrences on a day missing in a month, e.g. the
+// 31st, are skipped in that month. Skipped exceptions count into the count
+// of occurrences.
+func occurrenceTimes(series *AppointmentSeries) ([]time.Time, error) {
+    rule := series.Recurrence
+    step, ok := recurrenceFrequencies[rule.Frequency]
+    switch {
+    case !ok:
+        return nil, fmt.Errorf("frequency must be daily, weekly or monthly")
+    case rule.Interval < 0:
+        return nil, fmt.Errorf("interval must be positive")
+    case rule.Count < 0:
+        return nil, fmt.Errorf("count must be positive")
+    case rule.Count == 0 && rule.Until == nil:
+        return nil, fmt.Errorf("either count or until is required")
+    case rule.Count > maxSeriesOccurrences:
+        return nil, fmt.Errorf("series can have at most %v occurrences", maxSeriesOccurrences)
+    case series.DateTime.IsZero():
+        return nil, fmt.Errorf("dateTime is required")
+    }
+    interval := int(rule.Interval)
+    if interval == 0 {
+        interval = 1
+    }
+    location, err := loadTimeZone(series.TimeZone)
+    if err != nil {
+        return nil, fmt.Errorf("unknown time zone %q", series.TimeZone)
+    }
+
+    first := series.DateTime.In(location)
+    starts := []time.Time{}
+    generated := 0
+    for n := 0; rule.Count == 0 || generated < int(rule.Count); n += interval {
+        start := step(first, n)
+        if rule.Until != nil && start.After(*rule.Until) {
+            break
+        }
+        if rule.Frequency == "monthly" && start.Day() != first.Day() {
+            continue
+        }
+        generated++
+        if isRecurrenceException(rule, start) {
+            continue
+        }
+        if len(starts) == maxSeriesOccurrences {
+            return nil, fmt.Errorf("series can have at most %v occurrences", maxSeriesOccurrences)
+        }
+        starts = append(starts, start.UTC())
+    }
+    if len(starts) == 0 {
+        return nil, fmt.Errorf("series has no occurrences")
+    }
+    return starts, nil
+}
+
+func isRecurrenceException(rule RecurrenceRule, start time.Time) bool {
+    for _, exception := range rule.Exceptions {
+        if exception.Equal(start) {
+            return true
+        }
+    }
+    return false
+}
+
+// truncateSeries ends the series with its last occurrence before the time.
+func truncateSeries(series *AppointmentSeries, before time.Time) {
+    starts, _ := occurrenceTimes(series)
+    var last *time.Time
+    for i := range starts {
+        if starts[i].Before(before) {
+            last = &starts[i]
+        }
+    }
+    if last == nil {
+        series.Status = seriesStatusCancelled
+        return
+    }
+    series.Recurrence.Until = last
+    series.Recurrence.Count = 0
+}
+
+// seriesTemplate returns the appointment all occurrences of the series are
+// created from.
+func seriesTemplate(series *AppointmentSeries) *Appointment {
+    return &Appointment{
+        Patient:         series.Patient,
+        Doctor:          series.Doctor,
+        Location:        series.Location,
+        DateTime:        series.DateTime,
+        Type:            series.Type,
+        DurationMinutes: series.DurationMinutes,
+        CreatedBy:       series.CreatedBy,
+        SeriesId:        series.Id,
+    }
+}
+
+func lockSeries(ctx context.Context, series *AppointmentSeries) (func(), error) {
+    return bookingLocks.Lock(ctx, "doctor/"+series.Doctor.Id, "location/"+series.Location.Id)
+}
+
+// cancelOccurrence cancels the occurrence, it is read again and the change is
+// retried when the occurrence was changed concurrently.
+func cancelOccurrence(c *gin.Context, db db_service.DbService[Appointment], appointment *Appointment, change StatusChange, editor User) error {
+    for attempt := 1; ; attempt++ {
+        if !canTransition(currentStatus(appointment), statusCancelled) {
+            return nil
+        }
+        appointment.Status = statusCancelled
+        appointment.StatusHistory = append(appointment.StatusHistory, change)
+        markUpdated(appointment, editor)
+        err := db.ReplaceDocumentIfVersion(c, appointment.Id, appointment.Version, appointment)
+        if err == nil {
+            offerFreedSlot(c, appointment)
+        }
+        if err != db_service.ErrPreconditionFailed || attempt == propagationRetries {
+            return err
+        }
+        if appointment, err = db.FindDocument(c, appointment.Id); err != nil {
+            return err
+        }
+    }
+}
+
+// statusesAllowing returns the statuses from which the appointment may be
+// moved into the target status.
+func statusesAllowing(target string) []interface{} {
+    statuses := []interface{}{}
+    for _, status := range statusTransitions[target] {
+        statuses = append(statuses, status)
+    }
+    return statuses
+}
+
+func seriesFromParameter(c *gin.Context, defaultValue time.Time) (time.Time, error) {
+    from := c.Query("from")
+    if from == "" {
+        return defaultValue, nil
+    }
+    value, err := time.Parse(time.RFC3339, from)
+    if err != nil {
+        return defaultValue, fmt.Errorf("from must be RFC3339 date-time")
+    }
+    return value, nil
+}
+
+func seriesReadErrorResponse(c *gin.Context, err error) {
+    if err == db_service.ErrNotFound {
+        c.JSON(
+            http.StatusNotFound,
+            gin.H{
+                "status":  "Not Found",
+                "message": "Series not found",
+                "error":   err.Error(),
+            })
+        return
+    }
+    c.JSON(
+        http.StatusBadGateway,
+        gin.H{
+            "status":  "Bad Gateway",
+            "message": "Failed to get series from database",
+            "error":   err.Error(),
+        })
+}
+
+func seriesWriteErrorResponse(c *gin.Context, err error) {
+    switch err {
+    case db_service.ErrConflict:
+        c.JSON(
+            http.StatusConflict,
+            gin.H{
+                "status":  "Conflict",
+                "message": "Series already exists",
+                "error":   err.Error(),
+            })
+    case db_service.ErrPreconditionFailed:
+        c.JSON(
+            http.StatusConflict,
+            gin.H{
+                "status":  "Conflict",
+                "message": "Series was changed concurrently, reload it and retry",
+                "error":   err.Error(),
+            })
+    default:
+        c.JSON(
+            http.StatusBadGateway,
+            gin.H{
+                "status":  "Bad Gateway",
+                "message": "Failed to store series in database",
+                "error":   err.Error(),
+            })
+    }
+}
+
+func seriesCancelledResponse(c *gin.Context) {
+    c.JSON(
+        http.StatusConflict,
+        gin.H{
+            "status":  "Conflict",
+            "message": "Series is cancelled",
+            "error":   "cancelled series cannot be changed",
+        })
+}
@@ -0,0 +1,265 @@
+package ambulance_wl
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "slices"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/xpokorny/ambulance-webapi/internal/db_service"
+)
+
+func utc(value string) time.Time {
+    parsed, err := time.Parse(time.RFC3339, value)
+    if err != nil {
+        panic(err)
+    }
+    return parsed.UTC()
+}
+
+func utcPointer(value string) *time.Time {
+    parsed := utc(value)
+    return &parsed
+}
+
+func formatTimes(times []time.Time) string {
+    formatted := make([]string, 0, len(times))
+    for _, value := range times {
+        formatted = append(formatted, value.UTC().Format(time.RFC3339))
+    }
+    return strings.Join(formatted, " ")
+}
+
+func TestOccurrenceTimes(t *testing.T) {
+    tests := []struct {
+        name     string
+        dateTime string
+        timeZone string
+        rule     RecurrenceRule
+        want     string
+    }{
+        {
+            name:     "weekly by count",
+            dateTime: "2030-01-07T09:00:00+01:00",
+            rule:     RecurrenceRule{Frequency: "weekly", Count: 3},
+            want:     "2030-01-07T08:00:00Z 2030-01-14T08:00:00Z 2030-01-21T08:00:00Z",
+        },
+        {
+            name:     "daily with interval until",
+            dateTime: "2030-01-07T09:00:00+01:00",
+            rule:     RecurrenceRule{Frequency: "daily", Interval: 2, Until: utcPointer("2030-01-11T08:00:00Z")},
+            want:     "2030-01-07T08:00:00Z 2030-01-09T08:00:00Z 2030-01-11T08:00:00Z",
+        },
+        {
+            name:     "time of day kept across daylight saving change",
+            dateTime: "2030-03-25T09:00:00+01:00",
+            timeZone: "Europe/Bratislava",
+            rule:     RecurrenceRule{Frequency: "weekly", Count: 2},
+            want:     "2030-03-25T08:00:00Z 2030-04-01T07:00:00Z",
+        },
+        {
+            name:     "monthly skips months without the day",
+            dateTime: "2030-01-31T10:00:00Z",
+            timeZone: "UTC",
+            rule:     RecurrenceRule{Frequency: "monthly", Count: 3},
+            want:     "2030-01-31T10:00:00Z 2030-03-31T10:00:00Z 2030-05-31T10:00:00Z",
+        },
+        {
+            name:     "exceptions count into the count",
+            dateTime: "2030-01-07T09:00:00+01:00",
+            rule: RecurrenceRule{
+                Frequency:  "weekly",
+                Count:      3,
+                Exceptions: []time.Time{utc("2030-01-14T08:00:00Z")},
+            },
+            want: "2030-01-07T08:00:00Z 2030-01-21T08:00:00Z",
+        },
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            series := &AppointmentSeries{DateTime: utc(test.dateTime), TimeZone: test.timeZone, Recurrence: test.rule}
+            if series.TimeZone == "" {
+                series.TimeZone = "Europe/Bratislava"
+            }
+            starts, err := occurrenceTimes(series)
+            if err != nil {
+                t.Fatalf("occurrenceTimes: %v", err)
+            }
+            if got := formatTimes(starts); got != test.want {
+                t.Errorf("occurrenceTimes = %v, want %v", got, test.want)
+            }
+        })
+    }
+}
+
+func TestOccurrenceTimesInvalid(t *testing.T) {
+    start := utc("2030-01-07T08:00:00Z")
+    tests := []struct {
+        name   string
+        series AppointmentSeries
+    }{
+        {"unknown frequency", AppointmentSeries{DateTime: start, Recurrence: RecurrenceRule{Frequency: "yearly", Count: 2}}},
+        {"without count and until", AppointmentSeries{DateTime: start, Recurrence: RecurrenceRule{Frequency: "daily"}}},
+        {"negative interval", AppointmentSeries{DateTime: start, Recurrence: RecurrenceRule{Frequency: "daily", Count: 2, Interval: -1}}},
+        {"too many by count", AppointmentSeries{DateTime: start, Recurrence: RecurrenceRule{Frequency: "daily", Count: maxSeriesOccurrences + 1}}},
+        {"too many by until", AppointmentSeries{DateTime: start, Recurrence: RecurrenceRule{Frequency: "daily", Until: utcPointer("2031-01-07T08:00:00Z")}}},
+        {"until before start", AppointmentSeries{DateTime: start, Recurrence: RecurrenceRule{Frequency: "daily", Until: utcPointer("2030-01-06T08:00:00Z")}}},
+        {"unknown time zone", AppointmentSeries{DateTime: start, TimeZone: "Mars/Olympus", Recurrence: RecurrenceRule{Frequency: "daily", Count: 2}}},
+        {"without dateTime", AppointmentSeries{Recurrence: RecurrenceRule{Frequency: "daily", Count: 2}}},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            if starts, err := occurrenceTimes(&test.series); err == nil {
+                t.Errorf("occurrenceTimes = %v, want an error", formatTimes(starts))
+            }
+        })
+    }
+}
+
+func TestTruncateSeries(t *testing.T) {
+    series := &AppointmentSeries{
+        DateTime:   utc("2030-01-07T08:00:00Z"),
+        TimeZone:   "UTC",
+        Status:     seriesStatusActive,
+        Recurrence: RecurrenceRule{Frequency: "weekly", Count: 4},
+    }
+    truncateSeries(series, utc("2030-01-21T08:00:00Z"))
+    starts, err := occurrenceTimes(series)
+    if err != nil {
+        t.Fatalf("occurrenceTimes of truncated series: %v", err)
+    }
+    if got, want := formatTimes(starts), "2030-01-07T08:00:00Z 2030-01-14T08:00:00Z"; got != want {
+        t.Errorf("occurrences of truncated series = %v, want %v", got, want)
+    }
+    if series.Status != seriesStatusActive {
+        t.Errorf("status of truncated series = %v, want %v", series.Status, seriesStatusActive)
+    }
+
+    truncateSeries(series, series.DateTime)
+    if series.Status != seriesStatusCancelled {
+        t.Errorf("status of series truncated before its start = %v, want %v", series.Status, seriesStatusCancelled)
+    }
+}
+
+// failingAppointments fails all changes of the appointments with the IDs.
+type failingAppointments struct {
+    db_service.DbService[Appointment]
+    failing map[string]bool
+}
+
+func (f failingAppointments) ReplaceDocumentIfVersion(ctx context.Context, id string, version int64, document *Appointment) error {
+    if f.failing[id] {
+        return errors.New("write failed")
+    }
+    return f.DbService.ReplaceDocumentIfVersion(ctx, id, version, document)
+}
+
+// bookTestSeries books three weekly occurrences of testAppointment at 09:00
+// and returns the series with its occurrences in order.
+func bookTestSeries(t *testing.T, server *testServer) (AppointmentSeries, []Appointment) {
+    t.Helper()
+    template := testAppointment("09:00")
+    response := server.call(http.MethodPost, "/api/appointment-series", asReceptionist, AppointmentSeries{
+        Patient:    template.Patient,
+        Doctor:     template.Doctor,
+        Location:   template.Location,
+        DateTime:   template.DateTime,
+        TimeZone:   "Europe/Bratislava",
+        Type:       template.Type,
+        Recurrence: RecurrenceRule{Frequency: "weekly", Count: 3},
+    })
+    if response.Code != http.StatusCreated {
+        t.Fatalf("series = %v %v", response.Code, response.Body.String())
+    }
+    series := decodeResponse[AppointmentSeries](t, response)
+    return series, seriesAppointments(t, server, series.Id)
+}
+
+func seriesAppointments(t *testing.T, server *testServer, seriesId string) []Appointment {
+    t.Helper()
+    appointments, err := server.appointments.FindDocuments(t.Context(), db_service.Query{
+        Filter: db_service.Eq("seriesid", seriesId),
+        Sort:   []db_service.SortField{{Field: "datetime"}},
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+    return appointments
+}
+
+func appointmentStatuses(appointments []Appointment) []string {
+    statuses := []string{}
+    for _, appointment := range appointments {
+        statuses = append(statuses, appointment.Location.Id+" "+appointment.Status)
+    }
+    slices.Sort(statuses)
+    return statuses
+}
+
+func TestSeriesUpdateWithFailedCancellation(t *testing.T) {
+    server := newTestServer(t, true)
+    series, occurrences := bookTestSeries(t, server)
+    server.appointments = failingAppointments{server.appointments, map[string]bool{occurrences[1].Id: true}}
+
+    changed := series
+    changed.Location = Location{Id: "loc2"}
+    response := server.call(http.MethodPut, "/api/appointment-series/"+series.Id, asReceptionist, changed)
+    if response.Code != http.StatusConflict {
+        t.Fatalf("update = %v %v, want 409", response.Code, response.Body.String())
+    }
+    failed := decodeResponse[struct{ OccurrenceIds []string }](t, response)
+    if !slices.Equal(failed.OccurrenceIds, []string{occurrences[1].Id}) {
+        t.Errorf("occurrenceIds = %v, want %v", failed.OccurrenceIds, occurrences[1].Id)
+    }
+
+    // the second week keeps the occurrence which could not be cancelled, its
+    // replacement in loc2 is cancelled instead
+    appointments := seriesAppointments(t, server, series.Id)
+    want := []string{
+        "loc1 cancelled", "loc1 cancelled", "loc1 scheduled",
+        "loc2 cancelled", "loc2 scheduled", "loc2 scheduled",
+    }
+    if statuses := appointmentStatuses(appointments); !slices.Equal(statuses, want) {
+        t.Errorf("occurrences = %v, want %v", statuses, want)
+    }
+    for _, appointment := range appointments {
+        if appointment.Location.Id == "loc2" && appointment.Status == statusCancelled && !appointment.DateTime.Equal(occurrences[1].DateTime) {
+            t.Errorf("cancelled replacement at %v, want at %v", appointment.DateTime, occurrences[1].DateTime)
+        }
+    }
+}
+
+func TestSeriesCancellationWithFailedCancellation(t *testing.T) {
+    server := newTestServer(t, true)
+    series, occurrences := bookTestSeries(t, server)
+    appointments := server.appointments
+    server.appointments = failingAppointments{appointments, map[string]bool{occurrences[1].Id: true}}
+
+    path := "/api/appointment-series/" + series.Id + "/cancel"
+    response := server.call(http.MethodPost, path, asReceptionist, StatusChangeRequest{Reason: "moving"})
+    if response.Code != http.StatusConflict {
+        t.Fatalf("cancel = %v %v, want 409", response.Code, response.Body.String())
+    }
+    if failed := decodeResponse[struct{ OccurrenceIds []string }](t, response); !slices.Equal(failed.OccurrenceIds, []string{occurrences[1].Id}) {
+        t.Errorf("occurrenceIds = %v, want %v", failed.OccurrenceIds, occurrences[1].Id)
+    }
+    if stored, _ := server.series.FindDocument(t.Context(), series.Id); stored.Status != seriesStatusActive {
+        t.Errorf("series status = %v, want it active until all occurrences are cancelled", stored.Status)
+    }
+
+    // the cancellation is repeated once the occurrence can be changed
+    server.appointments = appointments
+    if response := server.call(http.MethodPost, path, asReceptionist, StatusChangeRequest{Reason: "moving"}); response.Code != http.StatusOK {
+        t.Fatalf("repeated cancel = %v %v", response.Code, response.Body.String())
+    }
+    want := []string{"loc1 cancelled", "loc1 cancelled", "loc1 cancelled"}
+    if statuses := appointmentStatuses(seriesAppointments(t, server, series.Id)); !slices.Equal(statuses, want) {
+        t.Errorf("occurrences = %v, want %v", statuses, want)
+    }
+    if stored, _ := server.series.FindDocument(t.Context(), series.Id); stored.Status != seriesStatusCancelled {
+        t.Errorf("series status = %v, want cancelled", stored.Status)
+    }
+}
@@ -0,0 +1,194 @@
+package ambulance_wl
+
+import (
+    "errors"
+    "io"
+    "net/http"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/xpokorny/ambulance-webapi/internal/db_service"
+)
+
+const (
+    statusScheduled  = "scheduled"
+    statusCheckedIn  = "checked-in"
+    statusInProgress = "in-progress"
+    statusCompleted  = "completed"
+    statusCancelled  = "cancelled"
+    statusNoShow     = "no-show"
+)
+
+// statusTransitions lists for every target status the statuses from which
+// the appointment may be moved into it.
+var statusTransitions = map[string][]string{
+    statusCheckedIn:  {statusScheduled},
+    statusInProgress: {statusCheckedIn},
+    statusCompleted:  {statusInProgress},
+    statusCancelled:  {statusScheduled, statusCheckedIn},
+    statusNoShow:     {statusScheduled},
+}
+
+// currentStatus returns the status of the appointment, appointments stored
+// before the status was introduced are scheduled.
+func currentStatus(appointment *Appointment) string {
+    if appointment.Status == "" {
+        return statusScheduled
+    }
+    return appointment.Status
+}
+
+func canTransition(from string, to string) bool {
+    for _, allowed := range statusTransitions[to] {
+        if allowed == from {
+            return true
+        }
+    }
+    return false
+}
+
+// isActive reports whether the appointment still occupies its time slot.
+func isActive(appointment *Appointment) bool {
+    status := currentStatus(appointment)
+    return status != statusCancelled && status != statusNoShow
+}
+
+// activeStatusFilter matches appointments that still occupy their time slot.
+func activeStatusFilter() db_service.Filter {
+    return db_service.And(
+        db_service.Ne("status", statusCancelled),
+        db_service.Ne("status", statusNoShow),
+    )
+}
+
+func (o implAppointmentsAPI) CancelAppointment(c *gin.Context) {
+    o.changeStatus(c, statusCancelled)
+}
+
+func (o implAppointmentsAPI) CheckInAppointment(c *gin.Context) {
+    o.changeStatus(c, statusCheckedIn)
+}
+
+func (o implAppointmentsAPI) CompleteAppointment(c *gin.Context) {
+    o.changeStatus(c, statusCompleted)
+}
+
+func (o implAppointmentsAPI) MarkAppointmentNoShow(c *gin.Context) {
+    o.changeStatus(c, statusNoShow)
+}
+
+func (o implAppointmentsAPI) StartAppointment(c *gin.Context) {
+    o.changeStatus(c, statusInProgress)
+}
+
+// changeStatus moves the appointment into the target status if the state
+// machine allows it and records the change in the status history.
+func (o implAppointmentsAPI) changeStatus(c *gin.Context, target string) {
+    db, ok := dbServiceFromContext[Appointment](c, "appointment_service")
+    if !ok {
+        return
+    }
+    userService, ok := dbServiceFromContext[User](c, "user_service")
+    if !ok {
+        return
+    }
+
+    request, ok := bindStatusChange(c)
+    if !ok {
+        return
+    }
+
+    appointmentId := c.Param("appointmentId")
+    appointment, err := db.FindDocument(c, appointmentId)
+    if err == nil && !takesPart(c, appointment.Patient.Id, appointment.Doctor.Id) {
+        err = db_service.ErrNotFound
+    }
+    if err != nil {
+        switch err {
+        case db_service.ErrNotFound:
+            c.JSON(
+                http.StatusNotFound,
+                gin.H{
+                    "status":  "Not Found",
+                    "message": "Appointment not found",
+                    "error":   err.Error(),
+                })
+        default:
+            c.JSON(
+                http.StatusBadGateway,
+                gin.H{
+                    "status":  "Bad Gateway",
+                    "message": "Failed to get appointment from database",
+                    "error":   err.Error(),
+                })
+        }
+        return
+    }
+
+    from := currentStatus(appointment)
+    if !canTransition(from, target) {
+        c.JSON(
+            http.StatusConflict,
+            gin.H{
+                "status":  "Conflict",
+                "message": "Appointment status does not allow this change",
+                "error":   "cannot change status from " + from + " to " + target,
+            })
+        return
+    }
+
+    editor, err := changerReference(c, userService, request.ChangedBy)
+    if err != nil {
+        referencesErrorResponse(c, err)
+        return
+    }
+
+    appointment.Status = target
+    appointment.StatusHistory = append(appointment.StatusHistory, StatusChange{
+        Status:    target,
+        ChangedAt: time.Now().UTC(),
+        ChangedBy: editor,
+        Reason:    request.Reason,
+    })
+    markUpdated(appointment, editor)
+
+    err = db.ReplaceDocumentIfVersion(c, appointmentId, appointment.Version, appointment)
+    if err == db_service.ErrPreconditionFailed {
+        concurrentChangeResponse(c)
+        return
+    }
+    if err != nil {
+        c.JSON(
+            http.StatusBadGateway,
+            gin.H{
+                "status":  "Bad Gateway",
+                "message": "Failed to update appointment in database",
+                "error":   err.Error(),
+            })
+        return
+    }
+
+    if target == statusCancelled {
+        offerFreedSlot(c, appointment)
+    }
+
+    setETag(c, appointment.Version)
+    c.JSON(http.StatusOK, appointment)
+}
+
+// bindStatusChange reads the optional body of a status change. On failure it
+// responds and returns false.
+func bindStatusChange(c *gin.Context) (StatusChangeRequest, bool) {
+    request := StatusChangeRequest{}
+    if err := c.ShouldBindJSON(&request); err != nil && !errors.Is(err, io.EOF) {
+        c.JSON(
+            http.StatusBadRequest,
+            gin.H{
+                "status":  "Bad Request",
+                "message": "Invalid request body",
+                "error":   err.Error(),
+            })
+        return request, false
+    }
+    return request, true
+}
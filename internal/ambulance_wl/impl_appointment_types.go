@@ -0,0 +1,95 @@
+package ambulance_wl
+
+import (
+    "fmt"
+    "log"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// appointmentTypes holds the default duration of every known appointment
+// type, in minutes.
+type appointmentTypes struct {
+    durations       map[string]int32
+    defaultDuration int32
+}
+
+// loadAppointmentTypes reads the appointment types from the environment.
+// AMBULANCE_API_APPOINTMENT_TYPES is a comma separated list of name=minutes
+// pairs, AMBULANCE_API_DEFAULT_APPOINTMENT_MINUTES is the duration of
+// appointments without a type.
+func loadAppointmentTypes() appointmentTypes {
+    enviro := func(name string, defaultValue string) string {
+        if value, ok := os.LookupEnv(name); ok {
+            return value
+        }
+        return defaultValue
+    }
+
+    types := appointmentTypes{durations: map[string]int32{}}
+
+    minutes, valid := defaultAppointmentMinutes()
+    if !valid {
+        log.Printf("Invalid default appointment duration: %v", os.Getenv("AMBULANCE_API_DEFAULT_APPOINTMENT_MINUTES"))
+    }
+    types.defaultDuration = minutes
+
+    definitions := enviro("AMBULANCE_API_APPOINTMENT_TYPES", "checkup=15,consultation=30,procedure=60")
+    for _, definition := range strings.Split(definitions, ",") {
+        name, minutes, found := strings.Cut(strings.TrimSpace(definition), "=")
+        value, err := strconv.Atoi(strings.TrimSpace(minutes))
+        if !found || name == "" || err != nil || value <= 0 {
+            log.Printf("Invalid appointment type definition: %v", definition)
+            continue
+        }
+        types.durations[strings.TrimSpace(name)] = int32(value)
+    }
+    return types
+}
+
+// defaultAppointmentMinutes returns the duration of appointments without
+// a type set by AMBULANCE_API_DEFAULT_APPOINTMENT_MINUTES, 30 minutes when it
+// is not set or invalid, which is reported as the second value.
+func defaultAppointmentMinutes() (int32, bool) {
+    minutes, ok := os.LookupEnv("AMBULANCE_API_DEFAULT_APPOINTMENT_MINUTES")
+    if !ok {
+        return 30, true
+    }
+    value, err := strconv.Atoi(minutes)
+    if err != nil || value <= 0 {
+        return 30, false
+    }
+    return int32(value), true
+}
+
+// legacyAppointmentDuration is the duration of appointments stored before
+// durations were introduced, which have no end. They last as long as
+// appointments without a type.
+func legacyAppointmentDuration() time.Duration {
+    minutes, _ := defaultAppointmentMinutes()
+    return time.Duration(minutes) * time.Minute
+}
+
+// applyDuration validates the type and duration of the appointment, defaults
+// the duration from its type and computes the end of the appointment.
+func (t appointmentTypes) applyDuration(appointment *Appointment) error {
+    if appointment.DurationMinutes < 0 {
+        return fmt.Errorf("durationMinutes must be positive")
+    }
+    if appointment.Type != "" {
+        if _, ok := t.durations[appointment.Type]; !ok {
+            return fmt.Errorf("unknown appointment type %q", appointment.Type)
+        }
+    }
+    if appointment.DurationMinutes == 0 {
+        if duration, ok := t.durations[appointment.Type]; ok {
+            appointment.DurationMinutes = duration
+        } else {
+            appointment.DurationMinutes = t.defaultDuration
+        }
+    }
+    appointment.EndDateTime = appointment.DateTime.Add(time.Duration(appointment.DurationMinutes) * time.Minute)
+    return nil
+}
@@ -1,7 +1,11 @@
 package ambulance_wl
 
 import (
+    "context"
+    "fmt"
     "net/http"
+    "strings"
+    "time"
 
     "github.com/gin-gonic/gin"
     "github.com/google/uuid"
@@ -9,10 +13,19 @@ import (
 )
 
 type implAppointmentsAPI struct {
+    types appointmentTypes
+}
+
+var appointmentSortFields = sortableFields{
+    "id":       "id",
+    "dateTime": "datetime",
+    "priority": "priority",
 }
 
 func NewAppointmentsAPI() AppointmentsAPI {
-    return &implAppointmentsAPI{}
+    return &implAppointmentsAPI{
+        types: loadAppointmentTypes(),
+    }
 }
 
 func (o implAppointmentsAPI) CreateAppointment(c *gin.Context) {
@@ -58,10 +71,100 @@ func (o implAppointmentsAPI) CreateAppointment(c *gin.Context) {
         appointment.Id = uuid.New().String()
     }
 
+    userService, ok := dbServiceFromContext[User](c, "user_service")
+    if !ok {
+        return
+    }
+    locationService, ok := dbServiceFromContext[Location](c, "location_service")
+    if !ok {
+        return
+    }
+    if err := resolveAppointmentReferences(c, userService, locationService, &appointment, nil); err != nil {
+        referencesErrorResponse(c, err)
+        return
+    }
+    if !takesPart(c, appointment.Patient.Id, appointment.Doctor.Id) {
+        forbiddenRecordResponse(c, "only appointments of the caller can be booked")
+        return
+    }
+    creator, err := creatorReference(c, userService, appointment.CreatedBy)
+    if err != nil {
+        referencesErrorResponse(c, err)
+        return
+    }
+    markCreated(&appointment, creator)
+
+    // new appointments always start as scheduled, status is changed only
+    // by the status transition endpoints
+    appointment.Status = statusScheduled
+    appointment.StatusHistory = []StatusChange{{
+        Status:    statusScheduled,
+        ChangedAt: time.Now().UTC(),
+        ChangedBy: appointment.CreatedBy,
+    }}
+
+    if err := applyUrgency(&appointment); err != nil {
+        c.JSON(
+            http.StatusBadRequest,
+            gin.H{
+                "status":  "Bad Request",
+                "message": "Invalid appointment urgency",
+                "error":   err.Error(),
+            })
+        return
+    }
+
+    if err := o.types.applyDuration(&appointment); err != nil {
+        c.JSON(
+            http.StatusBadRequest,
+            gin.H{
+                "status":  "Bad Request",
+                "message": "Invalid appointment duration",
+                "error":   err.Error(),
+            })
+        return
+    }
+
+    availabilityService, ok := dbServiceFromContext[Availability](c, "availability_service")
+    if !ok {
+        return
+    }
+    if err := checkAvailability(c, availabilityService, locationService, &appointment); err != nil {
+        availabilityCheckResponse(c, err)
+        return
+    }
+
+    unlock, err := lockBooking(c, &appointment)
+    if err != nil {
+        bookingLockResponse(c, err)
+        return
+    }
+    defer unlock()
+
+    conflicting, err := findConflictingAppointment(c, db, &appointment)
+    if err != nil {
+        c.JSON(
+            http.StatusBadGateway,
+            gin.H{
+                "status":  "Bad Gateway",
+                "message": "Failed to check conflicting appointments",
+                "error":   err.Error(),
+            })
+        return
+    }
+    if conflicting != nil {
+        bookingConflictResponse(c, &appointment, conflicting)
+        return
+    }
+    if !checkSlotHolds(c, &appointment) {
+        return
+    }
+
     err = db.CreateDocument(c, appointment.Id, &appointment)
 
     switch err {
     case nil:
+        setETag(c, appointment.Version)
         c.JSON(
             http.StatusCreated,
             appointment,
@@ -113,12 +216,27 @@ func (o implAppointmentsAPI) DeleteAppointment(c *gin.Context) {
         return
     }
 
+    ifMatch, valid := ifMatchVersion(c)
+    if !valid {
+        preconditionFailedResponse(c)
+        return
+    }
+
     appointmentId := c.Param("appointmentId")
-    err := db.DeleteDocument(c, appointmentId)
+    err := checkOwnAppointment(c, db, appointmentId)
+    if err == nil {
+        err = db.SoftDeleteDocument(c, appointmentId, deleterId(c, c.Query("deletedBy")), ifMatch)
+    }
 
     switch err {
     case nil:
+        // the freed time is offered to patients waiting for the doctor
+        if appointment, err := findAppointmentIncludingDeleted(c, db, appointmentId); err == nil && isActive(appointment) {
+            offerFreedSlot(c, appointment)
+        }
         c.AbortWithStatus(http.StatusNoContent)
+    case db_service.ErrPreconditionFailed:
+        preconditionFailedResponse(c)
     case db_service.ErrNotFound:
         c.JSON(
             http.StatusNotFound,
@@ -176,7 +294,21 @@ func (o implAppointmentsAPI) GetAppointment(c *gin.Context) {
         return
     }
 
-    appointment, err := db.FindDocument(c, appointmentId)
+    withDeleted, allowed := includeDeleted(c)
+    if !allowed {
+        return
+    }
+    var appointment *Appointment
+    var err error
+    if withDeleted {
+        appointment, err = findAppointmentIncludingDeleted(c, db, appointmentId)
+    } else {
+        appointment, err = db.FindDocument(c, appointmentId)
+    }
+    // other patients' appointments are hidden as if they did not exist
+    if err == nil && !takesPart(c, appointment.Patient.Id, appointment.Doctor.Id) {
+        err = db_service.ErrNotFound
+    }
     if err != nil {
         switch err {
         case db_service.ErrNotFound:
@@ -199,6 +331,7 @@ func (o implAppointmentsAPI) GetAppointment(c *gin.Context) {
         return
     }
 
+    setETag(c, appointment.Version)
     c.JSON(http.StatusOK, appointment)
 }
 
@@ -228,70 +361,134 @@ func (o implAppointmentsAPI) GetAppointments(c *gin.Context) {
         return
     }
 
-    appointments := []Appointment{}
-    cursor, err := db.FindAllDocuments(c.Request.Context())
+    filter, err := appointmentsFilter(c)
     if err != nil {
         c.JSON(
-            http.StatusInternalServerError,
+            http.StatusBadRequest,
             gin.H{
-                "error":   "failed to get appointments",
-                "message": err.Error(),
-                "status":  "Internal Server Error",
+                "status":  "Bad Request",
+                "message": "Invalid query parameters",
+                "error":   err.Error(),
             })
         return
     }
-    defer cursor.Close(c.Request.Context())
 
-    for cursor.Next(c.Request.Context()) {
-        var appointment Appointment
-        if err := cursor.Decode(&appointment); err != nil {
-            c.JSON(http.StatusInternalServerError, gin.H{
-                "error":   "failed to decode appointment",
-                "message": err.Error(),
-                "status":  "Internal Server Error",
+    query, pageToken, err := unboundedPagingQuery(c, appointmentSortFields, "dateTime")
+    if err != nil {
+        c.JSON(
+            http.StatusBadRequest,
+            gin.H{
+                "status":  "Bad Request",
+                "message": "Invalid paging parameters",
+                "error":   err.Error(),
             })
-            return
-        }
-        appointments = append(appointments, appointment)
+        return
+    }
+    withDeleted, allowed := includeDeleted(c)
+    if !allowed {
+        return
     }
+    query.Filter = db_service.And(filter, ownRecordsFilter(c))
+    query.IncludeDeleted = withDeleted
 
-    userId := c.Query("userId")
-    role := c.Query("role")
-
-    if userId != "" || role != "" {
-        filteredAppointments := []Appointment{}
-        for _, appointment := range appointments {
-            matches := false
-
-            if userId != "" {
-                if appointment.Patient.Id == userId || 
-                   appointment.Doctor.Id == userId || 
-                   appointment.CreatedBy.Id == userId {
-                    matches = true
-                }
-            }
+    appointments, nextPageToken, err := db_service.FindPage(c.Request.Context(), db, query, pageToken)
+    if err != nil {
+        pagingError(c, err, "failed to get appointments")
+        return
+    }
 
-            if role != "" {
-                switch role {
-                case "patient":
-                    if appointment.Patient.Id == userId {
-                        matches = true
-                    }
-                case "creator":
-                    if appointment.CreatedBy.Id == userId {
-                        matches = true
-                    }
-                }
-            }
+    recordReads(c, appointments, func(appointment *Appointment) string { return appointment.Id })
+    setNextPageLink(c, nextPageToken)
+    c.JSON(http.StatusOK, appointments)
+}
 
-            if (userId == "" && role == "") || matches {
-                filteredAppointments = append(filteredAppointments, appointment)
-            }
+func (o implAppointmentsAPI) RestoreAppointment(c *gin.Context) {
+    db, ok := dbServiceFromContext[Appointment](c, "appointment_service")
+    if !ok {
+        return
+    }
+
+    appointmentId := c.Param("appointmentId")
+    appointment, err := findAppointmentIncludingDeleted(c, db, appointmentId)
+    if err == nil && appointment.DeletedAt == nil {
+        err = db_service.ErrNotFound
+    }
+    if err != nil {
+        switch err {
+        case db_service.ErrNotFound:
+            c.JSON(
+                http.StatusNotFound,
+                gin.H{
+                    "status":  "Not Found",
+                    "message": "Deleted appointment not found",
+                    "error":   err.Error(),
+                })
+        default:
+            c.JSON(
+                http.StatusBadGateway,
+                gin.H{
+                    "status":  "Bad Gateway",
+                    "message": "Failed to get appointment from database",
+                    "error":   err.Error(),
+                })
         }
-        appointments = filteredAppointments
+        return
     }
 
-    c.JSON(http.StatusOK, appointments)
+    // the time slot could have been booked while the appointment was deleted
+    unlock, err := lockBooking(c, appointment)
+    if err != nil {
+        bookingLockResponse(c, err)
+        return
+    }
+    defer unlock()
+
+    conflicting, err := findConflictingAppointment(c, db, appointment)
+    if err != nil {
+        c.JSON(
+            http.StatusBadGateway,
+            gin.H{
+                "status":  "Bad Gateway",
+                "message": "Failed to check conflicting appointments",
+                "error":   err.Error(),
+            })
+        return
+    }
+    if conflicting != nil {
+        bookingConflictResponse(c, appointment, conflicting)
+        return
+    }
+    if !checkSlotHolds(c, appointment) {
+        return
+    }
+
+    switch err := db.RestoreDocument(c, appointmentId); err {
+    case nil:
+        appointment.DeletedAt = nil
+        appointment.DeletedBy = ""
+        appointment.Version++
+        // users and location could have been renamed while the appointment
+        // was deleted, deleted appointments are skipped by the propagation
+        propagateAppointmentReferences(c, appointment)
+        setETag(c, appointment.Version)
+        c.JSON(http.StatusOK, appointment)
+    case db_service.ErrNotFound:
+        c.JSON(
+            http.StatusNotFound,
+            gin.H{
+                "status":  "Not Found",
+                "message": "Deleted appointment not found",
+                "error":   err.Error(),
+            })
+    default:
+        c.JSON(
+            http.StatusBadGateway,
+            gin.H{
+                "status":  "Bad Gateway",
+                "message": "Failed to restore appointment in database",
+                "error":   err.Error(),
+            })
+    }
 }
 
 func (o implAppointmentsAPI) UpdateAppointment(c *gin.Context) {
@@ -331,7 +528,16 @@ func (o implAppointmentsAPI) UpdateAppointment(c *gin.Context) {
         return
     }
 
+    ifMatch, valid := ifMatchVersion(c)
+    if !valid {
+        preconditionFailedResponse(c)
+        return
+    }
+
     existingAppointment, err := db.FindDocument(c, appointmentId)
+    if err == nil && !takesPart(c, existingAppointment.Patient.Id, existingAppointment.Doctor.Id) {
+        err = db_service.ErrNotFound
+    }
     if err != nil {
         switch err {
         case db_service.ErrNotFound:
@@ -353,6 +559,10 @@ func (o implAppointmentsAPI) UpdateAppointment(c *gin.Context) {
         }
         return
     }
+    if ifMatch != db_service.AnyVersion && ifMatch != existingAppointment.Version {
+        preconditionFailedResponse(c)
+        return
+    }
 
     var updateData Appointment
     if err := c.ShouldBindJSON(&updateData); err != nil {
@@ -366,55 +576,152 @@ func (o implAppointmentsAPI) UpdateAppointment(c *gin.Context) {
         return
     }
 
-    if updateData.Id != "" {
-        existingAppointment.Id = updateData.Id
+    // the appointment keeps its ID, the ID in the body may only repeat it
+    if updateData.Id != "" && updateData.Id != appointmentId {
+        c.JSON(
+            http.StatusBadRequest,
+            gin.H{
+                "status":  "Bad Request",
+                "message": "Appointment ID cannot be changed",
+                "error":   "id " + updateData.Id + " in the body does not match the appointment " + appointmentId,
+            })
+        return
     }
     if !updateData.DateTime.IsZero() {
         existingAppointment.DateTime = updateData.DateTime
     }
+    if updateData.Type != "" {
+        existingAppointment.Type = updateData.Type
+        // default the duration from the new type unless it is given explicitly
+        existingAppointment.DurationMinutes = 0
+    }
+    if updateData.DurationMinutes != 0 {
+        existingAppointment.DurationMinutes = updateData.DurationMinutes
+    }
+    if updateData.Urgency != "" {
+        existingAppointment.Urgency = updateData.Urgency
+    }
 
+    // only references are taken from the request, the names and roles are
+    // resolved from the stored users and locations
+    previousAppointment := *existingAppointment
     if updateData.Patient.Id != "" {
         existingAppointment.Patient.Id = updateData.Patient.Id
     }
-    if updateData.Patient.Name != "" {
-        existingAppointment.Patient.Name = updateData.Patient.Name
+    if updateData.Doctor.Id != "" {
+        existingAppointment.Doctor.Id = updateData.Doctor.Id
     }
-    if updateData.Patient.Role != "" {
-        existingAppointment.Patient.Role = updateData.Patient.Role
+    if updateData.Location.Id != "" {
+        existingAppointment.Location.Id = updateData.Location.Id
     }
 
-    if updateData.Doctor.Id != "" {
-        existingAppointment.Doctor.Id = updateData.Doctor.Id
+    userService, ok := dbServiceFromContext[User](c, "user_service")
+    if !ok {
+        return
     }
-    if updateData.Doctor.Name != "" {
-        existingAppointment.Doctor.Name = updateData.Doctor.Name
+    locationService, ok := dbServiceFromContext[Location](c, "location_service")
+    if !ok {
+        return
     }
-    if updateData.Doctor.Role != "" {
-        existingAppointment.Doctor.Role = updateData.Doctor.Role
+    if err := resolveAppointmentReferences(c, userService, locationService, existingAppointment, &previousAppointment); err != nil {
+        referencesErrorResponse(c, err)
+        return
     }
-
-    if updateData.Location.Id != "" {
-        existingAppointment.Location.Id = updateData.Location.Id
+    if !takesPart(c, existingAppointment.Patient.Id, existingAppointment.Doctor.Id) {
+        forbiddenRecordResponse(c, "appointment cannot be handed over to other patients or doctors")
+        return
     }
-    if updateData.Location.Name != "" {
-        existingAppointment.Location.Name = updateData.Location.Name
+    editor, err := editorReference(c, userService, User{})
+    if err != nil {
+        referencesErrorResponse(c, err)
+        return
     }
-    if updateData.Location.Address != "" {
-        existingAppointment.Location.Address = updateData.Location.Address
+    markUpdated(existingAppointment, editor)
+
+    if err := applyUrgency(existingAppointment); err != nil {
+        c.JSON(
+            http.StatusBadRequest,
+            gin.H{
+                "status":  "Bad Request",
+                "message": "Invalid appointment urgency",
+                "error":   err.Error(),
+            })
+        return
     }
 
-    if updateData.CreatedBy.Id != "" {
-        existingAppointment.CreatedBy.Id = updateData.CreatedBy.Id
+    if err := o.types.applyDuration(existingAppointment); err != nil {
+        c.JSON(
+            http.StatusBadRequest,
+            gin.H{
+                "status":  "Bad Request",
+                "message": "Invalid appointment duration",
+                "error":   err.Error(),
+            })
+        return
     }
-    if updateData.CreatedBy.Name != "" {
-        existingAppointment.CreatedBy.Name = updateData.CreatedBy.Name
+
+    // appointments booked before a change of working or opening hours stay
+    // valid until they are moved
+    if existingAppointment.Doctor.Id != previousAppointment.Doctor.Id ||
+        existingAppointment.Location.Id != previousAppointment.Location.Id ||
+        !existingAppointment.DateTime.Equal(previousAppointment.DateTime) ||
+        !existingAppointment.EndDateTime.Equal(previousAppointment.EndDateTime) {
+        availabilityService, ok := dbServiceFromContext[Availability](c, "availability_service")
+        if !ok {
+            return
+        }
+        if err := checkAvailability(c, availabilityService, locationService, existingAppointment); err != nil {
+            availabilityCheckResponse(c, err)
+            return
+        }
     }
-    if updateData.CreatedBy.Role != "" {
-        existingAppointment.CreatedBy.Role = updateData.CreatedBy.Role
+
+    unlock, err := lockBooking(c, existingAppointment)
+    if err != nil {
+        bookingLockResponse(c, err)
+        return
     }
+    defer unlock()
 
-    err = db.UpdateDocument(c, appointmentId, existingAppointment)
+    conflicting, err := findConflictingAppointment(c, db, existingAppointment)
     if err != nil {
+        c.JSON(
+            http.StatusBadGateway,
+            gin.H{
+                "status":  "Bad Gateway",
+                "message": "Failed to check conflicting appointments",
+                "error":   err.Error(),
+            })
+        return
+    }
+    if conflicting != nil {
+        bookingConflictResponse(c, existingAppointment, conflicting)
+        return
+    }
+    if !checkSlotHolds(c, existingAppointment) {
+        return
+    }
+
+    // replace only the version read above, so that concurrent changes are
+    // never silently overwritten
+    err = db.ReplaceDocumentIfVersion(c, appointmentId, existingAppointment.Version, existingAppointment)
+    switch {
+    case err == db_service.ErrPreconditionFailed && ifMatch != db_service.AnyVersion:
+        preconditionFailedResponse(c)
+        return
+    case err == db_service.ErrPreconditionFailed:
+        concurrentChangeResponse(c)
+        return
+    case err == db_service.ErrNotFound:
+        c.JSON(
+            http.StatusNotFound,
+            gin.H{
+                "status":  "Not Found",
+                "message": "Appointment not found",
+                "error":   err.Error(),
+            })
+        return
+    case err != nil:
         c.JSON(
             http.StatusBadGateway,
             gin.H{
@@ -425,5 +732,132 @@ func (o implAppointmentsAPI) UpdateAppointment(c *gin.Context) {
         return
     }
 
+    setETag(c, existingAppointment.Version)
     c.JSON(http.StatusOK, existingAppointment)
-}
\ No newline at end of file
+}
+
+// appointmentsFilter translates query parameters of GetAppointments into
+// a database filter so that only matching appointments are loaded.
+func appointmentsFilter(c *gin.Context) (db_service.Filter, error) {
+    filters := []db_service.Filter{}
+
+    userId := c.Query("userId")
+    switch role := c.Query("role"); role {
+    case "":
+        if userId != "" {
+            filters = append(filters, db_service.Or(
+                db_service.Eq("patient.id", userId),
+                db_service.Eq("doctor.id", userId),
+                db_service.Eq("createdby.id", userId),
+            ))
+        }
+    case "patient", "doctor", "creator":
+        if userId == "" {
+            return db_service.Filter{}, fmt.Errorf("role filter requires userId")
+        }
+        field := role + ".id"
+        if role == "creator" {
+            field = "createdby.id"
+        }
+        filters = append(filters, db_service.Eq(field, userId))
+    default:
+        return db_service.Filter{}, fmt.Errorf("unknown role %q", role)
+    }
+
+    if status := c.Query("status"); status != "" {
+        statuses := []interface{}{}
+        for _, value := range strings.Split(status, ",") {
+            value = strings.TrimSpace(value)
+            if _, known := statusTransitions[value]; !known && value != statusScheduled {
+                return db_service.Filter{}, fmt.Errorf("unknown status %q", value)
+            }
+            statuses = append(statuses, value)
+            if value == statusScheduled {
+                // appointments stored before statuses were introduced
+                statuses = append(statuses, nil)
+            }
+        }
+        filters = append(filters, db_service.In("status", statuses...))
+    }
+
+    if urgency := c.Query("urgency"); urgency != "" {
+        filter, err := urgencyFilter("urgency", urgency)
+        if err != nil {
+            return db_service.Filter{}, err
+        }
+        filters = append(filters, filter)
+    }
+
+    if doctorId := c.Query("doctorId"); doctorId != "" {
+        filters = append(filters, db_service.Eq("doctor.id", doctorId))
+    }
+    if locationId := c.Query("locationId"); locationId != "" {
+        filters = append(filters, db_service.Eq("location.id", locationId))
+    }
+    if seriesId := c.Query("seriesId"); seriesId != "" {
+        filters = append(filters, db_service.Eq("seriesid", seriesId))
+    }
+
+    if from := c.Query("from"); from != "" {
+        fromTime, err := time.Parse(time.RFC3339, from)
+        if err != nil {
+            return db_service.Filter{}, fmt.Errorf("invalid from date-time: %w", err)
+        }
+        filters = append(filters, db_service.Gte("datetime", fromTime))
+    }
+    if to := c.Query("to"); to != "" {
+        toTime, err := time.Parse(time.RFC3339, to)
+        if err != nil {
+            return db_service.Filter{}, fmt.Errorf("invalid to date-time: %w", err)
+        }
+        filters = append(filters, db_service.Lt("datetime", toTime))
+    }
+
+    return db_service.And(filters...), nil
+}
+
+// markCreated records who created the appointment and when, the creation is
+// also its first change.
+func markCreated(appointment *Appointment, creator User) {
+    now := time.Now().UTC()
+    appointment.CreatedBy = creator
+    appointment.CreatedAt = now
+    appointment.UpdatedBy = creator
+    appointment.UpdatedAt = now
+}
+
+// markUpdated records who changed the appointment last and when.
+func markUpdated(appointment *Appointment, editor User) {
+    appointment.UpdatedBy = editor
+    appointment.UpdatedAt = time.Now().UTC()
+}
+
+// checkOwnAppointment returns ErrNotFound when the caller is restricted to own
+// appointments and the appointment is not one of them.
+func checkOwnAppointment(c *gin.Context, db db_service.DbService[Appointment], appointmentId string) error {
+    if _, restricted := ownRecordsOnly(c); !restricted {
+        return nil
+    }
+    appointment, err := db.FindDocument(c, appointmentId)
+    if err == nil && !takesPart(c, appointment.Patient.Id, appointment.Doctor.Id) {
+        err = db_service.ErrNotFound
+    }
+    return err
+}
+
+// findAppointmentIncludingDeleted finds the appointment even if it is soft
+// deleted, which FindDocument hides.
+func findAppointmentIncludingDeleted(ctx context.Context, db db_service.DbService[Appointment], appointmentId string) (*Appointment, error) {
+    appointments, err := db.FindDocuments(ctx, db_service.Query{
+        Filter:         db_service.Eq("id", appointmentId),
+        Limit:          1,
+        IncludeDeleted: true,
+    })
+    if err != nil {
+        return nil, err
+    }
+    if len(appointments) == 0 {
+        return nil, db_service.ErrNotFound
+    }
+    return &appointments[0], nil
+}
@@ -0,0 +1,290 @@
+package ambulance_wl
+
+import (
+    "net/http"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestAppointmentStatusTransitions(t *testing.T) {
+    server := newTestServer(t, true)
+    appointment := server.book(t, asPatient, testAppointment("09:00"))
+    path := "/api/appointments/" + appointment.Id
+
+    steps := []struct {
+        name   string
+        action string
+        caller string
+        status int
+        want   string
+    }{
+        {"patient cannot check in", "check-in", asPatient, http.StatusForbidden, statusScheduled},
+        {"start before check-in", "start", asDoctor, http.StatusConflict, statusScheduled},
+        {"check in", "check-in", asReceptionist, http.StatusOK, statusCheckedIn},
+        {"no-show after check-in", "no-show", asDoctor, http.StatusConflict, statusCheckedIn},
+        {"other doctor cannot start", "start", asOtherDoctor, http.StatusNotFound, statusCheckedIn},
+        {"start", "start", asDoctor, http.StatusOK, statusInProgress},
+        {"cancel in progress", "cancel", asPatient, http.StatusConflict, statusInProgress},
+        {"complete", "complete", asDoctor, http.StatusOK, statusCompleted},
+        {"cancel completed", "cancel", asReceptionist, http.StatusConflict, statusCompleted},
+    }
+    for _, step := range steps {
+        response := server.call(http.MethodPost, path+"/"+step.action, step.caller, nil)
+        if response.Code != step.status {
+            t.Fatalf("%v: status = %v, want %v, body %v", step.name, response.Code, step.status, response.Body.String())
+        }
+        stored, _ := server.appointments.FindDocument(t.Context(), appointment.Id)
+        if stored.Status != step.want {
+            t.Fatalf("%v: appointment status = %v, want %v", step.name, stored.Status, step.want)
+        }
+    }
+
+    // every change is recorded with the caller who made it
+    stored, _ := server.appointments.FindDocument(t.Context(), appointment.Id)
+    history := []string{}
+    for _, change := range stored.StatusHistory {
+        history = append(history, change.Status+" by "+change.ChangedBy.Id)
+    }
+    want := "scheduled by user1, checked-in by recep, in-progress by user5, completed by user5"
+    if got := strings.Join(history, ", "); got != want {
+        t.Errorf("status history = %v, want %v", got, want)
+    }
+}
+
+func TestAppointmentCancellationFreesTheSlot(t *testing.T) {
+    server := newTestServer(t, true)
+    appointment := server.book(t, asPatient, testAppointment("09:00"))
+
+    other := testAppointment("09:00")
+    other.Patient.Id = "user2"
+    if response := server.call(http.MethodPost, "/api/appointments", asOtherPatient, other); response.Code != http.StatusConflict {
+        t.Fatalf("booking of booked time = %v, want 409", response.Code)
+    }
+    response := server.call(http.MethodPost, "/api/appointments/"+appointment.Id+"/cancel", asPatient, StatusChangeRequest{Reason: "ill"})
+    if response.Code != http.StatusOK {
+        t.Fatalf("cancel = %v %v", response.Code, response.Body.String())
+    }
+    if cancelled := decodeResponse[Appointment](t, response); cancelled.StatusHistory[1].Reason != "ill" {
+        t.Errorf("status history = %+v, want the reason of the cancellation", cancelled.StatusHistory)
+    }
+    server.book(t, asOtherPatient, other)
+}
+
+func TestAppointmentSoftDeleteAndRestore(t *testing.T) {
+    server := newTestServer(t, true)
+    appointment := server.book(t, asPatient, testAppointment("09:00"))
+    path := "/api/appointments/" + appointment.Id
+
+    if response := server.call(http.MethodDelete, path, asPatient, nil); response.Code != http.StatusForbidden {
+        t.Errorf("delete by patient = %v, want 403", response.Code)
+    }
+    if response := server.call(http.MethodDelete, path+"?deletedBy=user6", asDoctor, nil); response.Code != http.StatusNoContent {
+        t.Fatalf("delete = %v %v", response.Code, response.Body.String())
+    }
+    if response := server.call(http.MethodDelete, path, asDoctor, nil); response.Code != http.StatusNotFound {
+        t.Errorf("second delete = %v, want 404", response.Code)
+    }
+    if response := server.call(http.MethodGet, path, asReceptionist, nil); response.Code != http.StatusNotFound {
+        t.Errorf("get of deleted appointment = %v, want 404", response.Code)
+    }
+    listed := decodeResponse[[]Appointment](t, server.call(http.MethodGet, "/api/appointments", asReceptionist, nil))
+    if len(listed) != 0 {
+        t.Errorf("appointments = %+v, want none", listed)
+    }
+
+    if response := server.call(http.MethodGet, path+"?includeDeleted=true", asDoctor, nil); response.Code != http.StatusForbidden {
+        t.Errorf("get including deleted by doctor = %v, want 403", response.Code)
+    }
+    response := server.call(http.MethodGet, path+"?includeDeleted=true", asAdmin, nil)
+    if response.Code != http.StatusOK {
+        t.Fatalf("get including deleted = %v %v", response.Code, response.Body.String())
+    }
+    // the deleter is the caller, not the one claimed in the query
+    if deleted := decodeResponse[Appointment](t, response); deleted.DeletedAt == nil || deleted.DeletedBy != "user5" {
+        t.Errorf("deleted appointment = %+v, want deleted by user5", deleted)
+    }
+
+    // the time of the deleted appointment is free, it cannot be restored
+    // until the new booking is gone
+    replacement := server.book(t, asPatient, testAppointment("09:15"))
+    if response := server.call(http.MethodPost, path+"/restore", asAdmin, nil); response.Code != http.StatusConflict {
+        t.Errorf("restore into booked time = %v, want 409", response.Code)
+    }
+    server.call(http.MethodDelete, "/api/appointments/"+replacement.Id, asReceptionist, nil)
+    response = server.call(http.MethodPost, path+"/restore", asAdmin, nil)
+    if response.Code != http.StatusOK {
+        t.Fatalf("restore = %v %v", response.Code, response.Body.String())
+    }
+    if restored := decodeResponse[Appointment](t, response); restored.DeletedAt != nil || restored.DeletedBy != "" {
+        t.Errorf("restored appointment = %+v", restored)
+    }
+    if response := server.call(http.MethodGet, path, asPatient, nil); response.Code != http.StatusOK {
+        t.Errorf("get of restored appointment = %v, want 200", response.Code)
+    }
+    if response := server.call(http.MethodPost, path+"/restore", asAdmin, nil); response.Code != http.StatusNotFound {
+        t.Errorf("restore of not deleted appointment = %v, want 404", response.Code)
+    }
+}
+
+func TestAppointmentETags(t *testing.T) {
+    server := newTestServer(t, true)
+    response := server.call(http.MethodPost, "/api/appointments", asPatient, testAppointment("09:00"))
+    if response.Code != http.StatusCreated || response.Header().Get("ETag") != `"1"` {
+        t.Fatalf("create = %v with ETag %v", response.Code, response.Header().Get("ETag"))
+    }
+    path := "/api/appointments/" + decodeResponse[Appointment](t, response).Id
+    change := Appointment{DurationMinutes: 45}
+
+    steps := []struct {
+        name    string
+        method  string
+        ifMatch string
+        body    interface{}
+        status  int
+        etag    string
+    }{
+        {"get", http.MethodGet, "", nil, http.StatusOK, `"1"`},
+        {"update current version", http.MethodPut, `"1"`, change, http.StatusOK, `"2"`},
+        {"update stale version", http.MethodPut, `"1"`, change, http.StatusPreconditionFailed, ""},
+        {"update weak current version", http.MethodPut, `W/"2"`, change, http.StatusOK, `"3"`},
+        {"update malformed version", http.MethodPut, "two", change, http.StatusPreconditionFailed, ""},
+        {"update any version", http.MethodPut, "*", change, http.StatusOK, `"4"`},
+        {"update without If-Match", http.MethodPut, "", change, http.StatusOK, `"5"`},
+        {"update of the id", http.MethodPut, "", Appointment{Id: "other"}, http.StatusBadRequest, ""},
+        {"delete stale version", http.MethodDelete, `"4"`, nil, http.StatusPreconditionFailed, ""},
+        {"delete current version", http.MethodDelete, `"5"`, nil, http.StatusNoContent, ""},
+    }
+    for _, step := range steps {
+        headers := []string{}
+        if step.ifMatch != "" {
+            headers = append(headers, "If-Match", step.ifMatch)
+        }
+        response := server.call(step.method, path, asReceptionist, step.body, headers...)
+        if response.Code != step.status {
+            t.Fatalf("%v: status = %v, want %v, body %v", step.name, response.Code, step.status, response.Body.String())
+        }
+        if response.Header().Get("ETag") != step.etag {
+            t.Errorf("%v: ETag = %v, want %v", step.name, response.Header().Get("ETag"), step.etag)
+        }
+    }
+}
+
+func TestAppointmentCreatedBy(t *testing.T) {
+    tests := []struct {
+        name          string
+        authenticated bool
+        caller        string
+        claimed       User
+        status        int
+        want          User
+    }{
+        {"caller overrides the claim", true, asPatient, User{Id: "user2"}, http.StatusCreated, User{Id: "user1", Name: "John Doe", Role: rolePatient}},
+        {"staff booking for a patient", true, asReceptionist, User{}, http.StatusCreated, User{Id: "recep", Name: "Mary Front", Role: roleReceptionist}},
+        {"caller known only to the identity provider", true, asAdmin, User{}, http.StatusCreated, User{Id: "admin", Role: roleAdmin}},
+        {"claim without authentication", false, anonymous, User{Id: "user2", Name: "Made Up"}, http.StatusCreated, User{Id: "user2", Name: "Jane Smith", Role: rolePatient}},
+        {"no claim without authentication", false, anonymous, User{}, http.StatusUnprocessableEntity, User{}},
+        {"unknown claim without authentication", false, anonymous, User{Id: "nobody"}, http.StatusUnprocessableEntity, User{}},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            server := newTestServer(t, test.authenticated)
+            appointment := testAppointment("09:00")
+            appointment.CreatedBy = test.claimed
+            response := server.call(http.MethodPost, "/api/appointments", test.caller, appointment)
+            if response.Code != test.status {
+                t.Fatalf("status = %v, want %v, body %v", response.Code, test.status, response.Body.String())
+            }
+            if test.status != http.StatusCreated {
+                return
+            }
+            created := decodeResponse[Appointment](t, response)
+            if created.CreatedBy != test.want || created.UpdatedBy != test.want || created.StatusHistory[0].ChangedBy != test.want {
+                t.Errorf("createdBy = %+v, updatedBy = %+v, history = %+v, want %+v", created.CreatedBy, created.UpdatedBy, created.StatusHistory, test.want)
+            }
+        })
+    }
+}
+
+func TestAppointmentReferences(t *testing.T) {
+    server := newTestServer(t, true)
+    if response := server.call(http.MethodDelete, "/api/locations/loc2", asReceptionist, nil); response.Code != http.StatusNoContent {
+        t.Fatalf("retiring location = %v %v", response.Code, response.Body.String())
+    }
+
+    tests := []struct {
+        name   string
+        change func(appointment *Appointment)
+        status int
+        error  string
+    }{
+        {"stored names replace the given ones", func(appointment *Appointment) {
+            appointment.Patient.Name = "Made Up"
+            appointment.Doctor.Role = rolePatient
+            appointment.Location.Address = "Nowhere"
+        }, http.StatusCreated, ""},
+        {"unknown patient", func(appointment *Appointment) { appointment.Patient.Id = "nobody" }, http.StatusUnprocessableEntity, "patient nobody does not exist"},
+        {"doctor as patient", func(appointment *Appointment) { appointment.Patient.Id = "user6" }, http.StatusUnprocessableEntity, "patient user6 is not a patient"},
+        {"patient as doctor", func(appointment *Appointment) { appointment.Doctor.Id = "user2" }, http.StatusUnprocessableEntity, "doctor user2 is not a doctor"},
+        {"deactivated patient", func(appointment *Appointment) { appointment.Patient.Id = "user3" }, http.StatusUnprocessableEntity, "patient user3 is deactivated"},
+        {"without doctor", func(appointment *Appointment) { appointment.Doctor = User{} }, http.StatusUnprocessableEntity, "doctor.id is required"},
+        {"unknown location", func(appointment *Appointment) { appointment.Location.Id = "loc9" }, http.StatusUnprocessableEntity, "location loc9 does not exist"},
+        {"retired location", func(appointment *Appointment) { appointment.Location.Id = "loc2" }, http.StatusUnprocessableEntity, "location loc2 is retired"},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            appointment := testAppointment("09:00")
+            test.change(&appointment)
+            response := server.call(http.MethodPost, "/api/appointments", asReceptionist, appointment)
+            if response.Code != test.status {
+                t.Fatalf("status = %v, want %v, body %v", response.Code, test.status, response.Body.String())
+            }
+            if test.error != "" && !strings.Contains(response.Body.String(), test.error) {
+                t.Errorf("body = %v, want error %q", response.Body.String(), test.error)
+            }
+            if test.status != http.StatusCreated {
+                return
+            }
+            created := decodeResponse[Appointment](t, response)
+            if created.Patient != (User{Id: "user1", Name: "John Doe", Role: rolePatient}) ||
+                created.Doctor != (User{Id: "user5", Name: "Dr. Michael Brown", Role: roleDoctor}) ||
+                created.Location.Address != "Main Building, Floor 1" {
+                t.Errorf("references = %+v %+v %+v", created.Patient, created.Doctor, created.Location)
+            }
+        })
+    }
+}
+
+func TestReferenceChangesPropagate(t *testing.T) {
+    server := newTestServer(t, true)
+    appointment := server.book(t, asPatient, testAppointment("09:00"))
+
+    response := server.call(http.MethodPut, "/api/users/user1", asReceptionist, User{Name: "John Smith", Role: rolePatient})
+    if response.Code != http.StatusOK {
+        t.Fatalf("user update = %v %v", response.Code, response.Body.String())
+    }
+    response = server.call(http.MethodPut, "/api/locations/loc1", asReceptionist, Location{Name: "Room 111", Address: "Main Building, Floor 1"})
+    if response.Code != http.StatusOK {
+        t.Fatalf("location update = %v %v", response.Code, response.Body.String())
+    }
+
+    // the references are updated in the background
+    deadline := time.Now().Add(5 * time.Second)
+    for {
+        stored, err := server.appointments.FindDocument(t.Context(), appointment.Id)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if stored.Patient.Name == "John Smith" && stored.CreatedBy.Name == "John Smith" && stored.Location.Name == "Room 111" {
+            // the status history keeps the name at the time of the change
+            if stored.StatusHistory[0].ChangedBy.Name != "John Doe" {
+                t.Errorf("status history = %+v, want the name at the time of booking", stored.StatusHistory)
+            }
+            return
+        }
+        if time.Now().After(deadline) {
+            t.Fatalf("references were not propagated: %+v %+v %+v", stored.Patient, stored.CreatedBy, stored.Location)
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+}
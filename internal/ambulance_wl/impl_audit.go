@@ -0,0 +1,260 @@
+package ambulance_wl
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "sync"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/google/uuid"
+    "github.com/xpokorny/ambulance-webapi/internal/auth"
+    "github.com/xpokorny/ambulance-webapi/internal/db_service"
+)
+
+const (
+    auditActionRead  = "read"
+    auditActionWrite = "write"
+
+    // actor of changes made by background jobs, e.g. expiring slot offers
+    auditSystemActor = "system"
+    // actor of requests when authentication is disabled
+    auditAnonymousActor = "anonymous"
+)
+
+var auditSortFields = sortableFields{
+    "id":        "id",
+    "timestamp": "timestamp",
+}
+
+type auditContextKey struct{}
+
+// auditRecord collects the changes made and the records listed while a
+// request is handled.
+type auditRecord struct {
+    lock    sync.Mutex
+    changes []AuditChange
+    reads   []string
+}
+
+func (r *auditRecord) add(change AuditChange) {
+    r.lock.Lock()
+    defer r.lock.Unlock()
+    r.changes = append(r.changes, change)
+}
+
+func (r *auditRecord) addRead(ids ...string) {
+    r.lock.Lock()
+    defer r.lock.Unlock()
+    r.reads = append(r.reads, ids...)
+}
+
+// recordReads adds the records returned by a list endpoint to the audit entry
+// of the request, a list has no path parameter naming the records it read.
+func recordReads[T interface{}](c *gin.Context, records []T, id func(record *T) string) {
+    record, ok := c.Request.Context().Value(auditContextKey{}).(*auditRecord)
+    if !ok {
+        return
+    }
+    ids := make([]string, 0, len(records))
+    for i := range records {
+        ids = append(ids, id(&records[i]))
+    }
+    record.addRead(ids...)
+}
+
+type implAuditAPI struct {
+}
+
+func NewAuditAPI() AuditAPI {
+    return &implAuditAPI{}
+}
+
+func (api *implAuditAPI) GetAuditEntries(c *gin.Context) {
+    auditService, ok := dbServiceFromContext[AuditEntry](c, "audit_service")
+    if !ok {
+        return
+    }
+
+    query, pageToken, err := pagingQuery(c, auditSortFields, "-timestamp")
+    if err != nil {
+        invalidAuditQueryResponse(c, err)
+        return
+    }
+
+    filters := []db_service.Filter{}
+    if actorId := c.Query("actorId"); actorId != "" {
+        filters = append(filters, db_service.Eq("actorid", actorId))
+    }
+    if resourceId := c.Query("resourceId"); resourceId != "" {
+        filters = append(filters, db_service.Eq("resourceids", resourceId))
+    }
+    if from := c.Query("from"); from != "" {
+        fromTime, err := time.Parse(time.RFC3339, from)
+        if err != nil {
+            invalidAuditQueryResponse(c, fmt.Errorf("invalid from date-time: %w", err))
+            return
+        }
+        filters = append(filters, db_service.Gte("timestamp", fromTime))
+    }
+    if to := c.Query("to"); to != "" {
+        toTime, err := time.Parse(time.RFC3339, to)
+        if err != nil {
+            invalidAuditQueryResponse(c, fmt.Errorf("invalid to date-time: %w", err))
+            return
+        }
+        filters = append(filters, db_service.Lt("timestamp", toTime))
+    }
+    query.Filter = db_service.And(filters...)
+
+    entries, nextPageToken, err := db_service.FindPage(c.Request.Context(), auditService, query, pageToken)
+    if err != nil {
+        pagingError(c, err, "failed to get audit log")
+        return
+    }
+
+    setNextPageLink(c, nextPageToken)
+    c.JSON(http.StatusOK, entries)
+}
+
+func invalidAuditQueryResponse(c *gin.Context, err error) {
+    c.JSON(http.StatusBadRequest, gin.H{
+        "error":   err.Error(),
+        "message": "invalid query parameters",
+        "status":  "Bad Request",
+    })
+}
+
+// NewAuditMiddleware stores an audit entry for every call of the routes of
+// the handle functions, with the changes the auditing db services reported
+// while the request was handled. It runs before the authentication, so that
+// rejected authentication and authorization are recorded too, the caller is
+// read from the context after the request was handled.
+func NewAuditMiddleware(handleFunctions ApiHandleFunctions, auditService db_service.DbService[AuditEntry]) gin.HandlerFunc {
+    names := routeNames(handleFunctions)
+
+    return func(c *gin.Context) {
+        name, known := names[c.Request.Method+" "+c.FullPath()]
+        if !known {
+            c.Next()
+            return
+        }
+
+        record := &auditRecord{}
+        c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), auditContextKey{}, record))
+        c.Next()
+
+        actorId, actorRole, authenticated := auth.Caller(c)
+        if !authenticated {
+            actorId = auditAnonymousActor
+        }
+        action := auditActionWrite
+        if c.Request.Method == http.MethodGet {
+            action = auditActionRead
+        }
+        resourceId := ""
+        if len(c.Params) > 0 {
+            // the last parameter names the record, e.g. the notification of
+            // /users/:userId/notifications/:notificationId
+            resourceId = c.Params[len(c.Params)-1].Value
+        }
+
+        record.lock.Lock()
+        entry := AuditEntry{
+            Id:          uuid.New().String(),
+            Timestamp:   time.Now().UTC(),
+            ActorId:     actorId,
+            ActorRole:   actorRole,
+            Action:      action,
+            RouteName:   name,
+            Method:      c.Request.Method,
+            Path:        c.Request.URL.RequestURI(),
+            ResourceId:  resourceId,
+            ResourceIds: auditResourceIds(resourceId, record.reads, record.changes),
+            ClientIp:    c.ClientIP(),
+            StatusCode:  int32(c.Writer.Status()),
+            Changes:     record.changes,
+        }
+        record.lock.Unlock()
+        storeAuditEntry(auditService, &entry)
+    }
+}
+
+// NewAuditRecorder returns the recorder of the changes reported by the
+// auditing db services. Changes made while a request is handled are added to
+// its audit entry, changes made by background jobs are stored as entries of
+// the system actor.
+func NewAuditRecorder(auditService db_service.DbService[AuditEntry]) db_service.ChangeRecorder {
+    return func(ctx context.Context, change db_service.Change) {
+        auditChange := AuditChange{
+            Collection: change.Collection,
+            DocumentId: change.DocumentId,
+            Operation:  change.Operation,
+        }
+        for _, field := range change.Diff {
+            auditChange.Diff = append(auditChange.Diff, AuditFieldChange{
+                Field:  field.Field,
+                Before: auditValue(field.Before),
+                After:  auditValue(field.After),
+            })
+        }
+
+        if c, ok := ctx.(*gin.Context); ok && c.Request != nil {
+            ctx = c.Request.Context()
+        }
+        if record, ok := ctx.Value(auditContextKey{}).(*auditRecord); ok {
+            record.add(auditChange)
+            return
+        }
+
+        storeAuditEntry(auditService, &AuditEntry{
+            Id:          uuid.New().String(),
+            Timestamp:   time.Now().UTC(),
+            ActorId:     auditSystemActor,
+            Action:      auditActionWrite,
+            ResourceIds: []string{change.DocumentId},
+            Changes:     []AuditChange{auditChange},
+        })
+    }
+}
+
+// storeAuditEntry stores the entry even when the request was cancelled, a
+// failure is logged as the response was already sent.
+func storeAuditEntry(auditService db_service.DbService[AuditEntry], entry *AuditEntry) {
+    if err := auditService.CreateDocument(context.Background(), entry.Id, entry); err != nil {
+        log.Printf("Failed to store audit entry of %v %v by %v: %v", entry.Method, entry.Path, entry.ActorId, err)
+    }
+}
+
+func auditResourceIds(resourceId string, reads []string, changes []AuditChange) []string {
+    ids := []string{}
+    seen := map[string]bool{"": true}
+    for _, id := range append([]string{resourceId}, reads...) {
+        if !seen[id] {
+            seen[id] = true
+            ids = append(ids, id)
+        }
+    }
+    for _, change := range changes {
+        if !seen[change.DocumentId] {
+            seen[change.DocumentId] = true
+            ids = append(ids, change.DocumentId)
+        }
+    }
+    return ids
+}
+
+// auditValue returns the JSON encoding of a changed value, empty for nil.
+func auditValue(value interface{}) string {
+    if value == nil {
+        return ""
+    }
+    encoded, err := json.Marshal(value)
+    if err != nil {
+        return fmt.Sprint(value)
+    }
+    return string(encoded)
+}
@@ -0,0 +1,50 @@
+package ambulance_wl
+
+import (
+    "maps"
+    "net/http"
+    "testing"
+)
+
+func TestAuditEntriesByResource(t *testing.T) {
+    server := newTestServer(t, true)
+    appointment := server.book(t, asPatient, testAppointment("09:00"))
+    other := testAppointment("10:00")
+    other.Patient.Id = "user2"
+    server.book(t, asOtherPatient, other)
+
+    requests := []struct {
+        name   string
+        path   string
+        caller string
+    }{
+        {"GetAppointment", "/api/appointments/" + appointment.Id, asDoctor},
+        {"GetAppointments", "/api/appointments", asReceptionist},
+        {"GetAppointments", "/api/appointments?seriesId=none", asAdmin},
+        {"GetAppointments", "/api/appointments", asOtherPatient},
+    }
+    for _, request := range requests {
+        if response := server.call(http.MethodGet, request.path, request.caller, nil); response.Code != http.StatusOK {
+            t.Fatalf("%v = %v %v", request.path, response.Code, response.Body.String())
+        }
+    }
+
+    response := server.call(http.MethodGet, "/api/audit?resourceId="+appointment.Id, asAdmin, nil)
+    if response.Code != http.StatusOK {
+        t.Fatalf("audit = %v %v", response.Code, response.Body.String())
+    }
+    // the booking, the read of the appointment and the list containing it,
+    // lists not returning the appointment did not read it
+    want := map[string]bool{
+        "CreateAppointment by user1": true,
+        "GetAppointment by user5":    true,
+        "GetAppointments by recep":   true,
+    }
+    found := map[string]bool{}
+    for _, entry := range decodeResponse[[]AuditEntry](t, response) {
+        found[entry.RouteName+" by "+entry.ActorId] = true
+    }
+    if !maps.Equal(found, want) {
+        t.Errorf("audit entries of the appointment = %v, want %v", found, want)
+    }
+}
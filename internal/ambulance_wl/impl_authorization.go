@@ -0,0 +1,306 @@
+package ambulance_wl
+
+import (
+    "log"
+    "net/http"
+    "sort"
+
+    "github.com/gin-gonic/gin"
+    "github.com/xpokorny/ambulance-webapi/internal/auth"
+    "github.com/xpokorny/ambulance-webapi/internal/db_service"
+)
+
+const (
+    rolePatient      = "patient"
+    roleDoctor       = "doctor"
+    roleReceptionist = "receptionist"
+    roleAdmin        = "admin"
+
+    // key of the access granted to the caller in the gin context
+    accessKey = "auth_access"
+)
+
+// access is what a role may do with a route.
+type access int
+
+const (
+    // accessAny allows the route on any record
+    accessAny access = iota + 1
+    // accessOwn allows the route, the handler restricts it to records the
+    // caller takes part in, e.g. appointments of the patient or the doctor
+    accessOwn
+    // accessSelf allows the route only when the userId path parameter is the
+    // caller
+    accessSelf
+)
+
+type routePolicy map[string]access
+
+var (
+    adminOnly      = routePolicy{roleAdmin: accessAny}
+    staffOnly      = routePolicy{roleReceptionist: accessAny, roleAdmin: accessAny}
+    everyone       = routePolicy{rolePatient: accessAny, roleDoctor: accessAny, roleReceptionist: accessAny, roleAdmin: accessAny}
+    ownOrStaff     = routePolicy{rolePatient: accessOwn, roleDoctor: accessOwn, roleReceptionist: accessAny, roleAdmin: accessAny}
+    doctorOrStaff  = routePolicy{roleDoctor: accessOwn, roleReceptionist: accessAny, roleAdmin: accessAny}
+    patientOrStaff = routePolicy{rolePatient: accessOwn, roleReceptionist: accessAny, roleAdmin: accessAny}
+)
+
+// routePolicies lists the roles allowed to call every route of getRoutes,
+// keyed by the route name. Routes missing here are denied to everybody.
+var routePolicies = map[string]routePolicy{
+    // appointments
+    "GetAppointments":         ownOrStaff,
+    "GetAppointment":          ownOrStaff,
+    "CreateAppointment":       ownOrStaff,
+    "UpdateAppointment":       ownOrStaff,
+    "DeleteAppointment":       doctorOrStaff,
+    "RestoreAppointment":      adminOnly,
+    "InsertUrgentAppointment": doctorOrStaff,
+    "CancelAppointment":       ownOrStaff,
+    "CheckInAppointment":      doctorOrStaff,
+    "StartAppointment":        {roleDoctor: accessOwn, roleAdmin: accessAny},
+    "CompleteAppointment":     {roleDoctor: accessOwn, roleAdmin: accessAny},
+    "MarkAppointmentNoShow":   doctorOrStaff,
+    "CreateAppointmentSeries": ownOrStaff,
+    "GetAppointmentSeries":    ownOrStaff,
+    "UpdateAppointmentSeries": ownOrStaff,
+    "CancelAppointmentSeries": ownOrStaff,
+
+    // users
+    "GetUsers":       {roleDoctor: accessAny, roleReceptionist: accessAny, roleAdmin: accessAny},
+    "GetUser":        {rolePatient: accessSelf, roleDoctor: accessAny, roleReceptionist: accessAny, roleAdmin: accessAny},
+    "CreateUser":     staffOnly,
+    "UpdateUser":     staffOnly,
+    "PatchUser":      staffOnly,
+    "DeactivateUser": staffOnly,
+    "ActivateUser":   staffOnly,
+
+    // working hours and absences of doctors
+    "GetAvailability":             everyone,
+    "UpdateAvailability":          {roleDoctor: accessSelf, roleReceptionist: accessAny, roleAdmin: accessAny},
+    "DeleteAvailability":          {roleDoctor: accessSelf, roleReceptionist: accessAny, roleAdmin: accessAny},
+    "CreateAvailabilityException": {roleDoctor: accessSelf, roleReceptionist: accessAny, roleAdmin: accessAny},
+    "DeleteAvailabilityException": {roleDoctor: accessSelf, roleReceptionist: accessAny, roleAdmin: accessAny},
+
+    // locations
+    "GetLocations":   everyone,
+    "GetLocation":    everyone,
+    "CreateLocation": staffOnly,
+    "UpdateLocation": staffOnly,
+    "DeleteLocation": staffOnly,
+
+    "GetSlots": everyone,
+
+    "GetNotifications":     {rolePatient: accessSelf, roleDoctor: accessSelf, roleReceptionist: accessSelf, roleAdmin: accessAny},
+    "MarkNotificationRead": {rolePatient: accessSelf, roleDoctor: accessSelf, roleReceptionist: accessSelf, roleAdmin: accessAny},
+
+    // walk-in waiting list
+    "GetWaitingList":         {roleDoctor: accessAny, roleReceptionist: accessAny, roleAdmin: accessAny},
+    "GetWaitingListEntry":    {rolePatient: accessOwn, roleDoctor: accessAny, roleReceptionist: accessAny, roleAdmin: accessAny},
+    "CreateWaitingListEntry": staffOnly,
+    "DeleteWaitingListEntry": patientOrStaff,
+    "CallNextWaitingPatient": {roleDoctor: accessAny, roleReceptionist: accessAny, roleAdmin: accessAny},
+
+    // waitlist of fully booked doctors
+    "GetWaitlistRequests":   ownOrStaff,
+    "GetWaitlistRequest":    ownOrStaff,
+    "CreateWaitlistRequest": patientOrStaff,
+    "CancelWaitlistRequest": patientOrStaff,
+    "AcceptSlotOffer":       patientOrStaff,
+    "DeclineSlotOffer":      patientOrStaff,
+
+    "GetApiKeys":   adminOnly,
+    "IssueApiKey":  adminOnly,
+    "RevokeApiKey": adminOnly,
+
+    "GetAuditEntries": adminOnly,
+}
+
+// routeScopes lists the scope an API key needs to call the route, keyed by
+// the route name. Routes missing here cannot be called with API keys.
+var routeScopes = map[string]string{
+    "GetAppointments":         "appointments:read",
+    "GetAppointment":          "appointments:read",
+    "GetAppointmentSeries":    "appointments:read",
+    "CreateAppointment":       "appointments:write",
+    "UpdateAppointment":       "appointments:write",
+    "DeleteAppointment":       "appointments:write",
+    "RestoreAppointment":      "appointments:write",
+    "InsertUrgentAppointment": "appointments:write",
+    "CancelAppointment":       "appointments:write",
+    "CheckInAppointment":      "appointments:write",
+    "StartAppointment":        "appointments:write",
+    "CompleteAppointment":     "appointments:write",
+    "MarkAppointmentNoShow":   "appointments:write",
+    "CreateAppointmentSeries": "appointments:write",
+    "UpdateAppointmentSeries": "appointments:write",
+    "CancelAppointmentSeries": "appointments:write",
+
+    "GetUsers":       "users:read",
+    "GetUser":        "users:read",
+    "CreateUser":     "users:write",
+    "UpdateUser":     "users:write",
+    "PatchUser":      "users:write",
+    "DeactivateUser": "users:write",
+    "ActivateUser":   "users:write",
+
+    "GetAvailability":             "availability:read",
+    "UpdateAvailability":          "availability:write",
+    "DeleteAvailability":          "availability:write",
+    "CreateAvailabilityException": "availability:write",
+    "DeleteAvailabilityException": "availability:write",
+
+    "GetLocations":   "locations:read",
+    "GetLocation":    "locations:read",
+    "CreateLocation": "locations:write",
+    "UpdateLocation": "locations:write",
+    "DeleteLocation": "locations:write",
+
+    "GetSlots": "slots:read",
+
+    "GetWaitingList":         "waiting-list:read",
+    "GetWaitingListEntry":    "waiting-list:read",
+    "CreateWaitingListEntry": "waiting-list:write",
+    "DeleteWaitingListEntry": "waiting-list:write",
+    "CallNextWaitingPatient": "waiting-list:write",
+
+    "GetWaitlistRequests":   "waitlist:read",
+    "GetWaitlistRequest":    "waitlist:read",
+    "CreateWaitlistRequest": "waitlist:write",
+    "CancelWaitlistRequest": "waitlist:write",
+    "AcceptSlotOffer":       "waitlist:write",
+    "DeclineSlotOffer":      "waitlist:write",
+}
+
+// apiKeyScopes are the scopes API keys can be issued with.
+var apiKeyScopes = func() map[string]bool {
+    scopes := map[string]bool{}
+    for _, scope := range routeScopes {
+        scopes[scope] = true
+    }
+    return scopes
+}()
+
+// NewAuthorizationMiddleware enforces routePolicies on the routes of the
+// handle functions for the caller authenticated by the auth middleware, which
+// must run first. Other routes, e.g. the OpenAPI document, pass through.
+func NewAuthorizationMiddleware(handleFunctions ApiHandleFunctions) gin.HandlerFunc {
+    names := routeNames(handleFunctions)
+    missing := []string{}
+    for _, route := range getRoutes(handleFunctions) {
+        if _, ok := routePolicies[route.Name]; !ok {
+            missing = append(missing, route.Name)
+        }
+    }
+    if len(missing) > 0 {
+        sort.Strings(missing)
+        log.Printf("Routes without authorization policy are denied: %v", missing)
+    }
+
+    return func(c *gin.Context) {
+        name, known := names[c.Request.Method+" "+c.FullPath()]
+        if !known {
+            c.Next()
+            return
+        }
+
+        userId, role, _ := auth.Caller(c)
+        granted := routePolicies[name][role]
+        if role == auth.APIKeyRole && hasScope(auth.Scopes(c), routeScopes[name]) {
+            granted = accessAny
+        }
+        if granted == accessSelf && c.Param("userId") != userId {
+            granted = 0
+        }
+        if granted == 0 && role == auth.APIKeyRole {
+            c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+                "error":   "API key has no scope allowing " + name,
+                "message": "access denied",
+                "status":  "Forbidden",
+            })
+            return
+        }
+        if granted == 0 {
+            c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+                "error":   "role " + role + " is not allowed to call " + name,
+                "message": "access denied",
+                "status":  "Forbidden",
+            })
+            return
+        }
+
+        c.Set(accessKey, granted)
+        c.Next()
+    }
+}
+
+// routeNames maps the method and the pattern of the routes to their names.
+func routeNames(handleFunctions ApiHandleFunctions) map[string]string {
+    names := map[string]string{}
+    for _, route := range getRoutes(handleFunctions) {
+        names[route.Method+" "+route.Pattern] = route.Name
+    }
+    return names
+}
+
+func hasScope(scopes []string, wanted string) bool {
+    for _, scope := range scopes {
+        if wanted != "" && scope == wanted {
+            return true
+        }
+    }
+    return false
+}
+
+// ownRecordsOnly returns the caller when the route is restricted to records
+// the caller takes part in.
+func ownRecordsOnly(c *gin.Context) (string, bool) {
+    if granted, _ := c.Value(accessKey).(access); granted != accessOwn {
+        return "", false
+    }
+    userId, _, _ := auth.Caller(c)
+    return userId, true
+}
+
+// takesPart reports whether the caller may access a record of the patient
+// and the doctor.
+func takesPart(c *gin.Context, patientId string, doctorId string) bool {
+    userId, restricted := ownRecordsOnly(c)
+    return !restricted || userId == patientId || userId == doctorId
+}
+
+// ownRecordsFilter restricts a list to records the caller takes part in, it
+// matches everything for unrestricted callers.
+func ownRecordsFilter(c *gin.Context) db_service.Filter {
+    userId, restricted := ownRecordsOnly(c)
+    if !restricted {
+        return db_service.And()
+    }
+    return db_service.Or(
+        db_service.Eq("patient.id", userId),
+        db_service.Eq("doctor.id", userId),
+    )
+}
+
+// includeDeleted reports whether deleted records were requested by the
+// includeDeleted query flag. Only administrators may see them, other callers
+// are refused and false is returned as the second value. Without
+// authentication everybody may see them.
+func includeDeleted(c *gin.Context) (bool, bool) {
+    if c.Query("includeDeleted") != "true" {
+        return false, true
+    }
+    if _, role, authenticated := auth.Caller(c); authenticated && role != roleAdmin {
+        forbiddenRecordResponse(c, "only administrators can see deleted records")
+        return false, false
+    }
+    return true, true
+}
+
+func forbiddenRecordResponse(c *gin.Context, message string) {
+    c.JSON(http.StatusForbidden, gin.H{
+        "error":   message,
+        "message": "access denied",
+        "status":  "Forbidden",
+    })
+}
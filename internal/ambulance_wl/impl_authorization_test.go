@@ -0,0 +1,138 @@
+package ambulance_wl
+
+import (
+    "net/http"
+    "slices"
+    "sort"
+    "testing"
+)
+
+// seedOwnRecords books an appointment of user1 with user5 and another one of
+// user2 with user6, the callers see them depending on their role.
+func seedOwnRecords(t *testing.T, server *testServer) (Appointment, Appointment) {
+    t.Helper()
+    own := server.book(t, asPatient, testAppointment("09:00"))
+    other := testAppointment("09:00")
+    other.Patient.Id = "user2"
+    other.Doctor.Id = "user6"
+    other.Location.Id = "loc2"
+    return own, server.book(t, asOtherPatient, other)
+}
+
+func TestAppointmentsOfOtherUsersAreHidden(t *testing.T) {
+    server := newTestServer(t, true)
+    own, _ := seedOwnRecords(t, server)
+    path := "/api/appointments/" + own.Id
+
+    tests := []struct {
+        name   string
+        caller string
+        status int
+    }{
+        {"patient", asPatient, http.StatusOK},
+        {"doctor", asDoctor, http.StatusOK},
+        {"other patient", asOtherPatient, http.StatusNotFound},
+        {"other doctor", asOtherDoctor, http.StatusNotFound},
+        {"receptionist", asReceptionist, http.StatusOK},
+        {"admin", asAdmin, http.StatusOK},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            if response := server.call(http.MethodGet, path, test.caller, nil); response.Code != test.status {
+                t.Errorf("get = %v, want %v", response.Code, test.status)
+            }
+        })
+    }
+
+    // changes of other users' appointments look the same as reads, the
+    // appointment is not revealed by a different status
+    changes := []struct {
+        name   string
+        method string
+        path   string
+        caller string
+        body   interface{}
+    }{
+        {"update by other patient", http.MethodPut, path, asOtherPatient, own},
+        {"cancel by other patient", http.MethodPost, path + "/cancel", asOtherPatient, StatusChangeRequest{}},
+        {"check in by other doctor", http.MethodPost, path + "/check-in", asOtherDoctor, StatusChangeRequest{}},
+        {"delete by other doctor", http.MethodDelete, path, asOtherDoctor, nil},
+    }
+    for _, change := range changes {
+        t.Run(change.name, func(t *testing.T) {
+            response := server.call(change.method, change.path, change.caller, change.body)
+            if response.Code != http.StatusNotFound {
+                t.Errorf("status = %v %v, want 404", response.Code, response.Body.String())
+            }
+        })
+    }
+    if stored, err := server.appointments.FindDocument(t.Context(), own.Id); err != nil || stored.Status != statusScheduled {
+        t.Errorf("appointment after changes of other users = %+v, %v", stored, err)
+    }
+}
+
+func TestAppointmentListsContainOwnRows(t *testing.T) {
+    server := newTestServer(t, true)
+    own, other := seedOwnRecords(t, server)
+
+    tests := []struct {
+        name   string
+        caller string
+        ids    []string
+    }{
+        {"patient", asPatient, []string{own.Id}},
+        {"doctor", asDoctor, []string{own.Id}},
+        {"other patient", asOtherPatient, []string{other.Id}},
+        {"other doctor", asOtherDoctor, []string{other.Id}},
+        {"receptionist", asReceptionist, []string{own.Id, other.Id}},
+        {"admin", asAdmin, []string{own.Id, other.Id}},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            response := server.call(http.MethodGet, "/api/appointments", test.caller, nil)
+            if response.Code != http.StatusOK {
+                t.Fatalf("list = %v %v", response.Code, response.Body.String())
+            }
+            ids := []string{}
+            for _, appointment := range decodeResponse[[]Appointment](t, response) {
+                ids = append(ids, appointment.Id)
+            }
+            sort.Strings(ids)
+            want := append([]string{}, test.ids...)
+            sort.Strings(want)
+            if !slices.Equal(ids, want) {
+                t.Errorf("listed appointments = %v, want %v", ids, want)
+            }
+        })
+    }
+}
+
+func TestDeletedAppointmentsAreForAdministrators(t *testing.T) {
+    server := newTestServer(t, true)
+    own, _ := seedOwnRecords(t, server)
+    path := "/api/appointments/" + own.Id
+    if response := server.call(http.MethodDelete, path, asReceptionist, nil); response.Code != http.StatusNoContent {
+        t.Fatalf("delete = %v %v", response.Code, response.Body.String())
+    }
+
+    tests := []struct {
+        name   string
+        caller string
+        status int
+    }{
+        {"patient", asPatient, http.StatusForbidden},
+        {"doctor", asDoctor, http.StatusForbidden},
+        {"receptionist", asReceptionist, http.StatusForbidden},
+        {"admin", asAdmin, http.StatusOK},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            // whoever may list deleted appointments may restore them
+            list := server.call(http.MethodGet, "/api/appointments?includeDeleted=true", test.caller, nil)
+            restore := server.call(http.MethodPost, path+"/restore", test.caller, nil)
+            if list.Code != test.status || restore.Code != test.status {
+                t.Errorf("list = %v, restore = %v, want %v", list.Code, restore.Code, test.status)
+            }
+        })
+    }
+}
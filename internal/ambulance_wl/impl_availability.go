@@ -0,0 +1,399 @@
+package ambulance_wl
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/google/uuid"
+    "github.com/xpokorny/ambulance-webapi/internal/db_service"
+)
+
+type implAvailabilityAPI struct {
+}
+
+var exceptionReasons = []string{"vacation", "sick-leave", "other"}
+
+// default working hours of doctors whose availability was never set, e.g.
+// doctors stored before availability was introduced
+const (
+    defaultTimeZone     = "Europe/Bratislava"
+    defaultWorkingStart = "08:00"
+    defaultWorkingEnd   = "16:00"
+)
+
+var defaultWorkingDays = []string{"monday", "tuesday", "wednesday", "thursday", "friday"}
+
+func NewAvailabilityAPI() AvailabilityAPI {
+    return &implAvailabilityAPI{}
+}
+
+func (api *implAvailabilityAPI) GetAvailability(c *gin.Context) {
+    availabilityService, ok := api.doctorAvailabilityService(c)
+    if !ok {
+        return
+    }
+
+    availability, _, err := findAvailability(c.Request.Context(), availabilityService, c.Param("userId"))
+    if err != nil {
+        availabilityErrorResponse(c, err)
+        return
+    }
+    c.JSON(http.StatusOK, availability)
+}
+
+func (api *implAvailabilityAPI) UpdateAvailability(c *gin.Context) {
+    availabilityService, ok := api.doctorAvailabilityService(c)
+    if !ok {
+        return
+    }
+
+    userId := c.Param("userId")
+    availability := Availability{}
+    if err := c.ShouldBindJSON(&availability); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{
+            "error":   err.Error(),
+            "message": "invalid request body",
+            "status":  "Bad Request",
+        })
+        return
+    }
+    availability.Id = userId
+    if availability.TimeZone == "" {
+        availability.TimeZone = "UTC"
+    }
+    if err := validateAvailability(&availability); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{
+            "error":   err.Error(),
+            "message": "invalid availability",
+            "status":  "Bad Request",
+        })
+        return
+    }
+
+    // absences are managed by their own endpoints and survive the replacement
+    existing, err := availabilityService.FindDocument(c.Request.Context(), userId)
+    switch err {
+    case nil:
+        availability.Exceptions = existing.Exceptions
+        err = availabilityService.ReplaceDocumentIfVersion(c.Request.Context(), userId, existing.Version, &availability)
+    case db_service.ErrNotFound:
+        err = availabilityService.CreateDocument(c.Request.Context(), userId, &availability)
+    }
+    if err != nil {
+        availabilityErrorResponse(c, err)
+        return
+    }
+    c.JSON(http.StatusOK, availability)
+}
+
+func (api *implAvailabilityAPI) DeleteAvailability(c *gin.Context) {
+    availabilityService, ok := api.doctorAvailabilityService(c)
+    if !ok {
+        return
+    }
+
+    if err := availabilityService.DeleteDocument(c.Request.Context(), c.Param("userId")); err != nil {
+        availabilityErrorResponse(c, err)
+        return
+    }
+    c.AbortWithStatus(http.StatusNoContent)
+}
+
+func (api *implAvailabilityAPI) CreateAvailabilityException(c *gin.Context) {
+    exception := AvailabilityException{}
+    if err := c.ShouldBindJSON(&exception); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{
+            "error":   err.Error(),
+            "message": "invalid request body",
+            "status":  "Bad Request",
+        })
+        return
+    }
+    if exception.Id == "" || exception.Id == "@new" {
+        exception.Id = uuid.New().String()
+    }
+    if err := validateAvailabilityException(&exception); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{
+            "error":   err.Error(),
+            "message": "invalid absence",
+            "status":  "Bad Request",
+        })
+        return
+    }
+
+    api.updateExceptions(c, http.StatusCreated, func(availability *Availability) error {
+        for _, existing := range availability.Exceptions {
+            if existing.Id == exception.Id {
+                return db_service.ErrConflict
+            }
+        }
+        availability.Exceptions = append(availability.Exceptions, exception)
+        return nil
+    })
+}
+
+func (api *implAvailabilityAPI) DeleteAvailabilityException(c *gin.Context) {
+    exceptionId := c.Param("exceptionId")
+    api.updateExceptions(c, http.StatusNoContent, func(availability *Availability) error {
+        for i, existing := range availability.Exceptions {
+            if existing.Id == exceptionId {
+                availability.Exceptions = append(availability.Exceptions[:i], availability.Exceptions[i+1:]...)
+                return nil
+            }
+        }
+        return db_service.ErrNotFound
+    })
+}
+
+// updateExceptions applies the change to the absences of the doctor. The
+// availability is replaced only in the version read, so that two concurrent
+// changes cannot overwrite each other.
+func (api *implAvailabilityAPI) updateExceptions(c *gin.Context, successStatus int, change func(availability *Availability) error) {
+    availabilityService, ok := api.doctorAvailabilityService(c)
+    if !ok {
+        return
+    }
+
+    userId := c.Param("userId")
+    availability, stored, err := findAvailability(c.Request.Context(), availabilityService, userId)
+    if err == nil {
+        err = change(availability)
+    }
+    switch {
+    case err != nil:
+    case stored:
+        err = availabilityService.ReplaceDocumentIfVersion(c.Request.Context(), userId, availability.Version, availability)
+    default:
+        err = availabilityService.CreateDocument(c.Request.Context(), userId, availability)
+    }
+    switch {
+    case err != nil:
+        availabilityErrorResponse(c, err)
+    case successStatus == http.StatusNoContent:
+        c.AbortWithStatus(http.StatusNoContent)
+    default:
+        c.JSON(successStatus, availability)
+    }
+}
+
+// DefaultAvailability returns the working hours of a doctor whose
+// availability was never set.
+func DefaultAvailability(doctorId string) Availability {
+    availability := Availability{Id: doctorId, TimeZone: defaultTimeZone}
+    for _, day := range defaultWorkingDays {
+        availability.WeeklySchedule = append(availability.WeeklySchedule, WorkingHours{
+            DayOfWeek: day,
+            Start:     defaultWorkingStart,
+            End:       defaultWorkingEnd,
+        })
+    }
+    return availability
+}
+
+// findAvailability returns the stored availability of the doctor, or the
+// default one, which is reported as not stored.
+func findAvailability(ctx context.Context, availabilityDb db_service.DbService[Availability], doctorId string) (*Availability, bool, error) {
+    availability, err := availabilityDb.FindDocument(ctx, doctorId)
+    if err == db_service.ErrNotFound {
+        defaults := DefaultAvailability(doctorId)
+        return &defaults, false, nil
+    }
+    return availability, err == nil, err
+}
+
+// doctorAvailabilityService returns the availability storage after checking
+// that the user of the request path is a doctor, only doctors have working
+// hours.
+func (api *implAvailabilityAPI) doctorAvailabilityService(c *gin.Context) (db_service.DbService[Availability], bool) {
+    userService, ok := dbServiceFromContext[User](c, "user_service")
+    if !ok {
+        return nil, false
+    }
+    availabilityService, ok := dbServiceFromContext[Availability](c, "availability_service")
+    if !ok {
+        return nil, false
+    }
+
+    userId := c.Param("userId")
+    user, err := userService.FindDocument(c.Request.Context(), userId)
+    if err == nil && user.Role != "doctor" {
+        err = db_service.ErrNotFound
+    }
+    switch err {
+    case nil:
+        return availabilityService, true
+    case db_service.ErrNotFound:
+        c.JSON(http.StatusNotFound, gin.H{
+            "error":   "doctor not found",
+            "message": "doctor with id " + userId + " not found",
+            "status":  "Not Found",
+        })
+    default:
+        c.JSON(http.StatusInternalServerError, gin.H{
+            "error":   "failed to get doctor",
+            "message": err.Error(),
+            "status":  "Internal Server Error",
+        })
+    }
+    return nil, false
+}
+
+func availabilityErrorResponse(c *gin.Context, err error) {
+    switch err {
+    case db_service.ErrNotFound:
+        c.JSON(http.StatusNotFound, gin.H{
+            "error":   "availability not found",
+            "message": "availability of doctor " + c.Param("userId") + " or its absence not found",
+            "status":  "Not Found",
+        })
+    case db_service.ErrConflict, db_service.ErrPreconditionFailed:
+        c.JSON(http.StatusConflict, gin.H{
+            "error":   err.Error(),
+            "message": "absence already exists, or availability was changed concurrently and should be reloaded",
+            "status":  "Conflict",
+        })
+    default:
+        c.JSON(http.StatusInternalServerError, gin.H{
+            "error":   "failed to access availability",
+            "message": err.Error(),
+            "status":  "Internal Server Error",
+        })
+    }
+}
+
+func validateAvailability(availability *Availability) error {
+    return validateWeeklyHours(availability.TimeZone, availability.WeeklySchedule)
+}
+
+func validateAvailabilityException(exception *AvailabilityException) error {
+    if exception.From.IsZero() || !exception.To.After(exception.From) {
+        return fmt.Errorf("absence must end after it starts")
+    }
+    if exception.Reason == "" {
+        return nil
+    }
+    for _, reason := range exceptionReasons {
+        if exception.Reason == reason {
+            return nil
+        }
+    }
+    return fmt.Errorf("reason must be one of %v", exceptionReasons)
+}
+
+// weekday parses the day of the week as used in the weekly schedule.
+func weekday(day string) (time.Weekday, error) {
+    for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+        if strings.ToLower(weekday.String()) == day {
+            return weekday, nil
+        }
+    }
+    return time.Sunday, fmt.Errorf("unknown day of week %q", day)
+}
+
+// minuteOfDay parses time of the day in HH:MM format, 24:00 is the end of the
+// day.
+func minuteOfDay(clock string) (int, error) {
+    var hours, minutes int
+    if len(clock) != 5 {
+        return 0, fmt.Errorf("time %q is not in HH:MM format", clock)
+    }
+    if _, err := fmt.Sscanf(clock, "%02d:%02d", &hours, &minutes); err != nil {
+        return 0, fmt.Errorf("time %q is not in HH:MM format", clock)
+    }
+    if hours < 0 || minutes < 0 || minutes > 59 || hours > 24 || (hours == 24 && minutes > 0) {
+        return 0, fmt.Errorf("time %q is out of range", clock)
+    }
+    return hours*60 + minutes, nil
+}
+
+// unavailableError reports an appointment outside working hours of the
+// doctor or during an absence.
+type unavailableError struct {
+    message string
+}
+
+func (e unavailableError) Error() string {
+    return e.message
+}
+
+// checkAvailability verifies that the whole appointment falls within working
+// hours of the doctor and opening hours of the location, and does not overlap
+// any absence of the doctor. Doctors without stored availability work the
+// default hours, locations without opening hours are always open.
+func checkAvailability(
+    ctx context.Context,
+    availabilityDb db_service.DbService[Availability],
+    locationDb db_service.DbService[Location],
+    appointment *Appointment,
+) error {
+    availability, _, err := findAvailability(ctx, availabilityDb, appointment.Doctor.Id)
+    if err != nil {
+        return err
+    }
+
+    for _, exception := range availability.Exceptions {
+        if exception.From.Before(appointment.EndDateTime) && exception.To.After(appointment.DateTime) {
+            reason := exception.Reason
+            if reason == "" {
+                reason = "absence"
+            }
+            return unavailableError{fmt.Sprintf("doctor is absent (%v) from %v to %v", reason, exception.From.Format(time.RFC3339), exception.To.Format(time.RFC3339))}
+        }
+    }
+
+    workingHours, err := weeklyIntervals(availability.WeeklySchedule, availability.TimeZone, appointment.DateTime, appointment.EndDateTime)
+    if err != nil {
+        return err
+    }
+    if !coversInterval(workingHours, appointment.DateTime, appointment.EndDateTime) {
+        return unavailableError{"appointment is outside working hours of the doctor"}
+    }
+
+    location, err := locationDb.FindDocument(ctx, appointment.Location.Id)
+    if err == db_service.ErrNotFound {
+        // appointments kept at a retired location cannot be moved there
+        err = missingLocationError(ctx, locationDb, appointment.Location.Id)
+    }
+    if err != nil {
+        return err
+    }
+    if len(location.OpeningHours) == 0 {
+        return nil
+    }
+    openingHours, err := weeklyIntervals(location.OpeningHours, location.TimeZone, appointment.DateTime, appointment.EndDateTime)
+    if err != nil {
+        return err
+    }
+    if !coversInterval(openingHours, appointment.DateTime, appointment.EndDateTime) {
+        return unavailableError{"appointment is outside opening hours of the location"}
+    }
+    return nil
+}
+
+func availabilityCheckResponse(c *gin.Context, err error) {
+    if _, ok := err.(invalidReferenceError); ok {
+        referencesErrorResponse(c, err)
+        return
+    }
+    if _, ok := err.(unavailableError); ok {
+        c.JSON(
+            http.StatusUnprocessableEntity,
+            gin.H{
+                "status":  "Unprocessable Entity",
+                "message": "Doctor or location is not available at the requested time",
+                "error":   err.Error(),
+            })
+        return
+    }
+    c.JSON(
+        http.StatusBadGateway,
+        gin.H{
+            "status":  "Bad Gateway",
+            "message": "Failed to check availability of the doctor and location",
+            "error":   err.Error(),
+        })
+}
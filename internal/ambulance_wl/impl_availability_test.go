@@ -0,0 +1,35 @@
+package ambulance_wl
+
+import "testing"
+
+func TestMinuteOfDay(t *testing.T) {
+    tests := []struct {
+        clock   string
+        want    int
+        invalid bool
+    }{
+        {clock: "00:00", want: 0},
+        {clock: "08:30", want: 510},
+        {clock: "24:00", want: 1440},
+        {clock: "24:01", invalid: true},
+        {clock: "12:60", invalid: true},
+        {clock: "-1:00", invalid: true},
+        {clock: "10:-5", invalid: true},
+        {clock: "8:30", invalid: true},
+        {clock: "ab:cd", invalid: true},
+    }
+    for _, test := range tests {
+        t.Run(test.clock, func(t *testing.T) {
+            got, err := minuteOfDay(test.clock)
+            if test.invalid {
+                if err == nil {
+                    t.Errorf("minuteOfDay = %v, want an error", got)
+                }
+                return
+            }
+            if err != nil || got != test.want {
+                t.Errorf("minuteOfDay = %v, %v, want %v", got, err, test.want)
+            }
+        })
+    }
+}
@@ -0,0 +1,266 @@
+package ambulance_wl
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "net/http"
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/google/uuid"
+    "github.com/xpokorny/ambulance-webapi/internal/db_service"
+)
+
+// keyedMutex provides one mutex per key, e.g. per doctor or location.
+type keyedMutex struct {
+    lock  sync.Mutex
+    locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+    sync.Mutex
+    references int
+}
+
+func newKeyedMutex() *keyedMutex {
+    return &keyedMutex{locks: map[string]*keyedLock{}}
+}
+
+// Lock locks all keys in a stable order, so that two callers locking
+// overlapping sets of keys cannot deadlock, and returns the unlock function.
+func (k *keyedMutex) Lock(keys ...string) func() {
+    sorted := append([]string{}, keys...)
+    sort.Strings(sorted)
+
+    acquired := []string{}
+    for i, key := range sorted {
+        if i > 0 && key == sorted[i-1] {
+            continue
+        }
+        k.lock.Lock()
+        entry, exists := k.locks[key]
+        if !exists {
+            entry = &keyedLock{}
+            k.locks[key] = entry
+        }
+        entry.references++
+        k.lock.Unlock()
+
+        entry.Lock()
+        acquired = append(acquired, key)
+    }
+
+    return func() {
+        k.lock.Lock()
+        defer k.lock.Unlock()
+        for i := len(acquired) - 1; i >= 0; i-- {
+            entry := k.locks[acquired[i]]
+            entry.Unlock()
+            entry.references--
+            if entry.references == 0 {
+                delete(k.locks, acquired[i])
+            }
+        }
+    }
+}
+
+const (
+    // a lease outlives every request holding it, it is taken over only when
+    // its holder crashed
+    bookingLeaseDuration = 30 * time.Second
+    bookingLeaseWait     = 10 * time.Second
+    bookingLeaseRetry    = 25 * time.Millisecond
+)
+
+var errBookingBusy = fmt.Errorf("booked time is locked by another request")
+
+// BookingLease is the stored lock of a doctor or a location, it is held by
+// one request across all replicas of the service. Released leases are kept
+// with ExpiresAt in the past and taken over by the next request.
+type BookingLease struct {
+    Id        string
+    Owner     string
+    ExpiresAt time.Time
+    Version   int64
+}
+
+// bookingLock serializes the conflict check and the write of appointments
+// sharing a doctor or a location, so that two concurrent requests cannot both
+// book the same time. Requests of one replica wait for each other in memory,
+// replicas exclude each other by leases created with the unique id of the
+// stored documents. Every write changing the time, the doctor or the location
+// of an appointment must hold the lock.
+type bookingLock struct {
+    local  *keyedMutex
+    leases db_service.DbService[BookingLease]
+}
+
+var bookingLocks = &bookingLock{local: newKeyedMutex()}
+
+// UseBookingLeases stores the leases of the booking locks in the service, it
+// must be called before the service runs with more than one replica.
+func UseBookingLeases(leaseService db_service.DbService[BookingLease]) {
+    bookingLocks.leases = leaseService
+}
+
+// Lock locks all keys and returns the unlock function. It fails when a lease
+// cannot be acquired in time.
+func (b *bookingLock) Lock(ctx context.Context, keys ...string) (func(), error) {
+    unlockLocal := b.local.Lock(keys...)
+    if b.leases == nil {
+        return unlockLocal, nil
+    }
+
+    sorted := append([]string{}, keys...)
+    sort.Strings(sorted)
+    owner := uuid.New().String()
+    acquired := []*BookingLease{}
+    unlock := func() {
+        for i := len(acquired) - 1; i >= 0; i-- {
+            b.releaseLease(acquired[i])
+        }
+        unlockLocal()
+    }
+    for i, key := range sorted {
+        if i > 0 && key == sorted[i-1] {
+            continue
+        }
+        lease, err := b.acquireLease(ctx, key, owner)
+        if err != nil {
+            unlock()
+            return nil, err
+        }
+        acquired = append(acquired, lease)
+    }
+    return unlock, nil
+}
+
+func (b *bookingLock) acquireLease(ctx context.Context, key string, owner string) (*BookingLease, error) {
+    deadline := time.Now().Add(bookingLeaseWait)
+    for {
+        lease := &BookingLease{Id: key, Owner: owner, ExpiresAt: time.Now().Add(bookingLeaseDuration)}
+        err := b.leases.CreateDocument(ctx, key, lease)
+        if err == db_service.ErrConflict {
+            var held *BookingLease
+            held, err = b.leases.FindDocument(ctx, key)
+            switch {
+            case err != nil:
+            case time.Now().Before(held.ExpiresAt):
+                err = errBookingBusy
+            default:
+                // the version check makes only one of the competing requests
+                // take over the released lease
+                err = b.leases.ReplaceDocumentIfVersion(ctx, key, held.Version, lease)
+            }
+        }
+
+        switch err {
+        case nil:
+            return lease, nil
+        case errBookingBusy, db_service.ErrPreconditionFailed, db_service.ErrNotFound:
+        default:
+            return nil, err
+        }
+        if time.Now().After(deadline) {
+            return nil, errBookingBusy
+        }
+        select {
+        case <-ctx.Done():
+            return nil, ctx.Err()
+        case <-time.After(bookingLeaseRetry):
+        }
+    }
+}
+
+// releaseLease expires the lease, a failure is only logged as the lease
+// expires on its own.
+func (b *bookingLock) releaseLease(lease *BookingLease) {
+    ctx, cancel := context.WithTimeout(context.Background(), bookingLeaseWait)
+    defer cancel()
+    released := BookingLease{Id: lease.Id}
+    if err := b.leases.ReplaceDocumentIfVersion(ctx, lease.Id, lease.Version, &released); err != nil {
+        log.Printf("Failed to release booking lease %v: %v", lease.Id, err)
+    }
+}
+
+func lockBooking(ctx context.Context, appointment *Appointment) (func(), error) {
+    return bookingLocks.Lock(ctx, "doctor/"+appointment.Doctor.Id, "location/"+appointment.Location.Id)
+}
+
+func bookingLockResponse(c *gin.Context, err error) {
+    c.JSON(
+        http.StatusServiceUnavailable,
+        gin.H{
+            "status":  "Service Unavailable",
+            "message": "Booked time is being changed by another request, retry later",
+            "error":   err.Error(),
+        },
+    )
+}
+
+// findConflictingAppointment returns another appointment of the same doctor
+// or location overlapping the time span of the appointment, or nil if there
+// is none. Appointments stored before durations were introduced have no end,
+// they last the default duration as in the slot search. Cancelled appointments
+// and no-shows do not block their time slot, neither do the ignored
+// appointments, e.g. those about to be replaced.
+func findConflictingAppointment(ctx context.Context, db db_service.DbService[Appointment], appointment *Appointment, ignoredIds ...string) (*Appointment, error) {
+    resources := []db_service.Filter{}
+    if appointment.Doctor.Id != "" {
+        resources = append(resources, db_service.Eq("doctor.id", appointment.Doctor.Id))
+    }
+    if appointment.Location.Id != "" {
+        resources = append(resources, db_service.Eq("location.id", appointment.Location.Id))
+    }
+    if len(resources) == 0 {
+        return nil, nil
+    }
+
+    conflicts, err := db.FindDocuments(ctx, db_service.Query{
+        Filter: db_service.And(
+            db_service.Nin("id", ignoredAppointments(appointment.Id, ignoredIds)...),
+            activeStatusFilter(),
+            db_service.Lt("datetime", appointment.EndDateTime),
+            db_service.Or(
+                db_service.Gt("enddatetime", appointment.DateTime),
+                db_service.And(
+                    db_service.Eq("enddatetime", nil),
+                    db_service.Gt("datetime", appointment.DateTime.Add(-legacyAppointmentDuration())),
+                ),
+            ),
+            db_service.Or(resources...),
+        ),
+        Limit: 1,
+    })
+    if err != nil || len(conflicts) == 0 {
+        return nil, err
+    }
+    return &conflicts[0], nil
+}
+
+func ignoredAppointments(id string, ignoredIds []string) []interface{} {
+    ignored := []interface{}{id}
+    for _, ignoredId := range ignoredIds {
+        ignored = append(ignored, ignoredId)
+    }
+    return ignored
+}
+
+func bookingConflictResponse(c *gin.Context, appointment *Appointment, conflicting *Appointment) {
+    message := "Location is already booked at this time"
+    if appointment.Doctor.Id != "" && conflicting.Doctor.Id == appointment.Doctor.Id {
+        message = "Doctor is already booked at this time"
+    }
+    c.JSON(
+        http.StatusConflict,
+        gin.H{
+            "status":                 "Conflict",
+            "message":                message,
+            "error":                  "appointment " + conflicting.Id + " overlaps the requested time",
+            "conflictingAppointment": conflicting,
+        },
+    )
+}
@@ -0,0 +1,179 @@
+package ambulance_wl
+
+import (
+    "context"
+    "net/http"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/xpokorny/ambulance-webapi/internal/db_service"
+    "go.mongodb.org/mongo-driver/bson"
+)
+
+func TestBookingConflicts(t *testing.T) {
+    tests := []struct {
+        name   string
+        change func(appointment *Appointment)
+        status int
+        error  string
+    }{
+        {"same doctor and location", func(appointment *Appointment) {}, http.StatusConflict, "Doctor is already booked"},
+        {"same doctor at other location", func(appointment *Appointment) {
+            appointment.Location.Id = "loc2"
+        }, http.StatusConflict, "Doctor is already booked"},
+        {"other doctor at same location", func(appointment *Appointment) {
+            appointment.Doctor.Id = "user6"
+        }, http.StatusConflict, "Location is already booked"},
+        {"overlapping the end", func(appointment *Appointment) {
+            *appointment = testAppointment("09:20")
+        }, http.StatusConflict, "Doctor is already booked"},
+        {"overlapping the start", func(appointment *Appointment) {
+            *appointment = testAppointment("08:40")
+        }, http.StatusConflict, "Doctor is already booked"},
+        {"other doctor at other location", func(appointment *Appointment) {
+            appointment.Doctor.Id = "user6"
+            appointment.Location.Id = "loc2"
+        }, http.StatusCreated, ""},
+        {"right after", func(appointment *Appointment) {
+            *appointment = testAppointment("09:30")
+        }, http.StatusCreated, ""},
+        {"right before", func(appointment *Appointment) {
+            *appointment = testAppointment("08:30")
+        }, http.StatusCreated, ""},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            server := newTestServer(t, true)
+            server.book(t, asPatient, testAppointment("09:00"))
+
+            appointment := testAppointment("09:00")
+            appointment.Patient.Id = "user2"
+            test.change(&appointment)
+            appointment.Patient.Id = "user2"
+            response := server.call(http.MethodPost, "/api/appointments", asReceptionist, appointment)
+            if response.Code != test.status {
+                t.Fatalf("status = %v, want %v, body %v", response.Code, test.status, response.Body.String())
+            }
+            if !strings.Contains(response.Body.String(), test.error) {
+                t.Errorf("body = %v, want %q", response.Body.String(), test.error)
+            }
+        })
+    }
+}
+
+func TestConcurrentBookings(t *testing.T) {
+    server := newTestServer(t, true)
+
+    const requests = 20
+    statuses := make([]int, requests)
+    start := make(chan struct{})
+    var wg sync.WaitGroup
+    for i := 0; i < requests; i++ {
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            // half of the requests overlap only partially, all of them
+            // share the doctor
+            appointment := testAppointment("09:00")
+            if i%2 == 1 {
+                appointment = testAppointment("09:15")
+                appointment.Location.Id = "loc2"
+            }
+            <-start
+            statuses[i] = server.call(http.MethodPost, "/api/appointments", asReceptionist, appointment).Code
+        }(i)
+    }
+    close(start)
+    wg.Wait()
+
+    created := 0
+    for i, status := range statuses {
+        switch status {
+        case http.StatusCreated:
+            created++
+        case http.StatusConflict:
+        default:
+            t.Errorf("status of request %v = %v, want 201 or 409", i, status)
+        }
+    }
+    if created != 1 {
+        t.Errorf("%v of %v overlapping bookings were created, want 1", created, requests)
+    }
+    booked, _ := server.appointments.FindDocuments(t.Context(), db_service.Query{})
+    if len(booked) != 1 {
+        t.Errorf("stored appointments = %v, want 1", len(booked))
+    }
+}
+
+// rawAppointments finds appointments stored as plain BSON documents, so that
+// documents missing fields of the current model can be stored.
+type rawAppointments struct {
+    db_service.DbService[Appointment]
+    documents db_service.DbService[bson.M]
+}
+
+func (r rawAppointments) FindDocuments(ctx context.Context, query db_service.Query) ([]Appointment, error) {
+    documents, err := r.documents.FindDocuments(ctx, query)
+    if err != nil {
+        return nil, err
+    }
+    appointments := []Appointment{}
+    for _, document := range documents {
+        raw, err := bson.Marshal(document)
+        if err != nil {
+            return nil, err
+        }
+        var appointment Appointment
+        if err := bson.Unmarshal(raw, &appointment); err != nil {
+            return nil, err
+        }
+        appointments = append(appointments, appointment)
+    }
+    return appointments, nil
+}
+
+func TestConflictsWithAppointmentsWithoutEnd(t *testing.T) {
+    t.Setenv("AMBULANCE_API_DEFAULT_APPOINTMENT_MINUTES", "30")
+    legacy := testAppointment("10:00")
+    db := rawAppointments{documents: db_service.NewMemoryService[bson.M]()}
+    // stored before durations were introduced, it lasts the default 30
+    // minutes as in the slot search
+    err := db.documents.CreateDocument(t.Context(), "legacy", &bson.M{
+        "id":       "legacy",
+        "doctor":   bson.M{"id": legacy.Doctor.Id},
+        "location": bson.M{"id": legacy.Location.Id},
+        "datetime": legacy.DateTime,
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        clock    string
+        minutes  int32
+        conflict bool
+    }{
+        {"09:30", 30, false},
+        {"09:45", 30, true},
+        {"10:00", 15, true},
+        {"10:15", 15, true},
+        {"10:29", 15, true},
+        {"10:30", 15, false},
+    }
+    for _, test := range tests {
+        t.Run(test.clock, func(t *testing.T) {
+            appointment := testAppointment(test.clock)
+            appointment.DurationMinutes = test.minutes
+            appointment.EndDateTime = appointment.DateTime.Add(time.Duration(test.minutes) * time.Minute)
+            conflicting, err := findConflictingAppointment(t.Context(), db, &appointment)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if (conflicting != nil) != test.conflict {
+                t.Errorf("conflicting = %+v, want conflict %v", conflicting, test.conflict)
+            }
+        })
+    }
+}
@@ -0,0 +1,52 @@
+package ambulance_wl
+
+import (
+    "fmt"
+    "net/http"
+    "strconv"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+    "github.com/xpokorny/ambulance-webapi/internal/db_service"
+)
+
+// setETag exposes the version of the returned document in the ETag header.
+func setETag(c *gin.Context, version int64) {
+    c.Header("ETag", fmt.Sprintf("%q", strconv.FormatInt(version, 10)))
+}
+
+// ifMatchVersion returns the version required by the If-Match header, or
+// db_service.AnyVersion when the header is missing or "*". A malformed ETag
+// cannot match any version, so the second result is false for it.
+func ifMatchVersion(c *gin.Context) (int64, bool) {
+    ifMatch := strings.TrimSpace(c.GetHeader("If-Match"))
+    if ifMatch == "" || ifMatch == "*" {
+        return db_service.AnyVersion, true
+    }
+    ifMatch = strings.TrimPrefix(ifMatch, "W/")
+    version, err := strconv.ParseInt(strings.Trim(ifMatch, `"`), 10, 64)
+    if err != nil || version < 0 {
+        return 0, false
+    }
+    return version, true
+}
+
+func preconditionFailedResponse(c *gin.Context) {
+    c.JSON(
+        http.StatusPreconditionFailed,
+        gin.H{
+            "status":  "Precondition Failed",
+            "message": "Appointment was changed, reload it and retry",
+            "error":   db_service.ErrPreconditionFailed.Error(),
+        })
+}
+
+func concurrentChangeResponse(c *gin.Context) {
+    c.JSON(
+        http.StatusConflict,
+        gin.H{
+            "status":  "Conflict",
+            "message": "Record was changed concurrently, reload it and retry",
+            "error":   db_service.ErrPreconditionFailed.Error(),
+        })
+}
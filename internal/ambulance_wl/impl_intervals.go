@@ -0,0 +1,164 @@
+package ambulance_wl
+
+import (
+    "fmt"
+    "sort"
+    "time"
+)
+
+// timeInterval is a half open interval of time [start, end).
+type timeInterval struct {
+    start time.Time
+    end   time.Time
+}
+
+// validateWeeklyHours validates the time zone and the days and times of
+// weekly recurring hours, e.g. working hours of a doctor.
+func validateWeeklyHours(timeZone string, weeklyHours []WorkingHours) error {
+    if _, err := loadTimeZone(timeZone); err != nil {
+        return fmt.Errorf("unknown time zone %q", timeZone)
+    }
+    for _, hours := range weeklyHours {
+        if _, err := weekday(hours.DayOfWeek); err != nil {
+            return err
+        }
+        start, err := minuteOfDay(hours.Start)
+        if err != nil {
+            return err
+        }
+        end, err := minuteOfDay(hours.End)
+        if err != nil {
+            return err
+        }
+        if end <= start {
+            return fmt.Errorf("hours of %v must end after %v", hours.DayOfWeek, hours.Start)
+        }
+    }
+    return nil
+}
+
+// loadTimeZone resolves the IANA time zone, UTC when it is empty.
+func loadTimeZone(timeZone string) (*time.Location, error) {
+    if timeZone == "" {
+        return time.UTC, nil
+    }
+    return time.LoadLocation(timeZone)
+}
+
+// weeklyIntervals expands weekly recurring hours into the sorted and merged
+// intervals between from and to. The hours are wall clock times in the time
+// zone, so that they hold across daylight saving time changes.
+func weeklyIntervals(weeklyHours []WorkingHours, timeZone string, from time.Time, to time.Time) ([]timeInterval, error) {
+    location, err := loadTimeZone(timeZone)
+    if err != nil {
+        return nil, err
+    }
+
+    intervals := []timeInterval{}
+    first := from.In(location)
+    day := time.Date(first.Year(), first.Month(), first.Day(), 0, 0, 0, 0, location)
+    for ; day.Before(to); day = day.AddDate(0, 0, 1) {
+        for _, hours := range weeklyHours {
+            if dayOfWeek, err := weekday(hours.DayOfWeek); err != nil || dayOfWeek != day.Weekday() {
+                continue
+            }
+            start, startErr := minuteOfDay(hours.Start)
+            end, endErr := minuteOfDay(hours.End)
+            if startErr != nil || endErr != nil {
+                continue
+            }
+            interval := timeInterval{
+                start: time.Date(day.Year(), day.Month(), day.Day(), start/60, start%60, 0, 0, location),
+                end:   time.Date(day.Year(), day.Month(), day.Day(), end/60, end%60, 0, 0, location),
+            }
+            if interval.start.Before(from) {
+                interval.start = from
+            }
+            if interval.end.After(to) {
+                interval.end = to
+            }
+            if interval.start.Before(interval.end) {
+                intervals = append(intervals, interval)
+            }
+        }
+    }
+    return mergeIntervals(intervals), nil
+}
+
+// mergeIntervals sorts the intervals and joins the overlapping and adjacent
+// ones.
+func mergeIntervals(intervals []timeInterval) []timeInterval {
+    sort.SliceStable(intervals, func(i, j int) bool {
+        return intervals[i].start.Before(intervals[j].start)
+    })
+    merged := []timeInterval{}
+    for _, interval := range intervals {
+        last := len(merged) - 1
+        if last >= 0 && !interval.start.After(merged[last].end) {
+            if interval.end.After(merged[last].end) {
+                merged[last].end = interval.end
+            }
+            continue
+        }
+        merged = append(merged, interval)
+    }
+    return merged
+}
+
+// intersectIntervals returns the time covered by both sorted and merged lists
+// of intervals.
+func intersectIntervals(a []timeInterval, b []timeInterval) []timeInterval {
+    result := []timeInterval{}
+    for i, j := 0, 0; i < len(a) && j < len(b); {
+        start, end := a[i].start, a[i].end
+        if b[j].start.After(start) {
+            start = b[j].start
+        }
+        if b[j].end.Before(end) {
+            end = b[j].end
+        }
+        if start.Before(end) {
+            result = append(result, timeInterval{start, end})
+        }
+        if a[i].end.Before(b[j].end) {
+            i++
+        } else {
+            j++
+        }
+    }
+    return result
+}
+
+// subtractIntervals removes the busy intervals, in any order, from the sorted
+// and merged intervals.
+func subtractIntervals(intervals []timeInterval, busy []timeInterval) []timeInterval {
+    busy = mergeIntervals(append([]timeInterval{}, busy...))
+    result := []timeInterval{}
+    for _, interval := range intervals {
+        start := interval.start
+        for _, blocked := range busy {
+            if !blocked.end.After(start) || !blocked.start.Before(interval.end) {
+                continue
+            }
+            if blocked.start.After(start) {
+                result = append(result, timeInterval{start, blocked.start})
+            }
+            start = blocked.end
+        }
+        if start.Before(interval.end) {
+            result = append(result, timeInterval{start, interval.end})
+        }
+    }
+    return result
+}
+
+// coversInterval reports whether one of the merged intervals contains the
+// whole time from start to end.
+func coversInterval(intervals []timeInterval, start time.Time, end time.Time) bool {
+    for _, interval := range intervals {
+        if !interval.start.After(start) && !interval.end.Before(end) {
+            return true
+        }
+    }
+    return false
+}
@@ -1,15 +1,23 @@
 package ambulance_wl
 
 import (
+    "fmt"
     "net/http"
+    "time"
 
     "github.com/gin-gonic/gin"
+    "github.com/google/uuid"
     "github.com/xpokorny/ambulance-webapi/internal/db_service"
 )
 
 type implLocationsAPI struct {
 }
 
+var locationSortFields = sortableFields{
+    "id":   "id",
+    "name": "name",
+}
+
 func NewLocationsAPI() LocationsAPI {
     return &implLocationsAPI{}
 }
@@ -35,31 +43,228 @@ func (api *implLocationsAPI) GetLocations(c *gin.Context) {
         return
     }
 
-    // Get all locations
-    locations := []Location{}
-    cursor, err := locationService.FindAllDocuments(c.Request.Context())
+    query, pageToken, err := unboundedPagingQuery(c, locationSortFields, "name")
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{
+            "error":   err.Error(),
+            "message": "invalid paging parameters",
+            "status":  "Bad Request",
+        })
+        return
+    }
+
+    locations, nextPageToken, err := db_service.FindPage(c.Request.Context(), locationService, query, pageToken)
     if err != nil {
+        pagingError(c, err, "failed to get locations")
+        return
+    }
+
+    recordReads(c, locations, func(location *Location) string { return location.Id })
+    setNextPageLink(c, nextPageToken)
+    c.JSON(http.StatusOK, locations)
+}
+func (api *implLocationsAPI) GetLocation(c *gin.Context) {
+    locationService, ok := dbServiceFromContext[Location](c, "location_service")
+    if !ok {
+        return
+    }
+
+    locationId := c.Param("locationId")
+    location, err := locationService.FindDocument(c.Request.Context(), locationId)
+    switch err {
+    case nil:
+        c.JSON(http.StatusOK, location)
+    case db_service.ErrNotFound:
+        c.JSON(http.StatusNotFound, gin.H{
+            "error":   "location not found",
+            "message": "location with id " + locationId + " not found",
+            "status":  "Not Found",
+        })
+    default:
         c.JSON(http.StatusInternalServerError, gin.H{
-            "error":   "failed to get locations",
+            "error":   "failed to get location",
             "message": err.Error(),
             "status":  "Internal Server Error",
         })
+    }
+}
+
+func (api *implLocationsAPI) CreateLocation(c *gin.Context) {
+    locationService, ok := dbServiceFromContext[Location](c, "location_service")
+    if !ok {
+        return
+    }
+
+    location := Location{}
+    if err := c.ShouldBindJSON(&location); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{
+            "error":   err.Error(),
+            "message": "invalid request body",
+            "status":  "Bad Request",
+        })
+        return
+    }
+    if location.Id == "" || location.Id == "@new" {
+        location.Id = uuid.New().String()
+    }
+    if err := validateLocation(&location); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{
+            "error":   err.Error(),
+            "message": "invalid location",
+            "status":  "Bad Request",
+        })
         return
     }
-    defer cursor.Close(c.Request.Context())
 
-    for cursor.Next(c.Request.Context()) {
-        var location Location
-        if err := cursor.Decode(&location); err != nil {
-            c.JSON(http.StatusInternalServerError, gin.H{
-                "error":   "failed to decode location",
-                "message": err.Error(),
-                "status":  "Internal Server Error",
-            })
-            return
-        }
-        locations = append(locations, location)
+    switch err := locationService.CreateDocument(c.Request.Context(), location.Id, &location); err {
+    case nil:
+        c.JSON(http.StatusCreated, location)
+    case db_service.ErrConflict:
+        c.JSON(http.StatusConflict, gin.H{
+            "error":   err.Error(),
+            "message": "location with id " + location.Id + " already exists",
+            "status":  "Conflict",
+        })
+    default:
+        c.JSON(http.StatusInternalServerError, gin.H{
+            "error":   "failed to create location",
+            "message": err.Error(),
+            "status":  "Internal Server Error",
+        })
     }
+}
 
-    c.JSON(http.StatusOK, locations)
-}
\ No newline at end of file
+func (api *implLocationsAPI) UpdateLocation(c *gin.Context) {
+    locationService, ok := dbServiceFromContext[Location](c, "location_service")
+    if !ok {
+        return
+    }
+    appointmentService, ok := dbServiceFromContext[Appointment](c, "appointment_service")
+    if !ok {
+        return
+    }
+
+    locationId := c.Param("locationId")
+    location := Location{}
+    if err := c.ShouldBindJSON(&location); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{
+            "error":   err.Error(),
+            "message": "invalid request body",
+            "status":  "Bad Request",
+        })
+        return
+    }
+    if location.Id != "" && location.Id != locationId {
+        c.JSON(http.StatusBadRequest, gin.H{
+            "error":   "id mismatch",
+            "message": "location id in the body does not match the path",
+            "status":  "Bad Request",
+        })
+        return
+    }
+    location.Id = locationId
+    if err := validateLocation(&location); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{
+            "error":   err.Error(),
+            "message": "invalid location",
+            "status":  "Bad Request",
+        })
+        return
+    }
+
+    switch err := locationService.UpdateDocument(c.Request.Context(), locationId, &location); err {
+    case nil:
+        // appointments embed a copy of the location, refresh it in the background
+        propagateLocation(locationService, appointmentService, locationId)
+        c.JSON(http.StatusOK, location)
+    case db_service.ErrNotFound:
+        c.JSON(http.StatusNotFound, gin.H{
+            "error":   "location not found",
+            "message": "location with id " + locationId + " not found",
+            "status":  "Not Found",
+        })
+    default:
+        c.JSON(http.StatusInternalServerError, gin.H{
+            "error":   "failed to update location",
+            "message": err.Error(),
+            "status":  "Internal Server Error",
+        })
+    }
+}
+
+func (api *implLocationsAPI) DeleteLocation(c *gin.Context) {
+    locationService, ok := dbServiceFromContext[Location](c, "location_service")
+    if !ok {
+        return
+    }
+    appointmentService, ok := dbServiceFromContext[Appointment](c, "appointment_service")
+    if !ok {
+        return
+    }
+
+    locationId := c.Param("locationId")
+
+    // no appointment can be booked at the location while it is being retired
+    unlock, err := bookingLocks.Lock(c.Request.Context(), "location/"+locationId)
+    if err != nil {
+        c.JSON(http.StatusServiceUnavailable, gin.H{
+            "error":   err.Error(),
+            "message": "location is being booked by another request, retry later",
+            "status":  "Service Unavailable",
+        })
+        return
+    }
+    defer unlock()
+
+    future, err := appointmentService.FindDocuments(c.Request.Context(), db_service.Query{
+        Filter: db_service.And(
+            db_service.Eq("location.id", locationId),
+            db_service.Gte("datetime", time.Now()),
+            activeStatusFilter(),
+        ),
+        Limit: 1,
+    })
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{
+            "error":   "failed to check future appointments",
+            "message": err.Error(),
+            "status":  "Internal Server Error",
+        })
+        return
+    }
+    if len(future) > 0 {
+        c.JSON(http.StatusConflict, gin.H{
+            "error":   "location has future appointments",
+            "message": "appointment " + future[0].Id + " is still booked at the location",
+            "status":  "Conflict",
+        })
+        return
+    }
+
+    switch err := locationService.SoftDeleteDocument(c.Request.Context(), locationId, deleterId(c, ""), db_service.AnyVersion); err {
+    case nil:
+        c.AbortWithStatus(http.StatusNoContent)
+    case db_service.ErrNotFound:
+        c.JSON(http.StatusNotFound, gin.H{
+            "error":   "location not found",
+            "message": "location with id " + locationId + " not found",
+            "status":  "Not Found",
+        })
+    default:
+        c.JSON(http.StatusInternalServerError, gin.H{
+            "error":   "failed to retire location",
+            "message": err.Error(),
+            "status":  "Internal Server Error",
+        })
+    }
+}
+
+func validateLocation(location *Location) error {
+    if location.Name == "" {
+        return fmt.Errorf("name is required")
+    }
+    if location.Address == "" {
+        return fmt.Errorf("address is required")
+    }
+    return validateWeeklyHours(location.TimeZone, location.OpeningHours)
+}
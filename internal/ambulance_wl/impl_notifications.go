@@ -0,0 +1,122 @@
+package ambulance_wl
+
+import (
+    "context"
+    "log"
+    "net/http"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/google/uuid"
+    "github.com/xpokorny/ambulance-webapi/internal/db_service"
+)
+
+const (
+    notificationAppointmentMoved     = "appointment-moved"
+    notificationQueuePositionChanged = "queue-position-changed"
+    notificationSlotOffered          = "slot-offered"
+    notificationSlotOfferExpired     = "slot-offer-expired"
+)
+
+var notificationSortFields = sortableFields{
+    "id":        "id",
+    "createdAt": "createdat",
+}
+
+type implNotificationsAPI struct {
+}
+
+func NewNotificationsAPI() NotificationsAPI {
+    return &implNotificationsAPI{}
+}
+
+func (api *implNotificationsAPI) GetNotifications(c *gin.Context) {
+    notificationService, ok := dbServiceFromContext[Notification](c, "notification_service")
+    if !ok {
+        return
+    }
+
+    query, pageToken, err := pagingQuery(c, notificationSortFields, "-createdAt")
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{
+            "error":   err.Error(),
+            "message": "invalid paging parameters",
+            "status":  "Bad Request",
+        })
+        return
+    }
+
+    filters := []db_service.Filter{db_service.Eq("recipientid", c.Param("userId"))}
+    if c.Query("unread") == "true" {
+        filters = append(filters, db_service.Eq("readat", nil))
+    }
+    query.Filter = db_service.And(filters...)
+
+    notifications, nextPageToken, err := db_service.FindPage(c.Request.Context(), notificationService, query, pageToken)
+    if err != nil {
+        pagingError(c, err, "failed to get notifications")
+        return
+    }
+
+    recordReads(c, notifications, func(notification *Notification) string { return notification.Id })
+    setNextPageLink(c, nextPageToken)
+    c.JSON(http.StatusOK, notifications)
+}
+
+func (api *implNotificationsAPI) MarkNotificationRead(c *gin.Context) {
+    notificationService, ok := dbServiceFromContext[Notification](c, "notification_service")
+    if !ok {
+        return
+    }
+
+    notificationId := c.Param("notificationId")
+    notification, err := notificationService.FindDocument(c.Request.Context(), notificationId)
+    // notifications of other users are not revealed
+    if err == nil && notification.RecipientId != c.Param("userId") {
+        err = db_service.ErrNotFound
+    }
+    if err == db_service.ErrNotFound {
+        c.JSON(http.StatusNotFound, gin.H{
+            "error":   err.Error(),
+            "message": "notification " + notificationId + " not found",
+            "status":  "Not Found",
+        })
+        return
+    }
+    if err == nil && notification.ReadAt == nil {
+        readAt := time.Now().UTC()
+        notification.ReadAt = &readAt
+        err = notificationService.UpdateDocument(c.Request.Context(), notificationId, notification)
+    }
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{
+            "error":   "failed to mark notification as read",
+            "message": err.Error(),
+            "status":  "Internal Server Error",
+        })
+        return
+    }
+    c.JSON(http.StatusOK, notification)
+}
+
+// notify stores a notification for the user. Notifications are a side effect
+// of a change which has already been made, so a failure is only logged and
+// does not fail the request.
+func notify(ctx context.Context, notifications db_service.DbService[Notification], notification Notification) {
+    if notifications == nil || notification.RecipientId == "" {
+        return
+    }
+    notification.Id = uuid.New().String()
+    notification.CreatedAt = time.Now().UTC()
+    notification.ReadAt = nil
+    if err := notifications.CreateDocument(ctx, notification.Id, &notification); err != nil {
+        log.Printf("Failed to notify user %v: %v", notification.RecipientId, err)
+    }
+}
+
+// notificationServiceFromContext returns the notification service or nil when
+// it is not configured, in which case nobody is notified.
+func notificationServiceFromContext(c *gin.Context) db_service.DbService[Notification] {
+    notifications, _ := c.Value("notification_service").(db_service.DbService[Notification])
+    return notifications
+}
@@ -0,0 +1,90 @@
+package ambulance_wl
+
+import (
+    "fmt"
+    "net/http"
+    "strconv"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+    "github.com/xpokorny/ambulance-webapi/internal/db_service"
+)
+
+const (
+    defaultPageLimit = 100
+    maxPageLimit     = 500
+)
+
+// sortableFields maps sort fields accepted by a list endpoint to the names of
+// the stored fields.
+type sortableFields map[string]string
+
+// pagingQuery reads the limit, pageToken and sort query parameters shared by
+// all list endpoints. The sort parameter is a comma separated list of fields,
+// a field prefixed with "-" is sorted in descending order.
+func pagingQuery(c *gin.Context, fields sortableFields, defaultSort string) (db_service.Query, string, error) {
+    query := db_service.Query{Limit: defaultPageLimit}
+
+    if limit := c.Query("limit"); limit != "" {
+        value, err := strconv.Atoi(limit)
+        if err != nil || value < 1 || value > maxPageLimit {
+            return query, "", fmt.Errorf("limit must be a number between 1 and %v", maxPageLimit)
+        }
+        query.Limit = int64(value)
+    }
+
+    sort := c.DefaultQuery("sort", defaultSort)
+    for _, name := range strings.Split(sort, ",") {
+        name = strings.TrimSpace(name)
+        descending := strings.HasPrefix(name, "-")
+        name = strings.TrimPrefix(name, "-")
+        field, ok := fields[name]
+        if !ok {
+            return query, "", fmt.Errorf("cannot sort by %q", name)
+        }
+        query.Sort = append(query.Sort, db_service.SortField{Field: field, Descending: descending})
+    }
+
+    return query, c.Query("pageToken"), nil
+}
+
+// unboundedPagingQuery is pagingQuery of the list endpoints that returned all
+// items before paging was introduced. Their clients are not expected to follow
+// the Link header, so the page limit applies only when the client asks for
+// paging with limit or pageToken.
+func unboundedPagingQuery(c *gin.Context, fields sortableFields, defaultSort string) (db_service.Query, string, error) {
+    query, pageToken, err := pagingQuery(c, fields, defaultSort)
+    if err == nil && c.Query("limit") == "" && pageToken == "" {
+        query.Limit = 0
+    }
+    return query, pageToken, err
+}
+
+// setNextPageLink advertises the next page in the Link header of the response.
+func setNextPageLink(c *gin.Context, nextPageToken string) {
+    if nextPageToken == "" {
+        return
+    }
+    next := *c.Request.URL
+    values := next.Query()
+    values.Set("pageToken", nextPageToken)
+    next.RawQuery = values.Encode()
+    c.Header("Link", fmt.Sprintf("<%s>; rel=\"next\"", next.RequestURI()))
+}
+
+// pagingError reports invalid paging parameters or a failed list query.
+func pagingError(c *gin.Context, err error, message string) {
+    if err == db_service.ErrInvalidPageToken {
+        c.JSON(http.StatusBadRequest, gin.H{
+            "error":   err.Error(),
+            "message": "pageToken does not match the requested sort order or is malformed",
+            "status":  "Bad Request",
+        })
+        return
+    }
+    c.JSON(http.StatusInternalServerError, gin.H{
+        "error":   err.Error(),
+        "message": message,
+        "status":  "Internal Server Error",
+    })
+}
@@ -0,0 +1,161 @@
+package ambulance_wl
+
+import (
+    "context"
+    "log"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/xpokorny/ambulance-webapi/internal/db_service"
+)
+
+const (
+    propagationTimeout  = 5 * time.Minute
+    propagationPageSize = 100
+    propagationRetries  = 3
+)
+
+// propagationLocks serializes propagation jobs of the same user or location,
+// so that a job started for an older rename cannot overwrite a newer one.
+var propagationLocks = newKeyedMutex()
+
+// propagateUser starts a background job copying the current name and role of
+// the user into all appointments embedding the user as patient, doctor or
+// creator. The status history of the appointments is left unchanged, it keeps
+// the snapshot of the user at the time of every status change.
+func propagateUser(users db_service.DbService[User], appointments db_service.DbService[Appointment], userId string) {
+    go func() {
+        ctx, cancel := context.WithTimeout(context.Background(), propagationTimeout)
+        defer cancel()
+
+        unlock := propagationLocks.Lock("user/" + userId)
+        defer unlock()
+
+        user, err := users.FindDocument(ctx, userId)
+        if err != nil {
+            log.Printf("Failed to propagate changes of user %v: %v", userId, err)
+            return
+        }
+        current := User{Id: user.Id, Name: user.Name, Role: user.Role}
+
+        filter := db_service.Or(
+            db_service.Eq("patient.id", userId),
+            db_service.Eq("doctor.id", userId),
+            db_service.Eq("createdby.id", userId),
+            db_service.Eq("updatedby.id", userId),
+        )
+        propagate(ctx, appointments, filter, "user "+userId, func(appointment *Appointment) bool {
+            changed := false
+            for _, reference := range []*User{&appointment.Patient, &appointment.Doctor, &appointment.CreatedBy, &appointment.UpdatedBy} {
+                if reference.Id == userId && *reference != current {
+                    *reference = current
+                    changed = true
+                }
+            }
+            return changed
+        })
+    }()
+}
+
+// propagateLocation starts a background job copying the current name and
+// address of the location into all appointments taking place there.
+func propagateLocation(locations db_service.DbService[Location], appointments db_service.DbService[Appointment], locationId string) {
+    go func() {
+        ctx, cancel := context.WithTimeout(context.Background(), propagationTimeout)
+        defer cancel()
+
+        unlock := propagationLocks.Lock("location/" + locationId)
+        defer unlock()
+
+        location, err := locations.FindDocument(ctx, locationId)
+        if err != nil {
+            log.Printf("Failed to propagate changes of location %v: %v", locationId, err)
+            return
+        }
+
+        current := locationReference(location)
+
+        filter := db_service.Eq("location.id", locationId)
+        propagate(ctx, appointments, filter, "location "+locationId, func(appointment *Appointment) bool {
+            if appointment.Location.Name == current.Name && appointment.Location.Address == current.Address {
+                return false
+            }
+            appointment.Location = current
+            return true
+        })
+    }()
+}
+
+// propagateAppointmentReferences refreshes the users and the location of the
+// appointment, e.g. after it is restored.
+func propagateAppointmentReferences(c *gin.Context, appointment *Appointment) {
+    users, usersOk := c.Value("user_service").(db_service.DbService[User])
+    locations, locationsOk := c.Value("location_service").(db_service.DbService[Location])
+    appointments, appointmentsOk := c.Value("appointment_service").(db_service.DbService[Appointment])
+    if !usersOk || !locationsOk || !appointmentsOk {
+        log.Printf("Cannot propagate references of appointment %v, db services are missing", appointment.Id)
+        return
+    }
+    for _, userId := range []string{appointment.Patient.Id, appointment.Doctor.Id, appointment.CreatedBy.Id, appointment.UpdatedBy.Id} {
+        if userId != "" {
+            propagateUser(users, appointments, userId)
+        }
+    }
+    if appointment.Location.Id != "" {
+        propagateLocation(locations, appointments, appointment.Location.Id)
+    }
+}
+
+// propagate applies the change to all appointments matching the filter, page
+// by page. An appointment changed concurrently is read again and the change
+// is retried, so that no other update of the appointment is lost.
+func propagate(
+    ctx context.Context,
+    appointments db_service.DbService[Appointment],
+    filter db_service.Filter,
+    subject string,
+    change func(appointment *Appointment) bool,
+) {
+    query := db_service.Query{Filter: filter, Limit: propagationPageSize}
+    updated := 0
+    pageToken := ""
+    for {
+        page, nextPageToken, err := db_service.FindPage(ctx, appointments, query, pageToken)
+        if err != nil {
+            log.Printf("Failed to propagate changes of %v: %v", subject, err)
+            return
+        }
+
+        for i := range page {
+            appointment := &page[i]
+            for attempt := 1; ; attempt++ {
+                if !change(appointment) {
+                    break
+                }
+                err = appointments.ReplaceDocumentIfVersion(ctx, appointment.Id, appointment.Version, appointment)
+                if err == nil {
+                    updated++
+                    break
+                }
+                if err == db_service.ErrPreconditionFailed && attempt < propagationRetries {
+                    appointment, err = appointments.FindDocument(ctx, appointment.Id)
+                    if err == nil {
+                        continue
+                    }
+                }
+                if err != db_service.ErrNotFound {
+                    log.Printf("Failed to propagate changes of %v to appointment %v: %v", subject, page[i].Id, err)
+                }
+                break
+            }
+        }
+
+        if nextPageToken == "" {
+            break
+        }
+        pageToken = nextPageToken
+    }
+    if updated > 0 {
+        log.Printf("Propagated changes of %v to %v appointments", subject, updated)
+    }
+}
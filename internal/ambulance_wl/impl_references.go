@@ -0,0 +1,210 @@
+package ambulance_wl
+
+import (
+    "context"
+    "fmt"
+    "net/http"
+
+    "github.com/gin-gonic/gin"
+    "github.com/xpokorny/ambulance-webapi/internal/auth"
+    "github.com/xpokorny/ambulance-webapi/internal/db_service"
+)
+
+// invalidReferenceError reports an appointment referring to an unknown
+// user or location, or to a user of a wrong role.
+type invalidReferenceError struct {
+    message string
+}
+
+func (e invalidReferenceError) Error() string {
+    return e.message
+}
+
+// resolveAppointmentReferences replaces the embedded patient, doctor and
+// location of the appointment with their stored data, so that the client
+// cannot store made up names. Deactivated users and retired
+// locations cannot be newly referenced, references unchanged since the
+// previous version of the appointment are kept even then.
+func resolveAppointmentReferences(
+    ctx context.Context,
+    users db_service.DbService[User],
+    locations db_service.DbService[Location],
+    appointment *Appointment,
+    previous *Appointment,
+) error {
+    if previous == nil {
+        previous = &Appointment{}
+    }
+
+    if err := resolveUserReference(ctx, users, "patient", &appointment.Patient, previous.Patient, "patient"); err != nil {
+        return err
+    }
+    if err := resolveUserReference(ctx, users, "doctor", &appointment.Doctor, previous.Doctor, "doctor"); err != nil {
+        return err
+    }
+
+    return resolveLocationReference(ctx, locations, &appointment.Location, previous.Location)
+}
+
+// resolveUserReference replaces the reference with the stored user, which
+// must exist, be active and have the role unless the role is empty. The
+// previous reference is kept as it is when the reference did not change.
+func resolveUserReference(
+    ctx context.Context,
+    users db_service.DbService[User],
+    field string,
+    reference *User,
+    previousReference User,
+    role string,
+) error {
+    if reference.Id == "" {
+        return invalidReferenceError{field + ".id is required"}
+    }
+    if reference.Id == previousReference.Id {
+        *reference = previousReference
+        return nil
+    }
+    user, err := users.FindDocument(ctx, reference.Id)
+    switch {
+    case err == db_service.ErrNotFound:
+        return invalidReferenceError{fmt.Sprintf("%v %v does not exist", field, reference.Id)}
+    case err != nil:
+        return err
+    case role != "" && user.Role != role:
+        return invalidReferenceError{fmt.Sprintf("%v %v is not a %v", field, reference.Id, role)}
+    case user.Deactivated:
+        return invalidReferenceError{fmt.Sprintf("%v %v is deactivated", field, reference.Id)}
+    }
+    *reference = User{Id: user.Id, Name: user.Name, Role: user.Role}
+    return nil
+}
+
+// callerReference returns the reference of the authenticated caller with its
+// stored name and role. Staff accounts may exist only at the identity
+// provider, their reference is built from the token then. The reference is
+// empty when authentication is disabled.
+func callerReference(c *gin.Context, users db_service.DbService[User]) (User, error) {
+    userId, role, ok := auth.Caller(c)
+    if !ok {
+        return User{}, nil
+    }
+    user, err := users.FindDocument(c, userId)
+    switch err {
+    case nil:
+        return User{Id: user.Id, Name: user.Name, Role: user.Role}, nil
+    case db_service.ErrNotFound:
+        return User{Id: userId, Role: role}, nil
+    default:
+        return User{}, err
+    }
+}
+
+// creatorReference returns the caller creating a record. Without
+// authentication the creator claimed by the client is resolved instead.
+func creatorReference(c *gin.Context, users db_service.DbService[User], claimed User) (User, error) {
+    return claimedReference(c, users, "createdBy", claimed)
+}
+
+// changerReference returns the caller changing the status of a record.
+// Without authentication the user claimed in changedBy is resolved instead.
+func changerReference(c *gin.Context, users db_service.DbService[User], claimed User) (User, error) {
+    return claimedReference(c, users, "changedBy", claimed)
+}
+
+func claimedReference(c *gin.Context, users db_service.DbService[User], field string, claimed User) (User, error) {
+    caller, err := callerReference(c, users)
+    if err != nil || caller.Id != "" {
+        return caller, err
+    }
+    err = resolveUserReference(c, users, field, &claimed, User{}, "")
+    return claimed, err
+}
+
+// editorReference returns the caller changing a record, or the fallback when
+// authentication is disabled.
+func editorReference(c *gin.Context, users db_service.DbService[User], fallback User) (User, error) {
+    editor, err := callerReference(c, users)
+    if err == nil && editor.Id == "" {
+        editor = fallback
+    }
+    return editor, err
+}
+
+// deleterId returns the caller deleting a record. Without authentication the
+// deleter claimed by the client is recorded instead.
+func deleterId(c *gin.Context, claimed string) string {
+    if userId, _, authenticated := auth.Caller(c); authenticated {
+        return userId
+    }
+    return claimed
+}
+
+// resolveLocationReference replaces the reference with the stored location,
+// which must exist and not be retired unless the reference did not change.
+func resolveLocationReference(
+    ctx context.Context,
+    locations db_service.DbService[Location],
+    reference *Location,
+    previousReference Location,
+) error {
+    if reference.Id == "" {
+        return invalidReferenceError{"location.id is required"}
+    }
+    if reference.Id == previousReference.Id {
+        *reference = previousReference
+        return nil
+    }
+    location, err := locations.FindDocument(ctx, reference.Id)
+    if err == db_service.ErrNotFound {
+        return missingLocationError(ctx, locations, reference.Id)
+    }
+    if err != nil {
+        return err
+    }
+    *reference = locationReference(location)
+    return nil
+}
+
+// missingLocationError explains why the location was not found, retired
+// locations are soft deleted and hidden by FindDocument.
+func missingLocationError(ctx context.Context, locations db_service.DbService[Location], locationId string) error {
+    retired, err := locations.FindDocuments(ctx, db_service.Query{
+        Filter:         db_service.And(db_service.Eq("id", locationId), db_service.Ne(db_service.DeletedAtField, nil)),
+        Limit:          1,
+        IncludeDeleted: true,
+    })
+    switch {
+    case err != nil:
+        return err
+    case len(retired) > 0:
+        return invalidReferenceError{fmt.Sprintf("location %v is retired", locationId)}
+    default:
+        return invalidReferenceError{fmt.Sprintf("location %v does not exist", locationId)}
+    }
+}
+
+// locationReference returns the part of the location embedded in
+// appointments.
+func locationReference(location *Location) Location {
+    return Location{Id: location.Id, Name: location.Name, Address: location.Address}
+}
+
+func referencesErrorResponse(c *gin.Context, err error) {
+    if _, ok := err.(invalidReferenceError); ok {
+        c.JSON(
+            http.StatusUnprocessableEntity,
+            gin.H{
+                "status":  "Unprocessable Entity",
+                "message": "Referenced users or location are invalid",
+                "error":   err.Error(),
+            })
+        return
+    }
+    c.JSON(
+        http.StatusBadGateway,
+        gin.H{
+            "status":  "Bad Gateway",
+            "message": "Failed to resolve users and location of the appointment",
+            "error":   err.Error(),
+        })
+}
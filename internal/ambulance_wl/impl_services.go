@@ -0,0 +1,52 @@
+package ambulance_wl
+
+import (
+    "context"
+    "log"
+    "net/http"
+
+    "github.com/gin-gonic/gin"
+    "github.com/xpokorny/ambulance-webapi/internal/db_service"
+)
+
+// dbServiceFromContext returns the db service registered in the gin context
+// under the given name. When the service is missing or of other type, it
+// responds with 500 Internal Server Error and returns false.
+func dbServiceFromContext[DocType interface{}](c *gin.Context, name string) (db_service.DbService[DocType], bool) {
+    value, exists := c.Get(name)
+    if !exists {
+        c.JSON(
+            http.StatusInternalServerError,
+            gin.H{
+                "status":  "Internal Server Error",
+                "message": name + " not found",
+                "error":   name + " not found",
+            })
+        return nil, false
+    }
+
+    db, ok := value.(db_service.DbService[DocType])
+    if !ok {
+        c.JSON(
+            http.StatusInternalServerError,
+            gin.H{
+                "status":  "Internal Server Error",
+                "message": name + " context is not of type db_service.DbService",
+                "error":   "cannot cast " + name + " context to db_service.DbService",
+            })
+        return nil, false
+    }
+    return db, true
+}
+
+// undoCreated removes the documents created by a change which failed before
+// it was complete, so that no half-done change is left behind. They are
+// removed even when the request was cancelled.
+func undoCreated[DocType interface{}](ctx context.Context, db db_service.DbService[DocType], ids ...string) {
+    ctx = context.WithoutCancel(ctx)
+    for _, id := range ids {
+        if err := db.DeleteDocument(ctx, id); err != nil && err != db_service.ErrNotFound {
+            log.Printf("Failed to remove %v created by a failed change: %v", id, err)
+        }
+    }
+}
@@ -0,0 +1,206 @@
+package ambulance_wl
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/xpokorny/ambulance-webapi/internal/auth"
+    "github.com/xpokorny/ambulance-webapi/internal/db_service"
+)
+
+// testCallerHeader names the caller of a test request as "userId/role", it
+// replaces the bearer token checked by the authentication middleware.
+const testCallerHeader = "X-Test-Caller"
+
+// callers of the test requests, the users exist in the seeded test data
+// except for the administrator, who exists only at the identity provider
+const (
+    asPatient      = "user1/patient"
+    asOtherPatient = "user2/patient"
+    asDoctor       = "user5/doctor"
+    asOtherDoctor  = "user6/doctor"
+    asReceptionist = "recep/receptionist"
+    asAdmin        = "admin/admin"
+    anonymous      = ""
+)
+
+var useTestBookingLeases sync.Once
+
+// testServer is the API wired as in main over the in-memory backend.
+type testServer struct {
+    engine       *gin.Engine
+    appointments db_service.DbService[Appointment]
+    series       db_service.DbService[AppointmentSeries]
+    users        db_service.DbService[User]
+    locations    db_service.DbService[Location]
+    availability db_service.DbService[Availability]
+    waitingList  db_service.DbService[WaitingListEntry]
+    waitlist     db_service.DbService[WaitlistRequest]
+    audit        db_service.DbService[AuditEntry]
+}
+
+// newTestServer returns the API with seeded users and locations. Without
+// authentication it runs as with AMBULANCE_API_AUTH_DISABLED, otherwise the
+// caller of every request is taken from testCallerHeader.
+func newTestServer(t *testing.T, authenticated bool) *testServer {
+    t.Helper()
+    gin.SetMode(gin.TestMode)
+
+    audit := db_service.NewAppendOnly(db_service.NewMemoryService[AuditEntry]())
+    record := NewAuditRecorder(audit)
+    s := &testServer{
+        appointments: db_service.NewAuditing(db_service.NewMemoryService[Appointment](), "appointments", record),
+        series:       db_service.NewAuditing(db_service.NewMemoryService[AppointmentSeries](), "appointment_series", record),
+        users:        db_service.NewAuditing(db_service.NewMemoryService[User](), "users", record),
+        locations:    db_service.NewAuditing(db_service.NewMemoryService[Location](), "locations", record),
+        availability: db_service.NewAuditing(db_service.NewMemoryService[Availability](), "availability", record),
+        waitingList:  db_service.NewAuditing(db_service.NewMemoryService[WaitingListEntry](), "waiting_list", record),
+        waitlist:     db_service.NewAuditing(db_service.NewMemoryService[WaitlistRequest](), "waitlist", record),
+        audit:        audit,
+    }
+    notifications := db_service.NewMemoryService[Notification]()
+    apiKeys := db_service.NewMemoryService[ApiKeyRecord]()
+    // the booking locks are global, background jobs of earlier tests may
+    // still hold them
+    useTestBookingLeases.Do(func() {
+        UseBookingLeases(db_service.NewMemoryService[BookingLease]())
+    })
+
+    ctx := context.Background()
+    for _, user := range []User{
+        {Id: "user1", Name: "John Doe", Role: rolePatient},
+        {Id: "user2", Name: "Jane Smith", Role: rolePatient},
+        {Id: "user3", Name: "Robert Johnson", Role: rolePatient, Deactivated: true},
+        {Id: "user5", Name: "Dr. Michael Brown", Role: roleDoctor},
+        {Id: "user6", Name: "Dr. Sarah Wilson", Role: roleDoctor},
+        {Id: "recep", Name: "Mary Front", Role: roleReceptionist},
+    } {
+        if err := s.users.CreateDocument(ctx, user.Id, &user); err != nil {
+            t.Fatal(err)
+        }
+    }
+    for _, location := range []Location{
+        {Id: "loc1", Name: "Room 101", Address: "Main Building, Floor 1"},
+        {Id: "loc2", Name: "Room 202", Address: "Main Building, Floor 2"},
+    } {
+        if err := s.locations.CreateDocument(ctx, location.Id, &location); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    handleFunctions := ApiHandleFunctions{
+        AppointmentsAPI:  NewAppointmentsAPI(),
+        UsersAPI:         NewUsersAPI(),
+        LocationsAPI:     NewLocationsAPI(),
+        AvailabilityAPI:  NewAvailabilityAPI(),
+        SlotsAPI:         NewSlotsAPI(),
+        WaitingListAPI:   NewWaitingListAPI(),
+        NotificationsAPI: NewNotificationsAPI(),
+        WaitlistAPI:      NewWaitlistAPI(),
+        ApiKeysAPI:       NewApiKeysAPI(),
+        AuditAPI:         NewAuditAPI(),
+    }
+    s.engine = gin.New()
+    s.engine.Use(NewAuditMiddleware(handleFunctions, audit))
+    if authenticated {
+        s.engine.Use(func(c *gin.Context) {
+            userId, role, found := strings.Cut(c.GetHeader(testCallerHeader), "/")
+            if !found {
+                c.AbortWithStatus(http.StatusUnauthorized)
+                return
+            }
+            c.Set(auth.UserIdKey, userId)
+            c.Set(auth.RoleKey, role)
+            c.Next()
+        })
+    }
+    s.engine.Use(func(c *gin.Context) {
+        c.Set("appointment_service", s.appointments)
+        c.Set("appointment_series_service", s.series)
+        c.Set("user_service", s.users)
+        c.Set("location_service", s.locations)
+        c.Set("availability_service", s.availability)
+        c.Set("waiting_list_service", s.waitingList)
+        c.Set("waitlist_service", s.waitlist)
+        c.Set("notification_service", notifications)
+        c.Set("api_key_service", apiKeys)
+        c.Set("audit_service", audit)
+        c.Next()
+    })
+    if authenticated {
+        s.engine.Use(NewAuthorizationMiddleware(handleFunctions))
+    }
+    NewRouterWithGinEngine(s.engine, handleFunctions)
+    return s
+}
+
+// call sends the request as the caller, the body is encoded as JSON unless
+// it is nil. Headers are given as name and value pairs.
+func (s *testServer) call(method string, path string, caller string, body interface{}, headers ...string) *httptest.ResponseRecorder {
+    var reader *bytes.Reader
+    if body == nil {
+        reader = bytes.NewReader(nil)
+    } else {
+        encoded, err := json.Marshal(body)
+        if err != nil {
+            panic(err)
+        }
+        reader = bytes.NewReader(encoded)
+    }
+    request := httptest.NewRequest(method, path, reader)
+    request.Header.Set("Content-Type", "application/json")
+    if caller != anonymous {
+        request.Header.Set(testCallerHeader, caller)
+    }
+    for i := 0; i+1 < len(headers); i += 2 {
+        request.Header.Set(headers[i], headers[i+1])
+    }
+    response := httptest.NewRecorder()
+    s.engine.ServeHTTP(response, request)
+    return response
+}
+
+// book creates an appointment as the caller and fails the test unless it is
+// created.
+func (s *testServer) book(t *testing.T, caller string, appointment Appointment) Appointment {
+    t.Helper()
+    response := s.call(http.MethodPost, "/api/appointments", caller, appointment)
+    if response.Code != http.StatusCreated {
+        t.Fatalf("booking of %+v = %v %v", appointment, response.Code, response.Body.String())
+    }
+    return decodeResponse[Appointment](t, response)
+}
+
+func decodeResponse[T interface{}](t *testing.T, response *httptest.ResponseRecorder) T {
+    t.Helper()
+    var value T
+    if err := json.Unmarshal(response.Body.Bytes(), &value); err != nil {
+        t.Fatalf("cannot decode response %v: %v", response.Body.String(), err)
+    }
+    return value
+}
+
+// testAppointment returns an appointment of the patient user1 with the
+// doctor user5 in loc1, starting at the given time on Monday 2030-01-07,
+// which is within the default working hours.
+func testAppointment(clock string) Appointment {
+    start, err := time.Parse(time.RFC3339, "2030-01-07T"+clock+":00+01:00")
+    if err != nil {
+        panic(err)
+    }
+    return Appointment{
+        Patient:  User{Id: "user1"},
+        Doctor:   User{Id: "user5"},
+        Location: Location{Id: "loc1"},
+        DateTime: start,
+        Type:     "consultation",
+    }
+}
@@ -0,0 +1,248 @@
+package ambulance_wl
+
+import (
+    "fmt"
+    "net/http"
+    "strconv"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/xpokorny/ambulance-webapi/internal/db_service"
+)
+
+const (
+    defaultSlotSearchRange = 7 * 24 * time.Hour
+    maxSlotSearchRange     = 31 * 24 * time.Hour
+)
+
+type implSlotsAPI struct {
+    types appointmentTypes
+}
+
+func NewSlotsAPI() SlotsAPI {
+    return &implSlotsAPI{
+        types: loadAppointmentTypes(),
+    }
+}
+
+func (api *implSlotsAPI) GetSlots(c *gin.Context) {
+    userService, ok := dbServiceFromContext[User](c, "user_service")
+    if !ok {
+        return
+    }
+    locationService, ok := dbServiceFromContext[Location](c, "location_service")
+    if !ok {
+        return
+    }
+    availabilityService, ok := dbServiceFromContext[Availability](c, "availability_service")
+    if !ok {
+        return
+    }
+    appointmentService, ok := dbServiceFromContext[Appointment](c, "appointment_service")
+    if !ok {
+        return
+    }
+
+    search, err := api.slotSearch(c)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{
+            "error":   err.Error(),
+            "message": "invalid slot search parameters",
+            "status":  "Bad Request",
+        })
+        return
+    }
+
+    ctx := c.Request.Context()
+    doctor, err := userService.FindDocument(ctx, search.doctorId)
+    if err == nil && (doctor.Role != "doctor" || doctor.Deactivated) {
+        err = db_service.ErrNotFound
+    }
+    var location *Location
+    if err == nil {
+        location, err = locationService.FindDocument(ctx, search.locationId)
+    }
+    if err != nil {
+        slotsErrorResponse(c, err, "doctor or location not found")
+        return
+    }
+
+    availability, _, err := findAvailability(ctx, availabilityService, search.doctorId)
+    if err != nil {
+        slotsErrorResponse(c, err, "failed to get availability of the doctor")
+        return
+    }
+
+    free, err := weeklyIntervals(availability.WeeklySchedule, availability.TimeZone, search.from, search.to)
+    if err == nil && len(location.OpeningHours) > 0 {
+        var openingHours []timeInterval
+        openingHours, err = weeklyIntervals(location.OpeningHours, location.TimeZone, search.from, search.to)
+        free = intersectIntervals(free, openingHours)
+    }
+    if err != nil {
+        slotsErrorResponse(c, err, "failed to compute working hours")
+        return
+    }
+
+    busy := []timeInterval{}
+    for _, exception := range availability.Exceptions {
+        busy = append(busy, timeInterval{exception.From, exception.To})
+    }
+    appointments, err := api.bookedAppointments(c, appointmentService, search)
+    if err != nil {
+        slotsErrorResponse(c, err, "failed to get booked appointments")
+        return
+    }
+    for _, appointment := range appointments {
+        end := appointment.EndDateTime
+        if end.IsZero() {
+            end = appointment.DateTime.Add(time.Duration(api.types.defaultDuration) * time.Minute)
+        }
+        busy = append(busy, timeInterval{appointment.DateTime, end})
+    }
+    holds, err := heldSlots(c, search)
+    if err != nil {
+        slotsErrorResponse(c, err, "failed to get slots held for the waitlist")
+        return
+    }
+    for _, hold := range holds {
+        busy = append(busy, timeInterval{hold.Offer.Start, hold.Offer.End})
+    }
+    free = subtractIntervals(free, busy)
+
+    slots := []Slot{}
+    for _, interval := range free {
+        start := interval.start
+        for ; !start.Add(search.duration).After(interval.end) && int64(len(slots)) < search.limit; start = start.Add(search.duration) {
+            slots = append(slots, Slot{
+                Start:      start.UTC(),
+                End:        start.Add(search.duration).UTC(),
+                DoctorId:   search.doctorId,
+                LocationId: search.locationId,
+            })
+        }
+    }
+    c.JSON(http.StatusOK, slots)
+}
+
+type slotSearch struct {
+    doctorId   string
+    locationId string
+    from       time.Time
+    to         time.Time
+    duration   time.Duration
+    limit      int64
+}
+
+// slotSearch reads the query parameters of the slot search. The search starts
+// now and spans a week unless told otherwise, slots last as long as an
+// appointment without a type.
+func (api *implSlotsAPI) slotSearch(c *gin.Context) (slotSearch, error) {
+    search := slotSearch{
+        doctorId:   c.Query("doctorId"),
+        locationId: c.Query("locationId"),
+        from:       time.Now().UTC().Truncate(time.Minute).Add(time.Minute),
+        duration:   time.Duration(api.types.defaultDuration) * time.Minute,
+        limit:      defaultPageLimit,
+    }
+    if search.doctorId == "" || search.locationId == "" {
+        return search, fmt.Errorf("doctorId and locationId are required")
+    }
+
+    if from := c.Query("from"); from != "" {
+        value, err := time.Parse(time.RFC3339, from)
+        if err != nil {
+            return search, fmt.Errorf("from must be RFC3339 date-time")
+        }
+        search.from = value
+    }
+    search.to = search.from.Add(defaultSlotSearchRange)
+    if to := c.Query("to"); to != "" {
+        value, err := time.Parse(time.RFC3339, to)
+        if err != nil {
+            return search, fmt.Errorf("to must be RFC3339 date-time")
+        }
+        search.to = value
+    }
+    if !search.to.After(search.from) || search.to.Sub(search.from) > maxSlotSearchRange {
+        return search, fmt.Errorf("to must be after from and at most 31 days later")
+    }
+
+    if duration := c.Query("duration"); duration != "" {
+        value, err := strconv.Atoi(duration)
+        if err != nil || value < 1 || value > 24*60 {
+            return search, fmt.Errorf("duration must be a number of minutes between 1 and 1440")
+        }
+        search.duration = time.Duration(value) * time.Minute
+    }
+
+    if limit := c.Query("limit"); limit != "" {
+        value, err := strconv.Atoi(limit)
+        if err != nil || value < 1 || value > maxPageLimit {
+            return search, fmt.Errorf("limit must be a number between 1 and %v", maxPageLimit)
+        }
+        search.limit = int64(value)
+    }
+    return search, nil
+}
+
+// bookedAppointments returns active appointments of the doctor or at the
+// location overlapping the searched time range.
+func (api *implSlotsAPI) bookedAppointments(c *gin.Context, db db_service.DbService[Appointment], search slotSearch) ([]Appointment, error) {
+    legacyDuration := time.Duration(api.types.defaultDuration) * time.Minute
+    return db.FindDocuments(c.Request.Context(), db_service.Query{
+        Filter: db_service.And(
+            activeStatusFilter(),
+            db_service.Lt("datetime", search.to),
+            db_service.Or(
+                db_service.Gt("enddatetime", search.from),
+                db_service.And(
+                    db_service.Eq("enddatetime", nil),
+                    db_service.Gt("datetime", search.from.Add(-legacyDuration)),
+                ),
+            ),
+            db_service.Or(
+                db_service.Eq("doctor.id", search.doctorId),
+                db_service.Eq("location.id", search.locationId),
+            ),
+        ),
+    })
+}
+
+// heldSlots returns the waitlist requests holding a slot of the doctor or at
+// the location within the searched time range, such slots cannot be booked
+// until the offer is accepted, declined or expires.
+func heldSlots(c *gin.Context, search slotSearch) ([]WaitlistRequest, error) {
+    waitlist, _ := c.Value("waitlist_service").(db_service.DbService[WaitlistRequest])
+    if waitlist == nil {
+        return nil, nil
+    }
+    return waitlist.FindDocuments(c.Request.Context(), db_service.Query{
+        Filter: db_service.And(
+            db_service.Eq("status", waitlistStatusOffered),
+            db_service.Gt("offer.expiresat", time.Now()),
+            db_service.Lt("offer.start", search.to),
+            db_service.Gt("offer.end", search.from),
+            db_service.Or(
+                db_service.Eq("doctor.id", search.doctorId),
+                db_service.Eq("offer.locationid", search.locationId),
+            ),
+        ),
+    })
+}
+
+func slotsErrorResponse(c *gin.Context, err error, message string) {
+    if err == db_service.ErrNotFound {
+        c.JSON(http.StatusNotFound, gin.H{
+            "error":   err.Error(),
+            "message": message,
+            "status":  "Not Found",
+        })
+        return
+    }
+    c.JSON(http.StatusInternalServerError, gin.H{
+        "error":   message,
+        "message": err.Error(),
+        "status":  "Internal Server Error",
+    })
+}
@@ -0,0 +1,69 @@
+package ambulance_wl
+
+import (
+    "fmt"
+    "strings"
+
+    "github.com/xpokorny/ambulance-webapi/internal/db_service"
+)
+
+// triage urgencies modelled after the Manchester triage system, from the
+// most to the least urgent
+const (
+    urgencyImmediate  = "immediate"
+    urgencyVeryUrgent = "very-urgent"
+    urgencyUrgent     = "urgent"
+    urgencyStandard   = "standard"
+    urgencyNonUrgent  = "non-urgent"
+)
+
+// urgencyPriorities maps the urgencies to priorities, more urgent cases have
+// higher priority and are served first.
+var urgencyPriorities = map[string]int32{
+    urgencyImmediate:  5,
+    urgencyVeryUrgent: 4,
+    urgencyUrgent:     3,
+    urgencyStandard:   2,
+    urgencyNonUrgent:  1,
+}
+
+// applyUrgency defaults the urgency of the appointment to standard, validates
+// it and derives the priority the appointments are sorted by.
+func applyUrgency(appointment *Appointment) error {
+    if appointment.Urgency == "" {
+        appointment.Urgency = urgencyStandard
+    }
+    priority, ok := urgencyPriorities[appointment.Urgency]
+    if !ok {
+        return fmt.Errorf("unknown urgency %q", appointment.Urgency)
+    }
+    appointment.Priority = priority
+    return nil
+}
+
+// appointmentPriority returns the priority of a stored appointment, which is
+// standard for appointments stored before urgencies were introduced.
+func appointmentPriority(appointment *Appointment) int32 {
+    if priority, ok := urgencyPriorities[appointment.Urgency]; ok {
+        return priority
+    }
+    return urgencyPriorities[urgencyStandard]
+}
+
+// urgencyFilter translates a comma separated list of urgencies into a filter
+// of the given stored field. Records stored before urgencies were introduced
+// are standard.
+func urgencyFilter(field string, urgencies string) (db_service.Filter, error) {
+    values := []interface{}{}
+    for _, value := range strings.Split(urgencies, ",") {
+        value = strings.TrimSpace(value)
+        if _, ok := urgencyPriorities[value]; !ok {
+            return db_service.Filter{}, fmt.Errorf("unknown urgency %q", value)
+        }
+        values = append(values, value)
+        if value == urgencyStandard {
+            values = append(values, nil)
+        }
+    }
+    return db_service.In(field, values...), nil
+}
@@ -0,0 +1,432 @@
+package ambulance_wl
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "net/http"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/google/uuid"
+    "github.com/xpokorny/ambulance-webapi/internal/db_service"
+)
+
+const (
+    // how far into the day of the doctor appointments may be moved to make
+    // room for an urgent case
+    urgentInsertionWindow = 24 * time.Hour
+    // how many times the insertion is planned again when the appointments in
+    // the way change between planning and locking
+    urgentInsertionRetries = 3
+)
+
+// urgentInsertionPlan lists the appointments of the doctor moved later by an
+// urgent case, with their new times and as they were before, and the
+// appointments of the doctor which do not block the urgent case although they
+// overlap it.
+type urgentInsertionPlan struct {
+    moved    []Appointment
+    previous []Appointment
+    ignored  []string
+}
+
+// urgentInsertionBlockedError is returned when an appointment in the way of
+// the urgent case cannot be moved.
+type urgentInsertionBlockedError struct {
+    message     string
+    appointment *Appointment
+}
+
+func (e urgentInsertionBlockedError) Error() string {
+    return e.message
+}
+
+func (o implAppointmentsAPI) InsertUrgentAppointment(c *gin.Context) {
+    db, ok := dbServiceFromContext[Appointment](c, "appointment_service")
+    if !ok {
+        return
+    }
+    userService, ok := dbServiceFromContext[User](c, "user_service")
+    if !ok {
+        return
+    }
+    locationService, ok := dbServiceFromContext[Location](c, "location_service")
+    if !ok {
+        return
+    }
+    availabilityService, ok := dbServiceFromContext[Availability](c, "availability_service")
+    if !ok {
+        return
+    }
+
+    appointment := Appointment{}
+    if err := c.ShouldBindJSON(&appointment); err != nil {
+        c.JSON(
+            http.StatusBadRequest,
+            gin.H{
+                "status":  "Bad Request",
+                "message": "Invalid request body",
+                "error":   err.Error(),
+            })
+        return
+    }
+    if appointment.Id == "" || appointment.Id == "@new" {
+        appointment.Id = uuid.New().String()
+    }
+    if appointment.DateTime.IsZero() {
+        appointment.DateTime = time.Now().UTC().Truncate(time.Minute)
+    }
+
+    err := applyUrgency(&appointment)
+    if err == nil && appointment.Priority < urgencyPriorities[urgencyUrgent] {
+        err = fmt.Errorf("urgency must be urgent or higher, use regular booking otherwise")
+    }
+    if err != nil {
+        c.JSON(
+            http.StatusBadRequest,
+            gin.H{
+                "status":  "Bad Request",
+                "message": "Invalid appointment urgency",
+                "error":   err.Error(),
+            })
+        return
+    }
+
+    if err := resolveAppointmentReferences(c, userService, locationService, &appointment, nil); err != nil {
+        referencesErrorResponse(c, err)
+        return
+    }
+    if !takesPart(c, appointment.Patient.Id, appointment.Doctor.Id) {
+        forbiddenRecordResponse(c, "only appointments of the caller can be booked")
+        return
+    }
+    creator, err := creatorReference(c, userService, appointment.CreatedBy)
+    if err != nil {
+        referencesErrorResponse(c, err)
+        return
+    }
+    markCreated(&appointment, creator)
+
+    appointment.Status = statusScheduled
+    appointment.StatusHistory = []StatusChange{{
+        Status:    statusScheduled,
+        ChangedAt: time.Now().UTC(),
+        ChangedBy: appointment.CreatedBy,
+    }}
+
+    if err := o.types.applyDuration(&appointment); err != nil {
+        c.JSON(
+            http.StatusBadRequest,
+            gin.H{
+                "status":  "Bad Request",
+                "message": "Invalid appointment duration",
+                "error":   err.Error(),
+            })
+        return
+    }
+
+    // the appointments in the way are known only after planning, so the plan
+    // is made twice, before locking to learn their locations and once more
+    // under the lock, which is retried when other locations are needed since
+    var plan urgentInsertionPlan
+    var unlock func()
+    for attempt := 0; ; attempt++ {
+        plan, err = o.planUrgentInsertion(c, db, &appointment)
+        if err != nil {
+            urgentInsertionErrorResponse(c, err)
+            return
+        }
+        keys := urgentInsertionLockKeys(&appointment, plan.moved)
+        unlock, err = bookingLocks.Lock(c, keys...)
+        if err != nil {
+            bookingLockResponse(c, err)
+            return
+        }
+
+        plan, err = o.planUrgentInsertion(c, db, &appointment)
+        if err != nil {
+            unlock()
+            urgentInsertionErrorResponse(c, err)
+            return
+        }
+        if containsAll(keys, urgentInsertionLockKeys(&appointment, plan.moved)) {
+            break
+        }
+        unlock()
+        if attempt+1 == urgentInsertionRetries {
+            concurrentChangeResponse(c)
+            return
+        }
+    }
+    defer unlock()
+
+    // appointments of the doctor are resolved by the plan, other appointments
+    // at the locations must not overlap the new times
+    ignored := append([]string{}, plan.ignored...)
+    for _, moved := range plan.moved {
+        ignored = append(ignored, moved.Id)
+    }
+    for _, checked := range append([]Appointment{appointment}, plan.moved...) {
+        conflicting, err := findConflictingAppointment(c, db, &checked, ignored...)
+        if err != nil {
+            c.JSON(
+                http.StatusBadGateway,
+                gin.H{
+                    "status":  "Bad Gateway",
+                    "message": "Failed to check conflicting appointments",
+                    "error":   err.Error(),
+                })
+            return
+        }
+        if conflicting != nil {
+            bookingConflictResponse(c, &checked, conflicting)
+            return
+        }
+    }
+
+    // the urgent appointment is booked like any other, the moved ones must
+    // stay within the availability and out of slots held for the waitlist
+    if err := checkAvailability(c, availabilityService, locationService, &appointment); err != nil {
+        availabilityCheckResponse(c, err)
+        return
+    }
+    if !checkSlotHolds(c, &appointment) {
+        return
+    }
+    if err := checkMovedAppointments(c, availabilityService, locationService, plan.moved); err != nil {
+        urgentInsertionErrorResponse(c, err)
+        return
+    }
+
+    // the moves are undone when a later write fails, so that either the
+    // whole insertion is stored or none of it
+    for i := range plan.moved {
+        moved := &plan.moved[i]
+        markUpdated(moved, appointment.CreatedBy)
+        err := db.ReplaceDocumentIfVersion(c, moved.Id, moved.Version, moved)
+        if err != nil {
+            undoMoves(c, db, plan.moved[:i], plan.previous[:i])
+        }
+        switch {
+        case err == db_service.ErrPreconditionFailed || err == db_service.ErrNotFound:
+            concurrentChangeResponse(c)
+            return
+        case err != nil:
+            c.JSON(
+                http.StatusBadGateway,
+                gin.H{
+                    "status":  "Bad Gateway",
+                    "message": "Failed to move appointment in database",
+                    "error":   err.Error(),
+                })
+            return
+        }
+    }
+
+    err = db.CreateDocument(c, appointment.Id, &appointment)
+    if err != nil {
+        undoMoves(c, db, plan.moved, plan.previous)
+    }
+    switch err {
+    case nil:
+    case db_service.ErrConflict:
+        c.JSON(
+            http.StatusConflict,
+            gin.H{
+                "status":  "Conflict",
+                "message": "Appointment already exists",
+                "error":   err.Error(),
+            })
+        return
+    default:
+        c.JSON(
+            http.StatusBadGateway,
+            gin.H{
+                "status":  "Bad Gateway",
+                "message": "Failed to create appointment in database",
+                "error":   err.Error(),
+            })
+        return
+    }
+
+    notifications := notificationServiceFromContext(c)
+    for i, moved := range plan.moved {
+        notify(c, notifications, Notification{
+            RecipientId: moved.Patient.Id,
+            Type:        notificationAppointmentMoved,
+            Message: fmt.Sprintf(
+                "Your appointment with %v was moved from %v to %v because of an urgent case",
+                moved.Doctor.Name,
+                plan.previous[i].DateTime.UTC().Format(time.RFC3339),
+                moved.DateTime.UTC().Format(time.RFC3339),
+            ),
+            AppointmentId: moved.Id,
+        })
+    }
+
+    setETag(c, appointment.Version)
+    c.JSON(
+        http.StatusCreated,
+        UrgentInsertion{
+            Appointment:       appointment,
+            MovedAppointments: plan.moved,
+        })
+}
+
+// planUrgentInsertion finds the appointments of the doctor overlapping the
+// urgent appointment and moves them, together with the appointments directly
+// following them, later by just as much as needed. The order of the moved
+// appointments is kept and the first free gap stops the cascade. Only less
+// urgent appointments the patient has not started yet can be moved, completed
+// appointments do not block the doctor anymore.
+func (o implAppointmentsAPI) planUrgentInsertion(ctx context.Context, db db_service.DbService[Appointment], urgent *Appointment) (urgentInsertionPlan, error) {
+    plan := urgentInsertionPlan{moved: []Appointment{}}
+    legacyDuration := time.Duration(o.types.defaultDuration) * time.Minute
+    windowEnd := urgent.DateTime.Add(urgentInsertionWindow)
+
+    appointments, err := db.FindDocuments(ctx, db_service.Query{
+        Filter: db_service.And(
+            db_service.Ne("id", urgent.Id),
+            db_service.Eq("doctor.id", urgent.Doctor.Id),
+            activeStatusFilter(),
+            db_service.Lt("datetime", windowEnd),
+            db_service.Or(
+                db_service.Gt("enddatetime", urgent.DateTime),
+                db_service.And(
+                    db_service.Eq("enddatetime", nil),
+                    db_service.Gt("datetime", urgent.DateTime.Add(-legacyDuration)),
+                ),
+            ),
+        ),
+        Sort: []db_service.SortField{{Field: "datetime"}, {Field: "id"}},
+    })
+    if err != nil {
+        return plan, err
+    }
+
+    cursor := urgent.EndDateTime
+    for i := range appointments {
+        appointment := appointments[i]
+        if currentStatus(&appointment) == statusCompleted {
+            plan.ignored = append(plan.ignored, appointment.Id)
+            continue
+        }
+        if !appointment.DateTime.Before(cursor) {
+            break
+        }
+
+        switch status := currentStatus(&appointment); {
+        case status != statusScheduled && status != statusCheckedIn:
+            return plan, urgentInsertionBlockedError{"Doctor is already treating a patient at this time", &appointments[i]}
+        case appointmentPriority(&appointment) >= urgent.Priority:
+            return plan, urgentInsertionBlockedError{"Doctor has an at least as urgent appointment at this time", &appointments[i]}
+        }
+
+        end := appointment.EndDateTime
+        if end.IsZero() {
+            end = appointment.DateTime.Add(legacyDuration)
+            appointment.DurationMinutes = o.types.defaultDuration
+        }
+        plan.previous = append(plan.previous, appointments[i])
+        appointment.DateTime = cursor
+        appointment.EndDateTime = cursor.Add(end.Sub(appointments[i].DateTime))
+        cursor = appointment.EndDateTime
+        if cursor.After(windowEnd) {
+            return plan, urgentInsertionBlockedError{"Day of the doctor is too full to move the appointments", &appointments[i]}
+        }
+        plan.moved = append(plan.moved, appointment)
+    }
+    return plan, nil
+}
+
+// checkMovedAppointments verifies that the moved appointments stay within
+// working hours of the doctor and opening hours of their locations and do not
+// overlap slots held for patients from the waitlist.
+func checkMovedAppointments(
+    ctx context.Context,
+    availabilityDb db_service.DbService[Availability],
+    locationDb db_service.DbService[Location],
+    moved []Appointment,
+) error {
+    waitlist, _ := ctx.Value("waitlist_service").(db_service.DbService[WaitlistRequest])
+    for i := range moved {
+        err := checkAvailability(ctx, availabilityDb, locationDb, &moved[i])
+        if unavailable, ok := err.(unavailableError); ok {
+            return urgentInsertionBlockedError{"Appointment cannot be moved, " + unavailable.message, &moved[i]}
+        }
+        if invalid, ok := err.(invalidReferenceError); ok {
+            return urgentInsertionBlockedError{"Appointment cannot be moved, " + invalid.message, &moved[i]}
+        }
+        if err != nil || waitlist == nil {
+            return err
+        }
+        holding, err := findConflictingHold(ctx, waitlist, &moved[i], "")
+        if err != nil {
+            return err
+        }
+        if holding != nil {
+            return urgentInsertionBlockedError{"Appointment cannot be moved into a slot held for a patient from the waitlist", &moved[i]}
+        }
+    }
+    return nil
+}
+
+// undoMoves writes the moved appointments back as they were before. It runs
+// even when the request was cancelled, failures are only logged as the
+// appointments may have been changed by someone else in the meantime.
+func undoMoves(ctx context.Context, db db_service.DbService[Appointment], moved []Appointment, previous []Appointment) {
+    ctx = context.WithoutCancel(ctx)
+    for i := range moved {
+        restored := previous[i]
+        if err := db.ReplaceDocumentIfVersion(ctx, moved[i].Id, moved[i].Version, &restored); err != nil {
+            log.Printf("Failed to move appointment %v back after a failed urgent insertion: %v", moved[i].Id, err)
+        }
+    }
+}
+
+// urgentInsertionLockKeys returns the booking locks of the doctor and of the
+// locations of the urgent and the moved appointments.
+func urgentInsertionLockKeys(urgent *Appointment, moved []Appointment) []string {
+    keys := []string{"doctor/" + urgent.Doctor.Id, "location/" + urgent.Location.Id}
+    for _, appointment := range moved {
+        if !containsAll(keys, []string{"location/" + appointment.Location.Id}) {
+            keys = append(keys, "location/"+appointment.Location.Id)
+        }
+    }
+    return keys
+}
+
+func containsAll(values []string, wanted []string) bool {
+    for _, value := range wanted {
+        found := false
+        for _, candidate := range values {
+            found = found || candidate == value
+        }
+        if !found {
+            return false
+        }
+    }
+    return true
+}
+
+func urgentInsertionErrorResponse(c *gin.Context, err error) {
+    if blocked, ok := err.(urgentInsertionBlockedError); ok {
+        c.JSON(
+            http.StatusConflict,
+            gin.H{
+                "status":                 "Conflict",
+                "message":                blocked.message,
+                "error":                  "appointment " + blocked.appointment.Id + " cannot be moved",
+                "conflictingAppointment": blocked.appointment,
+            })
+        return
+    }
+    c.JSON(
+        http.StatusBadGateway,
+        gin.H{
+            "status":  "Bad Gateway",
+            "message": "Failed to get appointments of the doctor",
+            "error":   err.Error(),
+        })
+}
@@ -1,15 +1,26 @@
 package ambulance_wl
 
 import (
+    "fmt"
     "net/http"
+    "time"
 
     "github.com/gin-gonic/gin"
+    "github.com/google/uuid"
     "github.com/xpokorny/ambulance-webapi/internal/db_service"
 )
 
 type implUsersAPI struct {
 }
 
+var userSortFields = sortableFields{
+    "id":   "id",
+    "name": "name",
+    "role": "role",
+}
+
+var userRoles = []string{"patient", "doctor", "receptionist", "admin"}
+
 func NewUsersAPI() UsersAPI {
     return &implUsersAPI{}
 }
@@ -35,44 +46,34 @@ func (api *implUsersAPI) GetUsers(c *gin.Context) {
         return
     }
 
-    // Get all users
-    users := []User{}
-    cursor, err := userService.FindAllDocuments(c.Request.Context())
+    query, pageToken, err := unboundedPagingQuery(c, userSortFields, "name")
     if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{
-            "error":   "failed to get users",
-            "message": err.Error(),
-            "status":  "Internal Server Error",
+        c.JSON(http.StatusBadRequest, gin.H{
+            "error":   err.Error(),
+            "message": "invalid paging parameters",
+            "status":  "Bad Request",
         })
         return
     }
-    defer cursor.Close(c.Request.Context())
 
-    for cursor.Next(c.Request.Context()) {
-        var user User
-        if err := cursor.Decode(&user); err != nil {
-            c.JSON(http.StatusInternalServerError, gin.H{
-                "error":   "failed to decode user",
-                "message": err.Error(),
-                "status":  "Internal Server Error",
-            })
-            return
-        }
-        users = append(users, user)
+    // Get users, optionally filtered by role
+    filters := []db_service.Filter{}
+    if role := c.Query("role"); role != "" {
+        filters = append(filters, db_service.Eq("role", role))
     }
+    if c.Query("includeDeactivated") != "true" {
+        filters = append(filters, db_service.Ne("deactivated", true))
+    }
+    query.Filter = db_service.And(filters...)
 
-    // Filter by role if specified
-    role := c.Query("role")
-    if role != "" {
-        filteredUsers := make([]User, 0)
-        for _, user := range users {
-            if user.Role == role {
-                filteredUsers = append(filteredUsers, user)
-            }
-        }
-        users = filteredUsers
+    users, nextPageToken, err := db_service.FindPage(c.Request.Context(), userService, query, pageToken)
+    if err != nil {
+        pagingError(c, err, "failed to get users")
+        return
     }
 
+    recordReads(c, users, func(user *User) string { return user.Id })
+    setNextPageLink(c, nextPageToken)
     c.JSON(http.StatusOK, users)
 }
 
@@ -126,4 +127,218 @@ func (api *implUsersAPI) GetUser(c *gin.Context) {
     }
 
     c.JSON(http.StatusOK, user)
-}
\ No newline at end of file
+}
+func (api *implUsersAPI) CreateUser(c *gin.Context) {
+    userService, ok := dbServiceFromContext[User](c, "user_service")
+    if !ok {
+        return
+    }
+
+    user := User{}
+    if err := c.ShouldBindJSON(&user); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{
+            "error":   err.Error(),
+            "message": "invalid request body",
+            "status":  "Bad Request",
+        })
+        return
+    }
+    if user.Id == "" || user.Id == "@new" {
+        user.Id = uuid.New().String()
+    }
+    user.Deactivated = false
+    if err := validateUser(&user); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{
+            "error":   err.Error(),
+            "message": "invalid user",
+            "status":  "Bad Request",
+        })
+        return
+    }
+
+    switch err := userService.CreateDocument(c.Request.Context(), user.Id, &user); err {
+    case nil:
+        c.JSON(http.StatusCreated, user)
+    case db_service.ErrConflict:
+        c.JSON(http.StatusConflict, gin.H{
+            "error":   err.Error(),
+            "message": "user with id " + user.Id + " already exists",
+            "status":  "Conflict",
+        })
+    default:
+        c.JSON(http.StatusInternalServerError, gin.H{
+            "error":   "failed to create user",
+            "message": err.Error(),
+            "status":  "Internal Server Error",
+        })
+    }
+}
+
+func (api *implUsersAPI) UpdateUser(c *gin.Context) {
+    update := User{}
+    if err := c.ShouldBindJSON(&update); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{
+            "error":   err.Error(),
+            "message": "invalid request body",
+            "status":  "Bad Request",
+        })
+        return
+    }
+    if update.Id != "" && update.Id != c.Param("userId") {
+        c.JSON(http.StatusBadRequest, gin.H{
+            "error":   "id mismatch",
+            "message": "user id in the body does not match the path",
+            "status":  "Bad Request",
+        })
+        return
+    }
+
+    api.updateUser(c, func(user *User) {
+        user.Name = update.Name
+        user.Role = update.Role
+    })
+}
+
+func (api *implUsersAPI) PatchUser(c *gin.Context) {
+    patch := UserPatch{}
+    if err := c.ShouldBindJSON(&patch); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{
+            "error":   err.Error(),
+            "message": "invalid request body",
+            "status":  "Bad Request",
+        })
+        return
+    }
+
+    api.updateUser(c, func(user *User) {
+        if patch.Name != "" {
+            user.Name = patch.Name
+        }
+        if patch.Role != "" {
+            user.Role = patch.Role
+        }
+    })
+}
+
+func (api *implUsersAPI) DeactivateUser(c *gin.Context) {
+    api.updateUser(c, func(user *User) {
+        user.Deactivated = true
+    })
+}
+
+func (api *implUsersAPI) ActivateUser(c *gin.Context) {
+    api.updateUser(c, func(user *User) {
+        user.Deactivated = false
+    })
+}
+
+// updateUser loads the user from the path, applies the change, validates
+// the result and stores it, unless the user was changed in the meantime.
+func (api *implUsersAPI) updateUser(c *gin.Context, change func(user *User)) {
+    userService, ok := dbServiceFromContext[User](c, "user_service")
+    if !ok {
+        return
+    }
+    appointmentService, ok := dbServiceFromContext[Appointment](c, "appointment_service")
+    if !ok {
+        return
+    }
+
+    userId := c.Param("userId")
+    // no appointment can be booked with the doctor while the role changes
+    unlock, err := bookingLocks.Lock(c.Request.Context(), "doctor/"+userId)
+    if err != nil {
+        c.JSON(http.StatusServiceUnavailable, gin.H{
+            "error":   err.Error(),
+            "message": "user is being booked by another request, retry later",
+            "status":  "Service Unavailable",
+        })
+        return
+    }
+    defer unlock()
+
+    user, err := userService.FindDocument(c.Request.Context(), userId)
+    if err == nil {
+        previousRole := user.Role
+        change(user)
+        if err := validateUser(user); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{
+                "error":   err.Error(),
+                "message": "invalid user",
+                "status":  "Bad Request",
+            })
+            return
+        }
+        if user.Role != previousRole && !checkNoFutureAppointments(c, appointmentService, userId, previousRole) {
+            return
+        }
+        err = userService.ReplaceDocumentIfVersion(c.Request.Context(), userId, user.Version, user)
+    }
+
+    switch err {
+    case nil:
+        // appointments embed a copy of the user, refresh it in the background
+        propagateUser(userService, appointmentService, userId)
+        c.JSON(http.StatusOK, user)
+    case db_service.ErrPreconditionFailed:
+        concurrentChangeResponse(c)
+    case db_service.ErrNotFound:
+        c.JSON(http.StatusNotFound, gin.H{
+            "error":   "user not found",
+            "message": "user with id " + userId + " not found",
+            "status":  "Not Found",
+        })
+    default:
+        c.JSON(http.StatusInternalServerError, gin.H{
+            "error":   "failed to update user",
+            "message": err.Error(),
+            "status":  "Internal Server Error",
+        })
+    }
+}
+
+// checkNoFutureAppointments refuses the role change of a patient or doctor
+// who still has appointments ahead in the previous role, they would refer to
+// a user of a wrong role. On failure it responds and returns false.
+func checkNoFutureAppointments(c *gin.Context, appointmentService db_service.DbService[Appointment], userId string, previousRole string) bool {
+    if previousRole != rolePatient && previousRole != roleDoctor {
+        return true
+    }
+    future, err := appointmentService.FindDocuments(c.Request.Context(), db_service.Query{
+        Filter: db_service.And(
+            db_service.Eq(previousRole+".id", userId),
+            db_service.Gte("datetime", time.Now()),
+            activeStatusFilter(),
+        ),
+        Limit: 1,
+    })
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{
+            "error":   "failed to check future appointments",
+            "message": err.Error(),
+            "status":  "Internal Server Error",
+        })
+        return false
+    }
+    if len(future) > 0 {
+        c.JSON(http.StatusConflict, gin.H{
+            "error":   "user has future appointments as " + previousRole,
+            "message": "appointment " + future[0].Id + " is still booked with the user as " + previousRole,
+            "status":  "Conflict",
+        })
+        return false
+    }
+    return true
+}
+
+func validateUser(user *User) error {
+    if user.Name == "" {
+        return fmt.Errorf("name is required")
+    }
+    for _, role := range userRoles {
+        if user.Role == role {
+            return nil
+        }
+    }
+    return fmt.Errorf("role must be one of %v", userRoles)
+}
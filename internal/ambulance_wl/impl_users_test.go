@@ -0,0 +1,76 @@
+package ambulance_wl
+
+import (
+    "context"
+    "net/http"
+    "testing"
+
+    "github.com/xpokorny/ambulance-webapi/internal/db_service"
+)
+
+// racingUsers changes the user concurrently right after it was read.
+type racingUsers struct {
+    db_service.DbService[User]
+}
+
+func (r racingUsers) FindDocument(ctx context.Context, id string) (*User, error) {
+    user, err := r.DbService.FindDocument(ctx, id)
+    if err == nil {
+        concurrent := *user
+        concurrent.Deactivated = true
+        r.DbService.UpdateDocument(ctx, id, &concurrent)
+    }
+    return user, err
+}
+
+func TestUserUpdateKeepsConcurrentChanges(t *testing.T) {
+    server := newTestServer(t, true)
+    response := server.call(http.MethodPatch, "/api/users/user2", asReceptionist, UserPatch{Name: "Jane Doe"})
+    if response.Code != http.StatusOK {
+        t.Fatalf("patch = %v %v", response.Code, response.Body.String())
+    }
+    if patched := decodeResponse[User](t, response); patched.Name != "Jane Doe" || patched.Version != 2 {
+        t.Errorf("patched user = %+v, want Jane Doe in version 2", patched)
+    }
+
+    server.users = racingUsers{server.users}
+    response = server.call(http.MethodPatch, "/api/users/user2", asReceptionist, UserPatch{Name: "Jane Roe"})
+    if response.Code != http.StatusConflict {
+        t.Fatalf("patch of concurrently changed user = %v %v, want 409", response.Code, response.Body.String())
+    }
+    stored, _ := server.users.(racingUsers).DbService.FindDocument(t.Context(), "user2")
+    if stored.Name != "Jane Doe" || !stored.Deactivated {
+        t.Errorf("stored user = %+v, want the concurrent deactivation kept", stored)
+    }
+}
+
+func TestUserRoleChangeWithFutureAppointments(t *testing.T) {
+    server := newTestServer(t, true)
+    appointment := server.book(t, asPatient, testAppointment("09:00"))
+
+    tests := []struct {
+        name   string
+        userId string
+        role   string
+        status int
+    }{
+        {"doctor with appointments", "user5", roleReceptionist, http.StatusConflict},
+        {"patient with appointments", "user1", roleDoctor, http.StatusConflict},
+        {"doctor without appointments", "user6", roleAdmin, http.StatusOK},
+        {"same role", "user5", roleDoctor, http.StatusOK},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            response := server.call(http.MethodPatch, "/api/users/"+test.userId, asAdmin, UserPatch{Role: test.role})
+            if response.Code != test.status {
+                t.Errorf("status = %v, want %v, body %v", response.Code, test.status, response.Body.String())
+            }
+        })
+    }
+
+    // cancelled appointments do not hold the role
+    server.call(http.MethodPost, "/api/appointments/"+appointment.Id+"/cancel", asPatient, nil)
+    if response := server.call(http.MethodPatch, "/api/users/user5", asAdmin, UserPatch{Role: roleReceptionist}); response.Code != http.StatusOK {
+        t.Errorf("role change after cancellation = %v %v", response.Code, response.Body.String())
+    }
+}
@@ -0,0 +1,532 @@
+package ambulance_wl
+
+import (
+    "context"
+    "log"
+    "net/http"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/google/uuid"
+    "github.com/xpokorny/ambulance-webapi/internal/db_service"
+)
+
+const (
+    waitingStatusWaiting = "waiting"
+    waitingStatusCalled  = "called"
+    waitingStatusLeft    = "left"
+
+    // number of recent visits of the doctor the estimated wait is based on
+    visitDurationSample = 20
+)
+
+type implWaitingListAPI struct {
+    types appointmentTypes
+}
+
+func NewWaitingListAPI() WaitingListAPI {
+    return &implWaitingListAPI{
+        types: loadAppointmentTypes(),
+    }
+}
+
+var waitingListSortFields = sortableFields{
+    "id":         "id",
+    "doctorId":   "doctor.id",
+    "locationId": "location.id",
+    "priority":   "priority",
+    "arrivedAt":  "arrivedat",
+}
+
+// lockQueue serializes changes of one queue across all replicas, so that the
+// same patient is not added twice or called by two staff members at once.
+func lockQueue(ctx context.Context, doctorId string, locationId string) (func(), error) {
+    return bookingLocks.Lock(ctx, "queue/"+doctorId+"/"+locationId)
+}
+
+func queueLockResponse(c *gin.Context, err error) {
+    c.JSON(http.StatusServiceUnavailable, gin.H{
+        "error":   err.Error(),
+        "message": "queue is being changed by another request, retry later",
+        "status":  "Service Unavailable",
+    })
+}
+
+func (api *implWaitingListAPI) GetWaitingList(c *gin.Context) {
+    waitingListService, ok := dbServiceFromContext[WaitingListEntry](c, "waiting_list_service")
+    if !ok {
+        return
+    }
+    appointmentService, ok := dbServiceFromContext[Appointment](c, "appointment_service")
+    if !ok {
+        return
+    }
+
+    query, pageToken, err := unboundedPagingQuery(c, waitingListSortFields, "doctorId,locationId,-priority,arrivedAt")
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{
+            "error":   err.Error(),
+            "message": "invalid paging parameters",
+            "status":  "Bad Request",
+        })
+        return
+    }
+
+    filters := []db_service.Filter{db_service.Eq("status", waitingStatusWaiting)}
+    if urgency := c.Query("urgency"); urgency != "" {
+        urgencies := []interface{}{}
+        for _, value := range strings.Split(urgency, ",") {
+            value = strings.TrimSpace(value)
+            if _, ok := urgencyPriorities[value]; !ok {
+                c.JSON(http.StatusBadRequest, gin.H{
+                    "error":   "unknown urgency " + value,
+                    "message": "invalid query parameters",
+                    "status":  "Bad Request",
+                })
+                return
+            }
+            urgencies = append(urgencies, value)
+        }
+        filters = append(filters, db_service.In("urgency", urgencies...))
+    }
+    if doctorId := c.Query("doctorId"); doctorId != "" {
+        filters = append(filters, db_service.Eq("doctor.id", doctorId))
+    }
+    if locationId := c.Query("locationId"); locationId != "" {
+        filters = append(filters, db_service.Eq("location.id", locationId))
+    }
+    query.Filter = db_service.And(filters...)
+
+    entries, nextPageToken, err := db_service.FindPage(c.Request.Context(), waitingListService, query, pageToken)
+    if err != nil {
+        pagingError(c, err, "failed to get waiting list")
+        return
+    }
+
+    // positions are counted in the whole queue, not only on the page or
+    // among the requested urgencies, every queue is read once
+    queues := map[string]map[string]WaitingListEntry{}
+    for i := range entries {
+        entry := &entries[i]
+        key := entry.Doctor.Id + "/" + entry.Location.Id
+        queue, loaded := queues[key]
+        if !loaded {
+            queue, err = api.numberedQueue(c.Request.Context(), waitingListService, appointmentService, entry.Doctor.Id, entry.Location.Id)
+            if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{
+                    "error":   "failed to estimate waits",
+                    "message": err.Error(),
+                    "status":  "Internal Server Error",
+                })
+                return
+            }
+            queues[key] = queue
+        }
+        entry.Position = queue[entry.Id].Position
+        entry.EstimatedWaitMinutes = queue[entry.Id].EstimatedWaitMinutes
+    }
+    recordReads(c, entries, func(entry *WaitingListEntry) string { return entry.Id })
+    setNextPageLink(c, nextPageToken)
+    c.JSON(http.StatusOK, entries)
+}
+
+func (api *implWaitingListAPI) GetWaitingListEntry(c *gin.Context) {
+    waitingListService, ok := dbServiceFromContext[WaitingListEntry](c, "waiting_list_service")
+    if !ok {
+        return
+    }
+    appointmentService, ok := dbServiceFromContext[Appointment](c, "appointment_service")
+    if !ok {
+        return
+    }
+
+    entryId := c.Param("entryId")
+    entry, err := waitingListService.FindDocument(c.Request.Context(), entryId)
+    if err == nil && !takesPart(c, entry.Patient.Id, entry.Doctor.Id) {
+        err = db_service.ErrNotFound
+    }
+    if err == nil && entry.Status == waitingStatusWaiting {
+        err = api.positionInQueue(c.Request.Context(), waitingListService, appointmentService, entry)
+    }
+    if err != nil {
+        waitingListErrorResponse(c, err, entryId)
+        return
+    }
+    c.JSON(http.StatusOK, entry)
+}
+
+func (api *implWaitingListAPI) CreateWaitingListEntry(c *gin.Context) {
+    waitingListService, ok := dbServiceFromContext[WaitingListEntry](c, "waiting_list_service")
+    if !ok {
+        return
+    }
+    appointmentService, ok := dbServiceFromContext[Appointment](c, "appointment_service")
+    if !ok {
+        return
+    }
+    userService, ok := dbServiceFromContext[User](c, "user_service")
+    if !ok {
+        return
+    }
+    locationService, ok := dbServiceFromContext[Location](c, "location_service")
+    if !ok {
+        return
+    }
+
+    entry := WaitingListEntry{}
+    if err := c.ShouldBindJSON(&entry); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{
+            "error":   err.Error(),
+            "message": "invalid request body",
+            "status":  "Bad Request",
+        })
+        return
+    }
+    if entry.Id == "" || entry.Id == "@new" {
+        entry.Id = uuid.New().String()
+    }
+    if entry.ArrivedAt.IsZero() {
+        entry.ArrivedAt = time.Now().UTC()
+    }
+    if entry.Urgency == "" {
+        entry.Urgency = urgencyStandard
+    }
+    if _, ok := urgencyPriorities[entry.Urgency]; !ok {
+        c.JSON(http.StatusBadRequest, gin.H{
+            "error":   "urgency must be one of immediate, very-urgent, urgent, standard, non-urgent",
+            "message": "invalid waiting list entry",
+            "status":  "Bad Request",
+        })
+        return
+    }
+    entry.Priority = urgencyPriorities[entry.Urgency]
+    entry.Status = waitingStatusWaiting
+    entry.CalledAt = nil
+
+    ctx := c.Request.Context()
+    err := resolveUserReference(ctx, userService, "patient", &entry.Patient, User{}, "patient")
+    if err == nil {
+        err = resolveUserReference(ctx, userService, "doctor", &entry.Doctor, User{}, "doctor")
+    }
+    if err == nil {
+        err = resolveLocationReference(ctx, locationService, &entry.Location, Location{})
+    }
+    if err != nil {
+        referencesErrorResponse(c, err)
+        return
+    }
+
+    unlock, err := lockQueue(ctx, entry.Doctor.Id, entry.Location.Id)
+    if err != nil {
+        queueLockResponse(c, err)
+        return
+    }
+    defer unlock()
+
+    waiting, err := waitingListService.FindDocuments(ctx, db_service.Query{
+        Filter: db_service.And(
+            queueFilter(entry.Doctor.Id, entry.Location.Id),
+            db_service.Eq("patient.id", entry.Patient.Id),
+        ),
+        Limit: 1,
+    })
+    if err == nil && len(waiting) > 0 {
+        c.JSON(http.StatusConflict, gin.H{
+            "error":   "patient is already waiting",
+            "message": "patient " + entry.Patient.Id + " is already waiting in the queue as " + waiting[0].Id,
+            "status":  "Conflict",
+        })
+        return
+    }
+    if err == nil {
+        err = waitingListService.CreateDocument(ctx, entry.Id, &entry)
+    }
+    if err == nil {
+        err = api.positionInQueue(ctx, waitingListService, appointmentService, &entry)
+    }
+    if err != nil {
+        waitingListErrorResponse(c, err, entry.Id)
+        return
+    }
+    notifyPushedBack(ctx, notificationServiceFromContext(c), waitingListService, &entry)
+    c.JSON(http.StatusCreated, entry)
+}
+
+func (api *implWaitingListAPI) DeleteWaitingListEntry(c *gin.Context) {
+    waitingListService, ok := dbServiceFromContext[WaitingListEntry](c, "waiting_list_service")
+    if !ok {
+        return
+    }
+
+    entryId := c.Param("entryId")
+    entry, err := waitingListService.FindDocument(c.Request.Context(), entryId)
+    if err == nil && !takesPart(c, entry.Patient.Id, entry.Doctor.Id) {
+        err = db_service.ErrNotFound
+    }
+    if err != nil {
+        waitingListErrorResponse(c, err, entryId)
+        return
+    }
+    if entry.Status != waitingStatusWaiting {
+        c.JSON(http.StatusConflict, gin.H{
+            "error":   "patient is not waiting",
+            "message": "patient of entry " + entryId + " is " + entry.Status,
+            "status":  "Conflict",
+        })
+        return
+    }
+
+    entry.Status = waitingStatusLeft
+    err = waitingListService.ReplaceDocumentIfVersion(c.Request.Context(), entryId, entry.Version, entry)
+    if err != nil {
+        waitingListErrorResponse(c, err, entryId)
+        return
+    }
+    c.AbortWithStatus(http.StatusNoContent)
+}
+
+func (api *implWaitingListAPI) CallNextWaitingPatient(c *gin.Context) {
+    waitingListService, ok := dbServiceFromContext[WaitingListEntry](c, "waiting_list_service")
+    if !ok {
+        return
+    }
+
+    doctorId := c.Query("doctorId")
+    locationId := c.Query("locationId")
+    if doctorId == "" || locationId == "" {
+        c.JSON(http.StatusBadRequest, gin.H{
+            "error":   "doctorId and locationId are required",
+            "message": "invalid queue",
+            "status":  "Bad Request",
+        })
+        return
+    }
+
+    ctx := c.Request.Context()
+    unlock, err := lockQueue(ctx, doctorId, locationId)
+    if err != nil {
+        queueLockResponse(c, err)
+        return
+    }
+    defer unlock()
+
+    entries, err := waitingListService.FindDocuments(ctx, db_service.Query{Filter: queueFilter(doctorId, locationId)})
+    if err == nil && len(entries) == 0 {
+        err = db_service.ErrNotFound
+    }
+    if err != nil {
+        waitingListErrorResponse(c, err, "")
+        return
+    }
+
+    sortQueue(entries)
+    next := &entries[0]
+    calledAt := time.Now().UTC()
+    next.Status = waitingStatusCalled
+    next.CalledAt = &calledAt
+    next.Position = 0
+    next.EstimatedWaitMinutes = 0
+    err = waitingListService.ReplaceDocumentIfVersion(ctx, next.Id, next.Version, next)
+    if err != nil {
+        waitingListErrorResponse(c, err, next.Id)
+        return
+    }
+    c.JSON(http.StatusOK, next)
+}
+
+// queueFilter matches the patients waiting for the doctor at the location.
+func queueFilter(doctorId string, locationId string) db_service.Filter {
+    return db_service.And(
+        db_service.Eq("doctor.id", doctorId),
+        db_service.Eq("location.id", locationId),
+        db_service.Eq("status", waitingStatusWaiting),
+    )
+}
+
+// sortQueue orders the waiting patients by the queue they wait in, then by
+// urgency and their arrival.
+func sortQueue(entries []WaitingListEntry) {
+    sort.SliceStable(entries, func(i, j int) bool {
+        a, b := entries[i], entries[j]
+        switch {
+        case a.Doctor.Id != b.Doctor.Id:
+            return a.Doctor.Id < b.Doctor.Id
+        case a.Location.Id != b.Location.Id:
+            return a.Location.Id < b.Location.Id
+        case urgencyPriorities[a.Urgency] != urgencyPriorities[b.Urgency]:
+            return urgencyPriorities[a.Urgency] > urgencyPriorities[b.Urgency]
+        case !a.ArrivedAt.Equal(b.ArrivedAt):
+            return a.ArrivedAt.Before(b.ArrivedAt)
+        default:
+            return a.Id < b.Id
+        }
+    })
+}
+
+// notifyPushedBack notifies the patients who arrived before the new entry
+// but are queued behind it because of its higher urgency.
+func notifyPushedBack(
+    ctx context.Context,
+    notifications db_service.DbService[Notification],
+    waitingListService db_service.DbService[WaitingListEntry],
+    entry *WaitingListEntry,
+) {
+    entries, err := waitingListService.FindDocuments(ctx, db_service.Query{
+        Filter: db_service.And(
+            queueFilter(entry.Doctor.Id, entry.Location.Id),
+            db_service.Lt("arrivedat", entry.ArrivedAt),
+        ),
+    })
+    if err != nil {
+        log.Printf("Failed to notify patients pushed back by waiting list entry %v: %v", entry.Id, err)
+        return
+    }
+    for _, queued := range entries {
+        if urgencyPriorities[queued.Urgency] >= urgencyPriorities[entry.Urgency] {
+            continue
+        }
+        notify(ctx, notifications, Notification{
+            RecipientId:        queued.Patient.Id,
+            Type:               notificationQueuePositionChanged,
+            Message:            "A more urgent patient was queued ahead of you for " + entry.Doctor.Name + ", your wait will be longer",
+            WaitingListEntryId: queued.Id,
+        })
+    }
+}
+
+// positionInQueue computes the position and estimated wait of the waiting
+// entry within its queue.
+func (api *implWaitingListAPI) positionInQueue(
+    ctx context.Context,
+    waitingListService db_service.DbService[WaitingListEntry],
+    appointmentService db_service.DbService[Appointment],
+    entry *WaitingListEntry,
+) error {
+    queue, err := api.numberedQueue(ctx, waitingListService, appointmentService, entry.Doctor.Id, entry.Location.Id)
+    if err != nil {
+        return err
+    }
+    entry.Position = queue[entry.Id].Position
+    entry.EstimatedWaitMinutes = queue[entry.Id].EstimatedWaitMinutes
+    return nil
+}
+
+// numberedQueue returns the patients waiting for the doctor at the location
+// with their position and estimated wait, indexed by the entry ID.
+func (api *implWaitingListAPI) numberedQueue(
+    ctx context.Context,
+    waitingListService db_service.DbService[WaitingListEntry],
+    appointmentService db_service.DbService[Appointment],
+    doctorId string,
+    locationId string,
+) (map[string]WaitingListEntry, error) {
+    entries, err := waitingListService.FindDocuments(ctx, db_service.Query{
+        Filter: queueFilter(doctorId, locationId),
+    })
+    if err != nil {
+        return nil, err
+    }
+    sortQueue(entries)
+    if err := api.estimateWaits(ctx, appointmentService, entries); err != nil {
+        return nil, err
+    }
+    queue := map[string]WaitingListEntry{}
+    for _, entry := range entries {
+        queue[entry.Id] = entry
+    }
+    return queue, nil
+}
+
+// estimateWaits numbers the sorted waiting entries within every queue and
+// estimates their wait as the number of patients ahead times the average
+// visit duration of the doctor.
+func (api *implWaitingListAPI) estimateWaits(ctx context.Context, appointmentService db_service.DbService[Appointment], entries []WaitingListEntry) error {
+    visitMinutes := map[string]int32{}
+    position := int32(0)
+    for i := range entries {
+        entry := &entries[i]
+        if i == 0 || entry.Doctor.Id != entries[i-1].Doctor.Id || entry.Location.Id != entries[i-1].Location.Id {
+            position = 0
+        }
+        position++
+
+        minutes, known := visitMinutes[entry.Doctor.Id]
+        if !known {
+            var err error
+            if minutes, err = api.averageVisitMinutes(ctx, appointmentService, entry.Doctor.Id); err != nil {
+                return err
+            }
+            visitMinutes[entry.Doctor.Id] = minutes
+        }
+        entry.Position = position
+        entry.EstimatedWaitMinutes = (position - 1) * minutes
+    }
+    return nil
+}
+
+// averageVisitMinutes returns the average time from start to completion of
+// recent appointments of the doctor, or the default appointment duration
+// when the doctor has not completed any appointment yet.
+func (api *implWaitingListAPI) averageVisitMinutes(ctx context.Context, appointmentService db_service.DbService[Appointment], doctorId string) (int32, error) {
+    appointments, err := appointmentService.FindDocuments(ctx, db_service.Query{
+        Filter: db_service.And(
+            db_service.Eq("doctor.id", doctorId),
+            db_service.Eq("status", statusCompleted),
+        ),
+        Sort:  []db_service.SortField{{Field: "datetime", Descending: true}},
+        Limit: visitDurationSample,
+    })
+    if err != nil {
+        return 0, err
+    }
+
+    total, visits := time.Duration(0), 0
+    for _, appointment := range appointments {
+        var started, completed time.Time
+        for _, change := range appointment.StatusHistory {
+            switch change.Status {
+            case statusInProgress:
+                started = change.ChangedAt
+            case statusCompleted:
+                completed = change.ChangedAt
+            }
+        }
+        if !started.IsZero() && completed.After(started) {
+            total += completed.Sub(started)
+            visits++
+        }
+    }
+    if visits == 0 {
+        return api.types.defaultDuration, nil
+    }
+    return int32((total / time.Duration(visits)).Round(time.Minute) / time.Minute), nil
+}
+
+func waitingListErrorResponse(c *gin.Context, err error, entryId string) {
+    switch err {
+    case db_service.ErrNotFound:
+        message := "waiting list entry " + entryId + " not found"
+        if entryId == "" {
+            message = "nobody is waiting in the queue"
+        }
+        c.JSON(http.StatusNotFound, gin.H{
+            "error":   err.Error(),
+            "message": message,
+            "status":  "Not Found",
+        })
+    case db_service.ErrConflict, db_service.ErrPreconditionFailed:
+        c.JSON(http.StatusConflict, gin.H{
+            "error":   err.Error(),
+            "message": "waiting list entry already exists or was changed concurrently",
+            "status":  "Conflict",
+        })
+    default:
+        c.JSON(http.StatusInternalServerError, gin.H{
+            "error":   "failed to access waiting list",
+            "message": err.Error(),
+            "status":  "Internal Server Error",
+        })
+    }
+}
@@ -0,0 +1,121 @@
+package ambulance_wl
+
+import (
+    "fmt"
+    "net/http"
+    "slices"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/xpokorny/ambulance-webapi/internal/db_service"
+)
+
+// queuePatient adds the patient to the queue of the doctor in loc1, arrived
+// the given minute after 8:00.
+func queuePatient(t *testing.T, server *testServer, patientId string, doctorId string, urgency string, minute int) {
+    t.Helper()
+    response := server.call(http.MethodPost, "/api/waiting-list", asReceptionist, WaitingListEntry{
+        Patient:   User{Id: patientId},
+        Doctor:    User{Id: doctorId},
+        Location:  Location{Id: "loc1"},
+        Urgency:   urgency,
+        ArrivedAt: time.Date(2030, 1, 7, 8, minute, 0, 0, time.UTC),
+    })
+    if response.Code != http.StatusCreated {
+        t.Fatalf("queueing of %v = %v %v", patientId, response.Code, response.Body.String())
+    }
+}
+
+func TestWaitingListPages(t *testing.T) {
+    server := newTestServer(t, true)
+    queuePatient(t, server, "user1", "user5", urgencyStandard, 1)
+    queuePatient(t, server, "user2", "user5", "urgent", 2)
+    queuePatient(t, server, "user1", "user6", urgencyStandard, 3)
+
+    tests := []struct {
+        name  string
+        query string
+        want  []string
+    }{
+        {"whole list in queue order", "", []string{"user5 user2 1", "user5 user1 2", "user6 user1 1"}},
+        {"pages in queue order", "?limit=1", []string{"user5 user2 1", "user5 user1 2", "user6 user1 1"}},
+        {"urgency filter keeps positions", "?urgency=standard&limit=1", []string{"user5 user1 2", "user6 user1 1"}},
+        {"sorted by arrival", "?sort=-arrivedAt&limit=2", []string{"user6 user1 1", "user5 user2 1", "user5 user1 2"}},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            listed := []string{}
+            path := "/api/waiting-list" + test.query
+            for pages := 0; path != "" && pages < 10; pages++ {
+                response := server.call(http.MethodGet, path, asReceptionist, nil)
+                if response.Code != http.StatusOK {
+                    t.Fatalf("%v = %v %v", path, response.Code, response.Body.String())
+                }
+                for _, entry := range decodeResponse[[]WaitingListEntry](t, response) {
+                    listed = append(listed, fmt.Sprintf("%v %v %v", entry.Doctor.Id, entry.Patient.Id, entry.Position))
+                }
+                path = ""
+                if link := response.Header().Get("Link"); link != "" {
+                    path = strings.TrimPrefix(strings.Split(link, ">")[0], "<")
+                }
+            }
+            if !slices.Equal(listed, test.want) {
+                t.Errorf("listed = %v, want %v", listed, test.want)
+            }
+        })
+    }
+}
+
+func TestConcurrentWaitingListEntries(t *testing.T) {
+    server := newTestServer(t, true)
+
+    const requests = 10
+    statuses := make([]int, requests)
+    var wg sync.WaitGroup
+    for i := 0; i < requests; i++ {
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            statuses[i] = server.call(http.MethodPost, "/api/waiting-list", asReceptionist, WaitingListEntry{
+                Patient:  User{Id: "user1"},
+                Doctor:   User{Id: "user5"},
+                Location: Location{Id: "loc1"},
+            }).Code
+        }(i)
+    }
+    wg.Wait()
+    slices.Sort(statuses)
+    if statuses[0] != http.StatusCreated || statuses[1] != http.StatusConflict || statuses[requests-1] != http.StatusConflict {
+        t.Errorf("statuses = %v, want one 201 and 409 otherwise", statuses)
+    }
+}
+
+func TestWaitingListLockedByOtherReplica(t *testing.T) {
+    server := newTestServer(t, true)
+
+    // another replica holds the queue for a moment
+    ctx := t.Context()
+    key := "queue/user5/loc1"
+    held := &BookingLease{Id: key, Owner: "other replica", ExpiresAt: time.Now().Add(time.Minute)}
+    if err := bookingLocks.leases.CreateDocument(ctx, key, held); err == db_service.ErrConflict {
+        err = bookingLocks.leases.ReplaceDocumentIfVersion(ctx, key, db_service.AnyVersion, held)
+        if err != nil {
+            t.Fatal(err)
+        }
+    } else if err != nil {
+        t.Fatal(err)
+    }
+    const holding = 200 * time.Millisecond
+    go func() {
+        time.Sleep(holding)
+        bookingLocks.leases.ReplaceDocumentIfVersion(ctx, key, db_service.AnyVersion, &BookingLease{Id: key})
+    }()
+
+    start := time.Now()
+    queuePatient(t, server, "user1", "user5", urgencyStandard, 1)
+    if waited := time.Since(start); waited < holding {
+        t.Errorf("queueing took %v, want it to wait for the lease of the other replica", waited)
+    }
+}
@@ -0,0 +1,722 @@
+package ambulance_wl
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/google/uuid"
+    "github.com/xpokorny/ambulance-webapi/internal/db_service"
+)
+
+const (
+    waitlistStatusWaiting   = "waiting"
+    waitlistStatusOffered   = "offered"
+    waitlistStatusBooked    = "booked"
+    waitlistStatusExpired   = "expired"
+    waitlistStatusCancelled = "cancelled"
+
+    defaultSlotHoldMinutes = 30
+    slotOfferTimeout       = time.Minute
+    slotOfferCheckInterval = time.Minute
+)
+
+var waitlistStatuses = []string{
+    waitlistStatusWaiting,
+    waitlistStatusOffered,
+    waitlistStatusBooked,
+    waitlistStatusExpired,
+    waitlistStatusCancelled,
+}
+
+var waitlistSortFields = sortableFields{
+    "id":        "id",
+    "createdAt": "createdat",
+}
+
+type implWaitlistAPI struct {
+    types appointmentTypes
+}
+
+func NewWaitlistAPI() WaitlistAPI {
+    return &implWaitlistAPI{
+        types: loadAppointmentTypes(),
+    }
+}
+
+// slotOfferServices are the storages a freed slot is offered with. Offers are
+// made in the background, after the request freeing the slot has finished.
+type slotOfferServices struct {
+    waitlist      db_service.DbService[WaitlistRequest]
+    appointments  db_service.DbService[Appointment]
+    notifications db_service.DbService[Notification]
+}
+
+func (api *implWaitlistAPI) GetWaitlistRequests(c *gin.Context) {
+    waitlistService, ok := dbServiceFromContext[WaitlistRequest](c, "waitlist_service")
+    if !ok {
+        return
+    }
+
+    query, pageToken, err := pagingQuery(c, waitlistSortFields, "createdAt")
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{
+            "error":   err.Error(),
+            "message": "invalid paging parameters",
+            "status":  "Bad Request",
+        })
+        return
+    }
+
+    filters := []db_service.Filter{}
+    if doctorId := c.Query("doctorId"); doctorId != "" {
+        filters = append(filters, db_service.Eq("doctor.id", doctorId))
+    }
+    if patientId := c.Query("patientId"); patientId != "" {
+        filters = append(filters, db_service.Eq("patient.id", patientId))
+    }
+    if status := c.Query("status"); status != "" {
+        statuses := []interface{}{}
+        for _, value := range strings.Split(status, ",") {
+            value = strings.TrimSpace(value)
+            if !containsAll(waitlistStatuses, []string{value}) {
+                c.JSON(http.StatusBadRequest, gin.H{
+                    "error":   "unknown status " + value,
+                    "message": "invalid query parameters",
+                    "status":  "Bad Request",
+                })
+                return
+            }
+            statuses = append(statuses, value)
+        }
+        filters = append(filters, db_service.In("status", statuses...))
+    }
+    filters = append(filters, ownRecordsFilter(c))
+    query.Filter = db_service.And(filters...)
+
+    requests, nextPageToken, err := db_service.FindPage(c.Request.Context(), waitlistService, query, pageToken)
+    if err != nil {
+        pagingError(c, err, "failed to get waitlist")
+        return
+    }
+
+    recordReads(c, requests, func(request *WaitlistRequest) string { return request.Id })
+    setNextPageLink(c, nextPageToken)
+    c.JSON(http.StatusOK, requests)
+}
+
+func (api *implWaitlistAPI) GetWaitlistRequest(c *gin.Context) {
+    waitlistService, ok := dbServiceFromContext[WaitlistRequest](c, "waitlist_service")
+    if !ok {
+        return
+    }
+
+    requestId := c.Param("requestId")
+    request, err := waitlistService.FindDocument(c.Request.Context(), requestId)
+    if err == nil && !takesPart(c, request.Patient.Id, request.Doctor.Id) {
+        err = db_service.ErrNotFound
+    }
+    if err != nil {
+        waitlistErrorResponse(c, err, requestId)
+        return
+    }
+    c.JSON(http.StatusOK, request)
+}
+
+func (api *implWaitlistAPI) CreateWaitlistRequest(c *gin.Context) {
+    waitlistService, ok := dbServiceFromContext[WaitlistRequest](c, "waitlist_service")
+    if !ok {
+        return
+    }
+    userService, ok := dbServiceFromContext[User](c, "user_service")
+    if !ok {
+        return
+    }
+    locationService, ok := dbServiceFromContext[Location](c, "location_service")
+    if !ok {
+        return
+    }
+
+    request := WaitlistRequest{}
+    if err := c.ShouldBindJSON(&request); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{
+            "error":   err.Error(),
+            "message": "invalid request body",
+            "status":  "Bad Request",
+        })
+        return
+    }
+    if request.Id == "" || request.Id == "@new" {
+        request.Id = uuid.New().String()
+    }
+    if request.DurationMinutes == 0 {
+        request.DurationMinutes = api.types.defaultDuration
+    }
+    if err := validateWaitlistRequest(&request); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{
+            "error":   err.Error(),
+            "message": "invalid waitlist request",
+            "status":  "Bad Request",
+        })
+        return
+    }
+    request.Status = waitlistStatusWaiting
+    request.CreatedAt = time.Now().UTC()
+    request.Offer = nil
+    request.DeclinedSlots = nil
+    request.AppointmentId = ""
+
+    ctx := c.Request.Context()
+    err := resolveUserReference(ctx, userService, "patient", &request.Patient, User{}, "patient")
+    if err == nil {
+        err = resolveUserReference(ctx, userService, "doctor", &request.Doctor, User{}, "doctor")
+    }
+    if err == nil && request.Location.Id != "" {
+        err = resolveLocationReference(ctx, locationService, &request.Location, Location{})
+    }
+    if err != nil {
+        referencesErrorResponse(c, err)
+        return
+    }
+    if request.Location.Id == "" {
+        request.Location = Location{}
+    }
+    if !takesPart(c, request.Patient.Id, "") {
+        forbiddenRecordResponse(c, "patients can join the waitlist only for themselves")
+        return
+    }
+
+    if err := waitlistService.CreateDocument(ctx, request.Id, &request); err != nil {
+        waitlistErrorResponse(c, err, request.Id)
+        return
+    }
+    c.JSON(http.StatusCreated, request)
+}
+
+func (api *implWaitlistAPI) CancelWaitlistRequest(c *gin.Context) {
+    services, ok := slotOfferServicesFromContext(c)
+    if !ok {
+        return
+    }
+
+    requestId := c.Param("requestId")
+    request, err := services.waitlist.FindDocument(c.Request.Context(), requestId)
+    if err == nil && !takesPart(c, request.Patient.Id, request.Doctor.Id) {
+        err = db_service.ErrNotFound
+    }
+    if err != nil {
+        waitlistErrorResponse(c, err, requestId)
+        return
+    }
+    if request.Status != waitlistStatusWaiting && request.Status != waitlistStatusOffered {
+        c.JSON(http.StatusConflict, gin.H{
+            "error":   "request is not waiting",
+            "message": "waitlist request " + requestId + " is " + request.Status,
+            "status":  "Conflict",
+        })
+        return
+    }
+
+    released := request.Offer
+    if request.Status != waitlistStatusOffered {
+        released = nil
+    }
+    request.Status = waitlistStatusCancelled
+    err = services.waitlist.ReplaceDocumentIfVersion(c.Request.Context(), requestId, request.Version, request)
+    if err != nil {
+        waitlistErrorResponse(c, err, requestId)
+        return
+    }
+    if released != nil {
+        go services.offerSlot(request.Doctor.Id, released.LocationId, timeInterval{released.Start, released.End})
+    }
+    c.AbortWithStatus(http.StatusNoContent)
+}
+
+func (api *implWaitlistAPI) AcceptSlotOffer(c *gin.Context) {
+    services, ok := slotOfferServicesFromContext(c)
+    if !ok {
+        return
+    }
+    userService, ok := dbServiceFromContext[User](c, "user_service")
+    if !ok {
+        return
+    }
+    locationService, ok := dbServiceFromContext[Location](c, "location_service")
+    if !ok {
+        return
+    }
+    availabilityService, ok := dbServiceFromContext[Availability](c, "availability_service")
+    if !ok {
+        return
+    }
+
+    ctx := c.Request.Context()
+    requestId := c.Param("requestId")
+    request, err := services.waitlist.FindDocument(ctx, requestId)
+    if err == nil && !takesPart(c, request.Patient.Id, request.Doctor.Id) {
+        err = db_service.ErrNotFound
+    }
+    if err != nil {
+        waitlistErrorResponse(c, err, requestId)
+        return
+    }
+    if !offerValid(request) {
+        noOfferResponse(c, request)
+        return
+    }
+
+    offer := *request.Offer
+    appointment := Appointment{
+        Id:              uuid.New().String(),
+        Patient:         User{Id: request.Patient.Id},
+        Doctor:          User{Id: request.Doctor.Id},
+        Location:        Location{Id: offer.LocationId},
+        DateTime:        offer.Start,
+        DurationMinutes: int32(offer.End.Sub(offer.Start) / time.Minute),
+    }
+    if err := resolveAppointmentReferences(ctx, userService, locationService, &appointment, nil); err != nil {
+        referencesErrorResponse(c, err)
+        return
+    }
+    creator, err := creatorReference(c, userService, appointment.Patient)
+    if err != nil {
+        referencesErrorResponse(c, err)
+        return
+    }
+    markCreated(&appointment, creator)
+    appointment.Status = statusScheduled
+    appointment.StatusHistory = []StatusChange{{
+        Status:    statusScheduled,
+        ChangedAt: time.Now().UTC(),
+        ChangedBy: appointment.CreatedBy,
+        Reason:    "Booked from the waitlist",
+    }}
+    err = applyUrgency(&appointment)
+    if err == nil {
+        err = api.types.applyDuration(&appointment)
+    }
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{
+            "error":   err.Error(),
+            "message": "offered slot cannot be booked",
+            "status":  "Bad Request",
+        })
+        return
+    }
+    if err := checkAvailability(ctx, availabilityService, locationService, &appointment); err != nil {
+        availabilityCheckResponse(c, err)
+        return
+    }
+
+    unlock, err := lockBooking(ctx, &appointment)
+    if err != nil {
+        bookingLockResponse(c, err)
+        return
+    }
+    defer unlock()
+
+    // the offer could have expired or been declined while the appointment was
+    // prepared, expiring and declining offers hold the same lock
+    request, err = services.waitlist.FindDocument(ctx, requestId)
+    if err == nil && (!offerValid(request) || *request.Offer != offer) {
+        noOfferResponse(c, request)
+        return
+    }
+    var conflicting *Appointment
+    if err == nil {
+        conflicting, err = findConflictingAppointment(ctx, services.appointments, &appointment)
+    }
+    if err != nil {
+        waitlistErrorResponse(c, err, requestId)
+        return
+    }
+    if conflicting != nil {
+        bookingConflictResponse(c, &appointment, conflicting)
+        return
+    }
+
+    err = services.appointments.CreateDocument(ctx, appointment.Id, &appointment)
+    if err == nil {
+        request.Status = waitlistStatusBooked
+        request.AppointmentId = appointment.Id
+        err = services.waitlist.ReplaceDocumentIfVersion(ctx, requestId, request.Version, request)
+        if err != nil {
+            // the request changed in the meantime, the booking is not kept
+            undoCreated(ctx, services.appointments, appointment.Id)
+        }
+    }
+    if err != nil {
+        waitlistErrorResponse(c, err, requestId)
+        return
+    }
+    setETag(c, appointment.Version)
+    c.JSON(http.StatusCreated, appointment)
+}
+
+func (api *implWaitlistAPI) DeclineSlotOffer(c *gin.Context) {
+    services, ok := slotOfferServicesFromContext(c)
+    if !ok {
+        return
+    }
+
+    ctx := c.Request.Context()
+    requestId := c.Param("requestId")
+    request, err := services.waitlist.FindDocument(ctx, requestId)
+    if err == nil && !takesPart(c, request.Patient.Id, request.Doctor.Id) {
+        err = db_service.ErrNotFound
+    }
+    if err != nil {
+        waitlistErrorResponse(c, err, requestId)
+        return
+    }
+    if request.Status != waitlistStatusOffered || request.Offer == nil {
+        noOfferResponse(c, request)
+        return
+    }
+
+    unlock, err := bookingLocks.Lock(ctx, "doctor/"+request.Doctor.Id, "location/"+request.Offer.LocationId)
+    if err != nil {
+        bookingLockResponse(c, err)
+        return
+    }
+    declined := *request.Offer
+    request.Status = waitlistStatusWaiting
+    request.Offer = nil
+    request.DeclinedSlots = append(request.DeclinedSlots, declined.Start)
+    err = services.waitlist.ReplaceDocumentIfVersion(ctx, requestId, request.Version, request)
+    unlock()
+    if err != nil {
+        waitlistErrorResponse(c, err, requestId)
+        return
+    }
+
+    go services.offerSlot(request.Doctor.Id, declined.LocationId, timeInterval{declined.Start, declined.End})
+    c.JSON(http.StatusOK, request)
+}
+
+// validateWaitlistRequest validates the time window of the request.
+func validateWaitlistRequest(request *WaitlistRequest) error {
+    switch {
+    case request.From.IsZero() || request.To.IsZero():
+        return fmt.Errorf("from and to are required")
+    case !request.To.After(request.From):
+        return fmt.Errorf("to must be after from")
+    case !request.To.After(time.Now()):
+        return fmt.Errorf("to must be in the future")
+    case request.DurationMinutes < 0:
+        return fmt.Errorf("durationMinutes must be positive")
+    case request.To.Sub(request.From) < time.Duration(request.DurationMinutes)*time.Minute:
+        return fmt.Errorf("time window is shorter than durationMinutes")
+    }
+    return nil
+}
+
+// offerValid reports whether a slot is currently held for the request.
+func offerValid(request *WaitlistRequest) bool {
+    return request.Status == waitlistStatusOffered && request.Offer != nil && time.Now().Before(request.Offer.ExpiresAt)
+}
+
+// slotHoldDuration reads how long an offered slot is held for the patient,
+// AMBULANCE_API_SLOT_HOLD_MINUTES, 30 minutes by default.
+func slotHoldDuration() time.Duration {
+    minutes := defaultSlotHoldMinutes
+    if value, err := strconv.Atoi(os.Getenv("AMBULANCE_API_SLOT_HOLD_MINUTES")); err == nil && value > 0 {
+        minutes = value
+    }
+    return time.Duration(minutes) * time.Minute
+}
+
+func slotOfferServicesFromContext(c *gin.Context) (*slotOfferServices, bool) {
+    var ok bool
+    services := &slotOfferServices{notifications: notificationServiceFromContext(c)}
+    if services.waitlist, ok = dbServiceFromContext[WaitlistRequest](c, "waitlist_service"); !ok {
+        return nil, false
+    }
+    if services.appointments, ok = dbServiceFromContext[Appointment](c, "appointment_service"); !ok {
+        return nil, false
+    }
+    return services, true
+}
+
+// offerFreedSlot offers the time of the cancelled or deleted appointment to
+// the waitlist of its doctor. Nothing is offered when the waitlist is not
+// configured or the appointment has already started.
+func offerFreedSlot(c *gin.Context, appointment *Appointment) {
+    waitlist, _ := c.Value("waitlist_service").(db_service.DbService[WaitlistRequest])
+    appointments, _ := c.Value("appointment_service").(db_service.DbService[Appointment])
+    if waitlist == nil || appointments == nil || appointment.EndDateTime.IsZero() || !appointment.DateTime.After(time.Now()) {
+        return
+    }
+    services := &slotOfferServices{
+        waitlist:      waitlist,
+        appointments:  appointments,
+        notifications: notificationServiceFromContext(c),
+    }
+    go services.offerSlot(appointment.Doctor.Id, appointment.Location.Id, timeInterval{appointment.DateTime, appointment.EndDateTime})
+}
+
+// offerSlot holds the free slot for the waiting patient who registered first
+// and whose time window, location and duration fit the slot, skipping
+// patients who have already declined it. The slot is offered only while it is
+// still free and in the future.
+func (s *slotOfferServices) offerSlot(doctorId string, locationId string, slot timeInterval) {
+    ctx, cancel := context.WithTimeout(context.Background(), slotOfferTimeout)
+    defer cancel()
+
+    probe := Appointment{
+        Doctor:      User{Id: doctorId},
+        Location:    Location{Id: locationId},
+        DateTime:    slot.start,
+        EndDateTime: slot.end,
+    }
+    unlock, err := lockBooking(ctx, &probe)
+    if err != nil {
+        log.Printf("Failed to lock freed slot of doctor %v: %v", doctorId, err)
+        return
+    }
+    defer unlock()
+
+    if !slot.start.After(time.Now()) {
+        return
+    }
+    conflicting, err := findConflictingAppointment(ctx, s.appointments, &probe)
+    var holding *WaitlistRequest
+    if err == nil && conflicting == nil {
+        holding, err = findConflictingHold(ctx, s.waitlist, &probe, "")
+    }
+    if err != nil {
+        log.Printf("Failed to offer freed slot of doctor %v at %v: %v", doctorId, slot.start, err)
+        return
+    }
+    if conflicting != nil || holding != nil {
+        return
+    }
+
+    requests, err := s.waitlist.FindDocuments(ctx, db_service.Query{
+        Filter: db_service.And(
+            db_service.Eq("doctor.id", doctorId),
+            db_service.Eq("status", waitlistStatusWaiting),
+            db_service.Lte("from", slot.start),
+            db_service.Gte("to", slot.end),
+        ),
+        Sort: []db_service.SortField{{Field: "createdat"}, {Field: "id"}},
+    })
+    if err != nil {
+        log.Printf("Failed to offer freed slot of doctor %v at %v: %v", doctorId, slot.start, err)
+        return
+    }
+
+    expiresAt := time.Now().UTC().Add(slotHoldDuration())
+    if expiresAt.After(slot.start) {
+        expiresAt = slot.start
+    }
+    for i := range requests {
+        request := &requests[i]
+        if !slotFits(request, locationId, slot) {
+            continue
+        }
+        request.Status = waitlistStatusOffered
+        request.Offer = &SlotOffer{
+            Start:      slot.start.UTC(),
+            End:        slot.end.UTC(),
+            LocationId: locationId,
+            ExpiresAt:  expiresAt,
+        }
+        if err := s.waitlist.ReplaceDocumentIfVersion(ctx, request.Id, request.Version, request); err != nil {
+            // the patient has just left the waitlist, try the next one
+            log.Printf("Failed to offer freed slot to waitlist request %v: %v", request.Id, err)
+            continue
+        }
+        notify(ctx, s.notifications, Notification{
+            RecipientId: request.Patient.Id,
+            Type:        notificationSlotOffered,
+            Message: fmt.Sprintf(
+                "A slot with %v at %v is held for you until %v, accept or decline it",
+                request.Doctor.Name,
+                slot.start.UTC().Format(time.RFC3339),
+                expiresAt.Format(time.RFC3339),
+            ),
+            WaitlistRequestId: request.Id,
+        })
+        return
+    }
+}
+
+// slotFits reports whether the slot at the location suits the request.
+func slotFits(request *WaitlistRequest, locationId string, slot timeInterval) bool {
+    if request.Location.Id != "" && request.Location.Id != locationId {
+        return false
+    }
+    if slot.end.Sub(slot.start) < time.Duration(request.DurationMinutes)*time.Minute {
+        return false
+    }
+    for _, declined := range request.DeclinedSlots {
+        if declined.Equal(slot.start) {
+            return false
+        }
+    }
+    return true
+}
+
+// findConflictingHold returns a request other than the ignored one holding an
+// offered slot of the same doctor or location overlapping the appointment.
+func findConflictingHold(ctx context.Context, waitlist db_service.DbService[WaitlistRequest], appointment *Appointment, ignoredId string) (*WaitlistRequest, error) {
+    holds, err := waitlist.FindDocuments(ctx, db_service.Query{
+        Filter: db_service.And(
+            db_service.Ne("id", ignoredId),
+            db_service.Eq("status", waitlistStatusOffered),
+            db_service.Gt("offer.expiresat", time.Now()),
+            db_service.Lt("offer.start", appointment.EndDateTime),
+            db_service.Gt("offer.end", appointment.DateTime),
+            db_service.Or(
+                db_service.Eq("doctor.id", appointment.Doctor.Id),
+                db_service.Eq("offer.locationid", appointment.Location.Id),
+            ),
+        ),
+        Limit: 1,
+    })
+    if err != nil || len(holds) == 0 {
+        return nil, err
+    }
+    return &holds[0], nil
+}
+
+// checkSlotHolds verifies that the time of the appointment is not held for a
+// patient from the waitlist. The caller holds the booking lock of the
+// appointment. On failure it responds and returns false.
+func checkSlotHolds(c *gin.Context, appointment *Appointment) bool {
+    waitlist, _ := c.Value("waitlist_service").(db_service.DbService[WaitlistRequest])
+    if waitlist == nil {
+        return true
+    }
+    holding, err := findConflictingHold(c, waitlist, appointment, "")
+    if err != nil {
+        c.JSON(
+            http.StatusBadGateway,
+            gin.H{
+                "status":  "Bad Gateway",
+                "message": "Failed to check slots held for the waitlist",
+                "error":   err.Error(),
+            })
+        return false
+    }
+    if holding != nil {
+        c.JSON(
+            http.StatusConflict,
+            gin.H{
+                "status":  "Conflict",
+                "message": "Time slot is held for a patient from the waitlist",
+                "error":   "slot is offered to waitlist request " + holding.Id + " until " + holding.Offer.ExpiresAt.Format(time.RFC3339),
+            })
+        return false
+    }
+    return true
+}
+
+// ExpireSlotOffers periodically releases slots not accepted within the hold,
+// expires the requests of the patients who did not respond and offers the
+// slots to the next waiting patients, until the context is cancelled.
+func ExpireSlotOffers(
+    ctx context.Context,
+    waitlist db_service.DbService[WaitlistRequest],
+    appointments db_service.DbService[Appointment],
+    notifications db_service.DbService[Notification],
+) {
+    services := &slotOfferServices{waitlist: waitlist, appointments: appointments, notifications: notifications}
+    ticker := time.NewTicker(slotOfferCheckInterval)
+    defer ticker.Stop()
+    for {
+        expired, err := waitlist.FindDocuments(ctx, db_service.Query{
+            Filter: db_service.And(
+                db_service.Eq("status", waitlistStatusOffered),
+                db_service.Lte("offer.expiresat", time.Now()),
+            ),
+        })
+        if err != nil {
+            log.Printf("Failed to find expired slot offers: %v", err)
+        }
+        for i := range expired {
+            services.expireOffer(ctx, &expired[i])
+        }
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// expireOffer expires the request unless it was accepted or declined in the
+// meantime and offers the slot to the next patient.
+func (s *slotOfferServices) expireOffer(ctx context.Context, request *WaitlistRequest) {
+    unlock, err := bookingLocks.Lock(ctx, "doctor/"+request.Doctor.Id, "location/"+request.Offer.LocationId)
+    if err != nil {
+        log.Printf("Failed to lock slot offered by waitlist request %v: %v", request.Id, err)
+        return
+    }
+    current, err := s.waitlist.FindDocument(ctx, request.Id)
+    if err == nil && (current.Status != waitlistStatusOffered || current.Offer == nil || offerValid(current)) {
+        unlock()
+        return
+    }
+    if err == nil {
+        current.Status = waitlistStatusExpired
+        err = s.waitlist.ReplaceDocumentIfVersion(ctx, current.Id, current.Version, current)
+    }
+    unlock()
+    if err != nil {
+        log.Printf("Failed to expire slot offer of waitlist request %v: %v", request.Id, err)
+        return
+    }
+
+    notify(ctx, s.notifications, Notification{
+        RecipientId:       current.Patient.Id,
+        Type:              notificationSlotOfferExpired,
+        Message:           "The slot offered to you was not accepted in time and was released, join the waitlist again to get another one",
+        WaitlistRequestId: current.Id,
+    })
+    s.offerSlot(current.Doctor.Id, current.Offer.LocationId, timeInterval{current.Offer.Start, current.Offer.End})
+}
+
+func noOfferResponse(c *gin.Context, request *WaitlistRequest) {
+    message := "no slot is offered to waitlist request " + request.Id
+    if request.Status == waitlistStatusOffered && request.Offer != nil {
+        message = "hold of the offered slot expired at " + request.Offer.ExpiresAt.Format(time.RFC3339)
+    }
+    c.JSON(http.StatusConflict, gin.H{
+        "error":   "no slot is offered",
+        "message": message,
+        "status":  "Conflict",
+    })
+}
+
+func waitlistErrorResponse(c *gin.Context, err error, requestId string) {
+    switch err {
+    case db_service.ErrNotFound:
+        c.JSON(http.StatusNotFound, gin.H{
+            "error":   err.Error(),
+            "message": "waitlist request " + requestId + " not found",
+            "status":  "Not Found",
+        })
+    case db_service.ErrConflict, db_service.ErrPreconditionFailed:
+        c.JSON(http.StatusConflict, gin.H{
+            "error":   err.Error(),
+            "message": "waitlist request already exists or was changed concurrently",
+            "status":  "Conflict",
+        })
+    default:
+        c.JSON(http.StatusInternalServerError, gin.H{
+            "error":   "failed to access waitlist",
+            "message": err.Error(),
+            "status":  "Internal Server Error",
+        })
+    }
+}
@@ -0,0 +1,48 @@
+/*
+ * Appointment Scheduling Api
+ *
+ * Medical Appointment Scheduling System
+ *
+ * API version: 1.0.0
+ * Contact: xpokorny@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+import (
+	"time"
+)
+
+type ApiKey struct {
+
+	// Unique identifier of the API key
+	Id string `json:"id,omitempty"`
+
+	// Name of the machine client using the key
+	Name string `json:"name"`
+
+	// Operations the key may call, read scopes allow GET requests and write scopes the others
+	Scopes []string `json:"scopes"`
+
+	// The API key, returned only when the key is issued
+	Key string `json:"key,omitempty"`
+
+	// Beginning of the key to recognize it in the list
+	Prefix string `json:"prefix,omitempty"`
+
+	// Date and time when the key was issued
+	CreatedAt time.Time `json:"createdAt,omitempty"`
+
+	// ID of the user who issued the key
+	CreatedBy string `json:"createdBy,omitempty"`
+
+	// Date and time when the key expires, null if it does not expire
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+
+	// Date and time when the key was revoked, null if it is valid
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+
+	// Version of the API key, incremented on every change
+	Version int64 `json:"version,omitempty"`
+}
@@ -28,5 +28,46 @@ type Appointment struct {
 	// Date and time of the appointment
 	DateTime time.Time `json:"dateTime"`
 
+	// Type of the appointment, determines the default duration
+	Type string `json:"type,omitempty"`
+
+	// Duration of the appointment in minutes
+	DurationMinutes int32 `json:"durationMinutes,omitempty"`
+
+	// Date and time when the appointment ends, computed from dateTime and durationMinutes
+	EndDateTime time.Time `json:"endDateTime,omitempty"`
+
 	CreatedBy User `json:"createdBy"`
+
+	// Date and time when the appointment was created
+	CreatedAt time.Time `json:"createdAt,omitempty"`
+
+	UpdatedBy User `json:"updatedBy,omitempty"`
+
+	// Date and time of the last change of the appointment
+	UpdatedAt time.Time `json:"updatedAt,omitempty"`
+
+	// Current status of the appointment, changed only by the status transition endpoints
+	Status string `json:"status,omitempty"`
+
+	// Status transitions of the appointment, oldest first
+	StatusHistory []StatusChange `json:"statusHistory,omitempty"`
+
+	// Date and time when the appointment was deleted, null if it is not deleted
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+
+	// ID of the user who deleted the appointment
+	DeletedBy string `json:"deletedBy,omitempty"`
+
+	// Version of the appointment, incremented on every change and returned in the ETag header
+	Version int64 `json:"version,omitempty"`
+
+	// ID of the recurring series the appointment was booked by, empty for single appointments
+	SeriesId string `json:"seriesId,omitempty"`
+
+	// Triage urgency of the appointment, standard when not given
+	Urgency string `json:"urgency,omitempty"`
+
+	// Priority derived from urgency, from 1 for non-urgent up to 5 for immediate, used for sorting
+	Priority int32 `json:"priority,omitempty"`
 }
@@ -0,0 +1,52 @@
+/*
+ * Appointment Scheduling Api
+ *
+ * Medical Appointment Scheduling System
+ *
+ * API version: 1.0.0
+ * Contact: xpokorny@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+import (
+	"time"
+)
+
+type AppointmentSeries struct {
+
+	// Unique identifier of the series
+	Id string `json:"id,omitempty"`
+
+	Patient User `json:"patient"`
+
+	Doctor User `json:"doctor"`
+
+	Location Location `json:"location"`
+
+	// Date and time of the first occurrence
+	DateTime time.Time `json:"dateTime"`
+
+	// IANA time zone in which the occurrences keep their time of day, UTC when not given
+	TimeZone string `json:"timeZone,omitempty"`
+
+	// Type of the appointments, determines the default duration
+	Type string `json:"type,omitempty"`
+
+	// Duration of every appointment in minutes
+	DurationMinutes int32 `json:"durationMinutes,omitempty"`
+
+	CreatedBy User `json:"createdBy"`
+
+	Recurrence RecurrenceRule `json:"recurrence"`
+
+	// Status of the series
+	Status string `json:"status,omitempty"`
+
+	// ID of the series this series was split from by a change of this and following occurrences
+	PreviousSeriesId string `json:"previousSeriesId,omitempty"`
+
+	// Version of the series, incremented on every change
+	Version int64 `json:"version,omitempty"`
+}
@@ -0,0 +1,26 @@
+/*
+ * Appointment Scheduling Api
+ *
+ * Medical Appointment Scheduling System
+ *
+ * API version: 1.0.0
+ * Contact: xpokorny@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+type AuditChange struct {
+
+	// Collection of the changed record, e.g. appointments
+	Collection string `json:"collection"`
+
+	// ID of the changed record
+	DocumentId string `json:"documentId"`
+
+	// Kind of the change
+	Operation string `json:"operation"`
+
+	// Changed fields of the record
+	Diff []AuditFieldChange `json:"diff,omitempty"`
+}
@@ -0,0 +1,57 @@
+/*
+ * Appointment Scheduling Api
+ *
+ * Medical Appointment Scheduling System
+ *
+ * API version: 1.0.0
+ * Contact: xpokorny@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+import (
+	"time"
+)
+
+type AuditEntry struct {
+
+	// Unique identifier of the entry
+	Id string `json:"id"`
+
+	// Date and time when the request was handled or the change was made
+	Timestamp time.Time `json:"timestamp"`
+
+	// ID of the user or API key who made the request, system for background jobs
+	ActorId string `json:"actorId"`
+
+	// Role of the actor, api-key for API keys
+	ActorRole string `json:"actorRole,omitempty"`
+
+	// Whether the request read or changed records
+	Action string `json:"action"`
+
+	// Name of the called operation, e.g. GetAppointment, empty for background jobs
+	RouteName string `json:"routeName,omitempty"`
+
+	// HTTP method of the request
+	Method string `json:"method,omitempty"`
+
+	// Path and query of the request
+	Path string `json:"path,omitempty"`
+
+	// ID of the record named in the path, e.g. the appointment ID
+	ResourceId string `json:"resourceId,omitempty"`
+
+	// IDs of the record named in the path, of the records returned by a list and of all changed records, used for filtering
+	ResourceIds []string `json:"resourceIds,omitempty"`
+
+	// IP address of the client
+	ClientIp string `json:"clientIp,omitempty"`
+
+	// HTTP status code of the response
+	StatusCode int32 `json:"statusCode,omitempty"`
+
+	// Records changed by the request
+	Changes []AuditChange `json:"changes,omitempty"`
+}
@@ -0,0 +1,23 @@
+/*
+ * Appointment Scheduling Api
+ *
+ * Medical Appointment Scheduling System
+ *
+ * API version: 1.0.0
+ * Contact: xpokorny@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+type AuditFieldChange struct {
+
+	// Stored name of the field, nested fields are separated by dots
+	Field string `json:"field"`
+
+	// JSON encoded value before the change, missing when the field was missing or null
+	Before string `json:"before,omitempty"`
+
+	// JSON encoded value after the change, missing when the field was removed or set to null
+	After string `json:"after,omitempty"`
+}
@@ -0,0 +1,29 @@
+/*
+ * Appointment Scheduling Api
+ *
+ * Medical Appointment Scheduling System
+ *
+ * API version: 1.0.0
+ * Contact: xpokorny@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+type Availability struct {
+
+	// ID of the doctor
+	Id string `json:"id,omitempty"`
+
+	// IANA time zone of the working hours, UTC when not given
+	TimeZone string `json:"timeZone,omitempty"`
+
+	// Working hours of the doctor in every week, a day without working hours is a day off
+	WeeklySchedule []WorkingHours `json:"weeklySchedule"`
+
+	// Absences of the doctor, e.g. vacations and sick leaves
+	Exceptions []AvailabilityException `json:"exceptions,omitempty"`
+
+	// Version of the availability, incremented on every change
+	Version int64 `json:"version,omitempty"`
+}
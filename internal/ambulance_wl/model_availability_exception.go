@@ -0,0 +1,33 @@
+/*
+ * Appointment Scheduling Api
+ *
+ * Medical Appointment Scheduling System
+ *
+ * API version: 1.0.0
+ * Contact: xpokorny@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+import (
+	"time"
+)
+
+type AvailabilityException struct {
+
+	// Unique identifier of the absence
+	Id string `json:"id,omitempty"`
+
+	// Start of the absence
+	From time.Time `json:"from"`
+
+	// End of the absence
+	To time.Time `json:"to"`
+
+	// Reason of the absence
+	Reason string `json:"reason,omitempty"`
+
+	// Optional note about the absence
+	Note string `json:"note,omitempty"`
+}
@@ -20,4 +20,10 @@ type Location struct {
 
 	// Physical address of the location
 	Address string `json:"address"`
+
+	// IANA time zone of the opening hours, UTC when not given
+	TimeZone string `json:"timeZone,omitempty"`
+
+	// Opening hours of the location in every week, the location is always open when there are none
+	OpeningHours []WorkingHours `json:"openingHours,omitempty"`
 }
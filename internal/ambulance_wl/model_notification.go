@@ -0,0 +1,45 @@
+/*
+ * Appointment Scheduling Api
+ *
+ * Medical Appointment Scheduling System
+ *
+ * API version: 1.0.0
+ * Contact: xpokorny@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+import (
+	"time"
+)
+
+type Notification struct {
+
+	// Unique identifier of the notification
+	Id string `json:"id"`
+
+	// ID of the notified user
+	RecipientId string `json:"recipientId"`
+
+	// Kind of the change the user is notified about
+	Type string `json:"type"`
+
+	// Human readable text of the notification
+	Message string `json:"message"`
+
+	// ID of the concerned appointment
+	AppointmentId string `json:"appointmentId,omitempty"`
+
+	// ID of the concerned waiting list entry
+	WaitingListEntryId string `json:"waitingListEntryId,omitempty"`
+
+	// ID of the concerned waitlist request
+	WaitlistRequestId string `json:"waitlistRequestId,omitempty"`
+
+	// Date and time when the notification was created
+	CreatedAt time.Time `json:"createdAt"`
+
+	// Date and time when the user read the notification, null if unread
+	ReadAt *time.Time `json:"readAt,omitempty"`
+}
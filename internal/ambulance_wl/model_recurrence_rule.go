@@ -0,0 +1,33 @@
+/*
+ * Appointment Scheduling Api
+ *
+ * Medical Appointment Scheduling System
+ *
+ * API version: 1.0.0
+ * Contact: xpokorny@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+import (
+	"time"
+)
+
+type RecurrenceRule struct {
+
+	// Unit of the repetition
+	Frequency string `json:"frequency"`
+
+	// Number of frequency units between occurrences, 1 when not given
+	Interval int32 `json:"interval,omitempty"`
+
+	// Number of occurrences, either count or until must be given
+	Count int32 `json:"count,omitempty"`
+
+	// Date and time of the last possible occurrence
+	Until *time.Time `json:"until,omitempty"`
+
+	// Start times of occurrences which are skipped
+	Exceptions []time.Time `json:"exceptions,omitempty"`
+}
@@ -0,0 +1,30 @@
+/*
+ * Appointment Scheduling Api
+ *
+ * Medical Appointment Scheduling System
+ *
+ * API version: 1.0.0
+ * Contact: xpokorny@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+import (
+	"time"
+)
+
+type Slot struct {
+
+	// Start of the free slot
+	Start time.Time `json:"start"`
+
+	// End of the free slot
+	End time.Time `json:"end"`
+
+	// ID of the doctor
+	DoctorId string `json:"doctorId"`
+
+	// ID of the location
+	LocationId string `json:"locationId"`
+}
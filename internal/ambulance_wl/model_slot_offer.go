@@ -0,0 +1,30 @@
+/*
+ * Appointment Scheduling Api
+ *
+ * Medical Appointment Scheduling System
+ *
+ * API version: 1.0.0
+ * Contact: xpokorny@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+import (
+	"time"
+)
+
+type SlotOffer struct {
+
+	// Start of the offered slot
+	Start time.Time `json:"start"`
+
+	// End of the offered slot
+	End time.Time `json:"end"`
+
+	// ID of the location of the offered slot
+	LocationId string `json:"locationId"`
+
+	// Date and time until the slot is held for the patient
+	ExpiresAt time.Time `json:"expiresAt"`
+}
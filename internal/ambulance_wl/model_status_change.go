@@ -0,0 +1,29 @@
+/*
+ * Appointment Scheduling Api
+ *
+ * Medical Appointment Scheduling System
+ *
+ * API version: 1.0.0
+ * Contact: xpokorny@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+import (
+	"time"
+)
+
+type StatusChange struct {
+
+	// Status of the appointment after the change
+	Status string `json:"status"`
+
+	// Date and time of the change
+	ChangedAt time.Time `json:"changedAt"`
+
+	ChangedBy User `json:"changedBy"`
+
+	// Optional reason of the change, e.g. why the appointment was cancelled
+	Reason string `json:"reason,omitempty"`
+}
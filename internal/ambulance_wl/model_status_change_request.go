@@ -0,0 +1,19 @@
+/*
+ * Appointment Scheduling Api
+ *
+ * Medical Appointment Scheduling System
+ *
+ * API version: 1.0.0
+ * Contact: xpokorny@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+type StatusChangeRequest struct {
+
+	ChangedBy User `json:"changedBy,omitempty"`
+
+	// Optional reason of the change
+	Reason string `json:"reason,omitempty"`
+}
@@ -0,0 +1,19 @@
+/*
+ * Appointment Scheduling Api
+ *
+ * Medical Appointment Scheduling System
+ *
+ * API version: 1.0.0
+ * Contact: xpokorny@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+type UrgentInsertion struct {
+
+	Appointment Appointment `json:"appointment"`
+
+	// Appointments moved later to make room for the urgent one
+	MovedAppointments []Appointment `json:"movedAppointments"`
+}
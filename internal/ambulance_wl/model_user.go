@@ -20,4 +20,10 @@ type User struct {
 
 	// Role of the user
 	Role string `json:"role"`
+
+	// Deactivated users are kept for the history but cannot be booked
+	Deactivated bool `json:"deactivated,omitempty"`
+
+	// Version of the user, incremented on every change
+	Version int64 `json:"version,omitempty"`
 }
@@ -0,0 +1,20 @@
+/*
+ * Appointment Scheduling Api
+ *
+ * Medical Appointment Scheduling System
+ *
+ * API version: 1.0.0
+ * Contact: xpokorny@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+type UserPatch struct {
+
+	// Full name of the user
+	Name string `json:"name,omitempty"`
+
+	// Role of the user
+	Role string `json:"role,omitempty"`
+}
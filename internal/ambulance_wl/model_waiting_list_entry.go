@@ -0,0 +1,51 @@
+/*
+ * Appointment Scheduling Api
+ *
+ * Medical Appointment Scheduling System
+ *
+ * API version: 1.0.0
+ * Contact: xpokorny@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+import (
+	"time"
+)
+
+type WaitingListEntry struct {
+
+	// Unique identifier of the entry
+	Id string `json:"id,omitempty"`
+
+	Patient User `json:"patient"`
+
+	Doctor User `json:"doctor"`
+
+	Location Location `json:"location"`
+
+	// Date and time of the arrival of the patient, now when not given
+	ArrivedAt time.Time `json:"arrivedAt,omitempty"`
+
+	// Triage urgency of the visit, more urgent patients are called first, standard when not given
+	Urgency string `json:"urgency,omitempty"`
+
+	// Priority derived from urgency, from 1 for non-urgent up to 5 for immediate, used for sorting
+	Priority int32 `json:"priority,omitempty"`
+
+	// State of the entry
+	Status string `json:"status,omitempty"`
+
+	// Date and time when the patient was called
+	CalledAt *time.Time `json:"calledAt,omitempty"`
+
+	// Position in the queue starting at 1, only for waiting patients
+	Position int32 `json:"position,omitempty"`
+
+	// Estimated wait in minutes computed from the average visit duration of the doctor, only for waiting patients
+	EstimatedWaitMinutes int32 `json:"estimatedWaitMinutes,omitempty"`
+
+	// Version of the entry, incremented on every change
+	Version int64 `json:"version,omitempty"`
+}
@@ -0,0 +1,53 @@
+/*
+ * Appointment Scheduling Api
+ *
+ * Medical Appointment Scheduling System
+ *
+ * API version: 1.0.0
+ * Contact: xpokorny@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+import (
+	"time"
+)
+
+type WaitlistRequest struct {
+
+	// Unique identifier of the request
+	Id string `json:"id"`
+
+	Patient User `json:"patient"`
+
+	Doctor User `json:"doctor"`
+
+	Location Location `json:"location,omitempty"`
+
+	// Start of the time window the patient is available in
+	From time.Time `json:"from"`
+
+	// End of the time window the patient is available in
+	To time.Time `json:"to"`
+
+	// Minimal length of the offered slot in minutes, the default appointment duration when not given
+	DurationMinutes int32 `json:"durationMinutes,omitempty"`
+
+	// State of the request, an offered slot not accepted in time expires the request
+	Status string `json:"status,omitempty"`
+
+	// Date and time the patient joined the waitlist, earlier requests are offered slots first
+	CreatedAt time.Time `json:"createdAt,omitempty"`
+
+	Offer *SlotOffer `json:"offer,omitempty"`
+
+	// Start times of slots declined by the patient, they are not offered again
+	DeclinedSlots []time.Time `json:"declinedSlots,omitempty"`
+
+	// ID of the appointment booked by accepting an offer
+	AppointmentId string `json:"appointmentId,omitempty"`
+
+	// Version of the request, incremented on every change
+	Version int64 `json:"version,omitempty"`
+}
@@ -0,0 +1,23 @@
+/*
+ * Appointment Scheduling Api
+ *
+ * Medical Appointment Scheduling System
+ *
+ * API version: 1.0.0
+ * Contact: xpokorny@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package ambulance_wl
+
+type WorkingHours struct {
+
+	// Day of the week
+	DayOfWeek string `json:"dayOfWeek"`
+
+	// Start of the working hours in HH:MM format
+	Start string `json:"start"`
+
+	// End of the working hours in HH:MM format, later than start
+	End string `json:"end"`
+}
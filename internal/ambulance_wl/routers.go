@@ -63,22 +63,84 @@ func DefaultHandleFunc(c *gin.Context) {
 
 type ApiHandleFunctions struct {
 
+	// Routes for the ApiKeysAPI part of the API
+	ApiKeysAPI ApiKeysAPI
 	// Routes for the AppointmentsAPI part of the API
 	AppointmentsAPI AppointmentsAPI
+	// Routes for the AuditAPI part of the API
+	AuditAPI AuditAPI
+	// Routes for the AvailabilityAPI part of the API
+	AvailabilityAPI AvailabilityAPI
 	// Routes for the LocationsAPI part of the API
 	LocationsAPI LocationsAPI
+	// Routes for the NotificationsAPI part of the API
+	NotificationsAPI NotificationsAPI
+	// Routes for the SlotsAPI part of the API
+	SlotsAPI SlotsAPI
 	// Routes for the UsersAPI part of the API
 	UsersAPI UsersAPI
+	// Routes for the WaitingListAPI part of the API
+	WaitingListAPI WaitingListAPI
+	// Routes for the WaitlistAPI part of the API
+	WaitlistAPI WaitlistAPI
 }
 
 func getRoutes(handleFunctions ApiHandleFunctions) []Route {
 	return []Route{ 
+		{
+			"GetApiKeys",
+			http.MethodGet,
+			"/api/api-keys",
+			handleFunctions.ApiKeysAPI.GetApiKeys,
+		},
+		{
+			"IssueApiKey",
+			http.MethodPost,
+			"/api/api-keys",
+			handleFunctions.ApiKeysAPI.IssueApiKey,
+		},
+		{
+			"RevokeApiKey",
+			http.MethodDelete,
+			"/api/api-keys/:keyId",
+			handleFunctions.ApiKeysAPI.RevokeApiKey,
+		},
+		{
+			"CancelAppointment",
+			http.MethodPost,
+			"/api/appointments/:appointmentId/cancel",
+			handleFunctions.AppointmentsAPI.CancelAppointment,
+		},
+		{
+			"CancelAppointmentSeries",
+			http.MethodPost,
+			"/api/appointment-series/:seriesId/cancel",
+			handleFunctions.AppointmentsAPI.CancelAppointmentSeries,
+		},
+		{
+			"CheckInAppointment",
+			http.MethodPost,
+			"/api/appointments/:appointmentId/check-in",
+			handleFunctions.AppointmentsAPI.CheckInAppointment,
+		},
+		{
+			"CompleteAppointment",
+			http.MethodPost,
+			"/api/appointments/:appointmentId/complete",
+			handleFunctions.AppointmentsAPI.CompleteAppointment,
+		},
 		{
 			"CreateAppointment",
 			http.MethodPost,
 			"/api/appointments",
 			handleFunctions.AppointmentsAPI.CreateAppointment,
 		},
+		{
+			"CreateAppointmentSeries",
+			http.MethodPost,
+			"/api/appointment-series",
+			handleFunctions.AppointmentsAPI.CreateAppointmentSeries,
+		},
 		{
 			"DeleteAppointment",
 			http.MethodDelete,
@@ -91,24 +153,156 @@ func getRoutes(handleFunctions ApiHandleFunctions) []Route {
 			"/api/appointments/:appointmentId",
 			handleFunctions.AppointmentsAPI.GetAppointment,
 		},
+		{
+			"GetAppointmentSeries",
+			http.MethodGet,
+			"/api/appointment-series/:seriesId",
+			handleFunctions.AppointmentsAPI.GetAppointmentSeries,
+		},
 		{
 			"GetAppointments",
 			http.MethodGet,
 			"/api/appointments",
 			handleFunctions.AppointmentsAPI.GetAppointments,
 		},
+		{
+			"InsertUrgentAppointment",
+			http.MethodPost,
+			"/api/appointments/urgent",
+			handleFunctions.AppointmentsAPI.InsertUrgentAppointment,
+		},
+		{
+			"MarkAppointmentNoShow",
+			http.MethodPost,
+			"/api/appointments/:appointmentId/no-show",
+			handleFunctions.AppointmentsAPI.MarkAppointmentNoShow,
+		},
+		{
+			"RestoreAppointment",
+			http.MethodPost,
+			"/api/appointments/:appointmentId/restore",
+			handleFunctions.AppointmentsAPI.RestoreAppointment,
+		},
+		{
+			"StartAppointment",
+			http.MethodPost,
+			"/api/appointments/:appointmentId/start",
+			handleFunctions.AppointmentsAPI.StartAppointment,
+		},
 		{
 			"UpdateAppointment",
 			http.MethodPut,
 			"/api/appointments/:appointmentId",
 			handleFunctions.AppointmentsAPI.UpdateAppointment,
 		},
+		{
+			"UpdateAppointmentSeries",
+			http.MethodPut,
+			"/api/appointment-series/:seriesId",
+			handleFunctions.AppointmentsAPI.UpdateAppointmentSeries,
+		},
+		{
+			"GetAuditEntries",
+			http.MethodGet,
+			"/api/audit",
+			handleFunctions.AuditAPI.GetAuditEntries,
+		},
+		{
+			"CreateAvailabilityException",
+			http.MethodPost,
+			"/api/users/:userId/availability/exceptions",
+			handleFunctions.AvailabilityAPI.CreateAvailabilityException,
+		},
+		{
+			"DeleteAvailability",
+			http.MethodDelete,
+			"/api/users/:userId/availability",
+			handleFunctions.AvailabilityAPI.DeleteAvailability,
+		},
+		{
+			"DeleteAvailabilityException",
+			http.MethodDelete,
+			"/api/users/:userId/availability/exceptions/:exceptionId",
+			handleFunctions.AvailabilityAPI.DeleteAvailabilityException,
+		},
+		{
+			"GetAvailability",
+			http.MethodGet,
+			"/api/users/:userId/availability",
+			handleFunctions.AvailabilityAPI.GetAvailability,
+		},
+		{
+			"UpdateAvailability",
+			http.MethodPut,
+			"/api/users/:userId/availability",
+			handleFunctions.AvailabilityAPI.UpdateAvailability,
+		},
+		{
+			"CreateLocation",
+			http.MethodPost,
+			"/api/locations",
+			handleFunctions.LocationsAPI.CreateLocation,
+		},
+		{
+			"DeleteLocation",
+			http.MethodDelete,
+			"/api/locations/:locationId",
+			handleFunctions.LocationsAPI.DeleteLocation,
+		},
+		{
+			"GetLocation",
+			http.MethodGet,
+			"/api/locations/:locationId",
+			handleFunctions.LocationsAPI.GetLocation,
+		},
 		{
 			"GetLocations",
 			http.MethodGet,
 			"/api/locations",
 			handleFunctions.LocationsAPI.GetLocations,
 		},
+		{
+			"UpdateLocation",
+			http.MethodPut,
+			"/api/locations/:locationId",
+			handleFunctions.LocationsAPI.UpdateLocation,
+		},
+		{
+			"GetNotifications",
+			http.MethodGet,
+			"/api/users/:userId/notifications",
+			handleFunctions.NotificationsAPI.GetNotifications,
+		},
+		{
+			"MarkNotificationRead",
+			http.MethodPost,
+			"/api/users/:userId/notifications/:notificationId/read",
+			handleFunctions.NotificationsAPI.MarkNotificationRead,
+		},
+		{
+			"GetSlots",
+			http.MethodGet,
+			"/api/slots",
+			handleFunctions.SlotsAPI.GetSlots,
+		},
+		{
+			"ActivateUser",
+			http.MethodPost,
+			"/api/users/:userId/activate",
+			handleFunctions.UsersAPI.ActivateUser,
+		},
+		{
+			"CreateUser",
+			http.MethodPost,
+			"/api/users",
+			handleFunctions.UsersAPI.CreateUser,
+		},
+		{
+			"DeactivateUser",
+			http.MethodPost,
+			"/api/users/:userId/deactivate",
+			handleFunctions.UsersAPI.DeactivateUser,
+		},
 		{
 			"GetUser",
 			http.MethodGet,
@@ -121,5 +315,83 @@ func getRoutes(handleFunctions ApiHandleFunctions) []Route {
 			"/api/users",
 			handleFunctions.UsersAPI.GetUsers,
 		},
+		{
+			"PatchUser",
+			http.MethodPatch,
+			"/api/users/:userId",
+			handleFunctions.UsersAPI.PatchUser,
+		},
+		{
+			"UpdateUser",
+			http.MethodPut,
+			"/api/users/:userId",
+			handleFunctions.UsersAPI.UpdateUser,
+		},
+		{
+			"CallNextWaitingPatient",
+			http.MethodPost,
+			"/api/waiting-list/call-next",
+			handleFunctions.WaitingListAPI.CallNextWaitingPatient,
+		},
+		{
+			"CreateWaitingListEntry",
+			http.MethodPost,
+			"/api/waiting-list",
+			handleFunctions.WaitingListAPI.CreateWaitingListEntry,
+		},
+		{
+			"DeleteWaitingListEntry",
+			http.MethodDelete,
+			"/api/waiting-list/:entryId",
+			handleFunctions.WaitingListAPI.DeleteWaitingListEntry,
+		},
+		{
+			"GetWaitingList",
+			http.MethodGet,
+			"/api/waiting-list",
+			handleFunctions.WaitingListAPI.GetWaitingList,
+		},
+		{
+			"GetWaitingListEntry",
+			http.MethodGet,
+			"/api/waiting-list/:entryId",
+			handleFunctions.WaitingListAPI.GetWaitingListEntry,
+		},
+		{
+			"AcceptSlotOffer",
+			http.MethodPost,
+			"/api/waitlist/:requestId/accept",
+			handleFunctions.WaitlistAPI.AcceptSlotOffer,
+		},
+		{
+			"CancelWaitlistRequest",
+			http.MethodDelete,
+			"/api/waitlist/:requestId",
+			handleFunctions.WaitlistAPI.CancelWaitlistRequest,
+		},
+		{
+			"CreateWaitlistRequest",
+			http.MethodPost,
+			"/api/waitlist",
+			handleFunctions.WaitlistAPI.CreateWaitlistRequest,
+		},
+		{
+			"DeclineSlotOffer",
+			http.MethodPost,
+			"/api/waitlist/:requestId/decline",
+			handleFunctions.WaitlistAPI.DeclineSlotOffer,
+		},
+		{
+			"GetWaitlistRequest",
+			http.MethodGet,
+			"/api/waitlist/:requestId",
+			handleFunctions.WaitlistAPI.GetWaitlistRequest,
+		},
+		{
+			"GetWaitlistRequests",
+			http.MethodGet,
+			"/api/waitlist",
+			handleFunctions.WaitlistAPI.GetWaitlistRequests,
+		},
 	}
 }
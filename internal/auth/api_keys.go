@@ -0,0 +1,72 @@
+package auth
+
+import (
+    "context"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/hex"
+    "fmt"
+
+    "github.com/gin-gonic/gin"
+)
+
+const (
+    // APIKeyHeader carries the API key of machine clients
+    APIKeyHeader = "X-API-Key"
+    // APIKeyRole is the role of callers authenticated by an API key, they are
+    // authorized by the scopes of the key instead of a role
+    APIKeyRole = "api-key"
+    // ScopesKey is the key of the scopes of the API key in the gin context
+    ScopesKey = "auth_scopes"
+
+    apiKeyPrefix = "amb_"
+    // length of the beginning of the key shown to recognize it
+    apiKeyShownLength = len(apiKeyPrefix) + 6
+)
+
+// APIKey is a valid API key of a machine client.
+type APIKey struct {
+    Id     string
+    Scopes []string
+}
+
+// APIKeyVerifier returns the API key with the hash, or nil when the key is
+// unknown, expired or revoked.
+type APIKeyVerifier func(ctx context.Context, hash string) (*APIKey, error)
+
+// GenerateAPIKey returns a new random API key, the beginning of the key to
+// recognize it and the hash to store instead of the key.
+func GenerateAPIKey() (key string, shown string, hash string, err error) {
+    secret := make([]byte, 32)
+    if _, err := rand.Read(secret); err != nil {
+        return "", "", "", err
+    }
+    key = apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+    return key, key[:apiKeyShownLength], HashAPIKey(key), nil
+}
+
+// HashAPIKey returns the stored hash of the API key. The keys are random and
+// long, so a fast hash does not make guessing them feasible.
+func HashAPIKey(key string) string {
+    sum := sha256.Sum256([]byte(key))
+    return hex.EncodeToString(sum[:])
+}
+
+// Scopes returns the scopes of the API key the caller was authenticated by.
+func Scopes(c *gin.Context) []string {
+    scopes, _ := c.Value(ScopesKey).([]string)
+    return scopes
+}
+
+func (a *Authenticator) authenticateAPIKey(c *gin.Context, key string) (string, string, error) {
+    apiKey, err := a.APIKeys(c.Request.Context(), HashAPIKey(key))
+    if err != nil {
+        return "", "", fmt.Errorf("cannot verify API key: %w", err)
+    }
+    if apiKey == nil {
+        return "", "", fmt.Errorf("invalid API key")
+    }
+    c.Set(ScopesKey, apiKey.Scopes)
+    return apiKey.Id, APIKeyRole, nil
+}
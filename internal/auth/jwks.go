@@ -0,0 +1,240 @@
+package auth
+
+import (
+    "context"
+    "crypto"
+    "crypto/ecdsa"
+    "crypto/ed25519"
+    "crypto/elliptic"
+    "crypto/rsa"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "math/big"
+    "net/http"
+    "os"
+    "strings"
+    "sync"
+    "time"
+)
+
+const (
+    // keys are fetched again after this interval to pick up rotated keys
+    jwksRefreshInterval = time.Hour
+    // unknown key IDs trigger a refresh at most this often, so that tokens
+    // with made up key IDs cannot flood the identity provider
+    jwksMinRefreshInterval = time.Minute
+    jwksFetchTimeout       = 10 * time.Second
+)
+
+// jsonWebKey is a public key of the JSON Web Key Set, RFC 7517 and 8037.
+type jsonWebKey struct {
+    Kty string `json:"kty"`
+    Kid string `json:"kid"`
+    Use string `json:"use"`
+    // RSA
+    N string `json:"n"`
+    E string `json:"e"`
+    // EC and OKP
+    Crv string `json:"crv"`
+    X   string `json:"x"`
+    Y   string `json:"y"`
+}
+
+// keySet holds the public keys of the JWKS loaded from a file or an URL,
+// indexed by their key ID.
+type keySet struct {
+    source    string
+    lock      sync.Mutex
+    keys      map[string]crypto.PublicKey
+    loadedAt  time.Time
+    attempted time.Time
+    // loading is closed when the running load finishes, nil when none runs
+    loading chan struct{}
+}
+
+func newKeySet(source string) *keySet {
+    return &keySet{source: source, keys: map[string]crypto.PublicKey{}}
+}
+
+// key returns the public key with the ID, or the only key of the set when the
+// token does not name its key. The set is loaded again when it is stale or
+// does not know the key ID. Only one request loads it, the others keep using
+// the known keys meanwhile, or wait for the load when their key is unknown.
+func (k *keySet) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+    k.lock.Lock()
+    key, found := k.lookup(kid)
+    stale := time.Since(k.loadedAt) > jwksRefreshInterval
+    loading := k.loading
+    load := loading == nil && (!found || stale) && time.Since(k.attempted) > jwksMinRefreshInterval
+    if load {
+        k.attempted = time.Now()
+        k.loading = make(chan struct{})
+    }
+    k.lock.Unlock()
+
+    switch {
+    case load:
+        if err := k.load(ctx); err != nil {
+            log.Printf("Failed to load JWKS from %v: %v", k.source, err)
+        }
+    case loading != nil && !found:
+        select {
+        case <-loading:
+        case <-ctx.Done():
+        }
+    default:
+        if !found {
+            return nil, fmt.Errorf("unknown signing key %q", kid)
+        }
+        return key, nil
+    }
+
+    k.lock.Lock()
+    key, found = k.lookup(kid)
+    k.lock.Unlock()
+    if !found {
+        return nil, fmt.Errorf("unknown signing key %q", kid)
+    }
+    return key, nil
+}
+
+// lookup finds the key, the caller holds the lock.
+func (k *keySet) lookup(kid string) (crypto.PublicKey, bool) {
+    if kid == "" && len(k.keys) == 1 {
+        for _, key := range k.keys {
+            return key, true
+        }
+    }
+    key, found := k.keys[kid]
+    return key, found
+}
+
+// load reads the key set from its source and replaces the keys by it. The
+// lock is taken only to swap the keys, fetching an URL may take up to
+// jwksFetchTimeout and must not block requests with known keys.
+func (k *keySet) load(ctx context.Context) error {
+    keys, err := k.fetch(ctx)
+
+    k.lock.Lock()
+    defer k.lock.Unlock()
+    k.attempted = time.Now()
+    if k.loading != nil {
+        close(k.loading)
+        k.loading = nil
+    }
+    if err != nil {
+        return err
+    }
+    k.keys = keys
+    k.loadedAt = time.Now()
+    return nil
+}
+
+// fetch reads and parses the key set without touching the loaded keys.
+func (k *keySet) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+    raw, err := k.read(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    document := struct {
+        Keys []jsonWebKey `json:"keys"`
+    }{}
+    if err := json.Unmarshal(raw, &document); err != nil {
+        return nil, fmt.Errorf("invalid JWKS: %w", err)
+    }
+
+    keys := map[string]crypto.PublicKey{}
+    for _, jwk := range document.Keys {
+        if jwk.Use != "" && jwk.Use != "sig" {
+            continue
+        }
+        key, err := jwk.publicKey()
+        if err != nil {
+            log.Printf("Skipping key %q of JWKS %v: %v", jwk.Kid, k.source, err)
+            continue
+        }
+        keys[jwk.Kid] = key
+    }
+    if len(keys) == 0 {
+        return nil, fmt.Errorf("JWKS contains no usable signing key")
+    }
+    return keys, nil
+}
+
+func (k *keySet) read(ctx context.Context) ([]byte, error) {
+    if !strings.HasPrefix(k.source, "https://") && !strings.HasPrefix(k.source, "http://") {
+        return os.ReadFile(k.source)
+    }
+
+    ctx, cancel := context.WithTimeout(ctx, jwksFetchTimeout)
+    defer cancel()
+    request, err := http.NewRequestWithContext(ctx, http.MethodGet, k.source, nil)
+    if err != nil {
+        return nil, err
+    }
+    response, err := http.DefaultClient.Do(request)
+    if err != nil {
+        return nil, err
+    }
+    defer response.Body.Close()
+    if response.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("unexpected status %v", response.Status)
+    }
+    return io.ReadAll(io.LimitReader(response.Body, 1<<20))
+}
+
+func (jwk jsonWebKey) publicKey() (crypto.PublicKey, error) {
+    switch jwk.Kty {
+    case "RSA":
+        n, err := decodeBigInt(jwk.N)
+        if err != nil {
+            return nil, err
+        }
+        e, err := decodeBigInt(jwk.E)
+        if err != nil || !e.IsInt64() {
+            return nil, fmt.Errorf("invalid RSA exponent")
+        }
+        return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+    case "EC":
+        var curve elliptic.Curve
+        switch jwk.Crv {
+        case "P-256":
+            curve = elliptic.P256()
+        case "P-384":
+            curve = elliptic.P384()
+        case "P-521":
+            curve = elliptic.P521()
+        default:
+            return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+        }
+        x, err := decodeBigInt(jwk.X)
+        if err != nil {
+            return nil, err
+        }
+        y, err := decodeBigInt(jwk.Y)
+        if err != nil {
+            return nil, err
+        }
+        return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+    case "OKP":
+        x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+        if jwk.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+            return nil, fmt.Errorf("unsupported or invalid OKP key")
+        }
+        return ed25519.PublicKey(x), nil
+    default:
+        return nil, fmt.Errorf("unsupported key type %q", jwk.Kty)
+    }
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+    raw, err := base64.RawURLEncoding.DecodeString(value)
+    if err != nil || len(raw) == 0 {
+        return nil, fmt.Errorf("invalid key parameter")
+    }
+    return new(big.Int).SetBytes(raw), nil
+}
@@ -0,0 +1,245 @@
+// Package auth authenticates callers of the API by JWT bearer tokens or API
+// keys.
+//
+// Tokens are verified with the public keys of a JSON Web Key Set, read from
+// the file or URL in AMBULANCE_API_AUTH_JWKS. Machine clients may send an API
+// key in the X-API-Key header instead, which is accepted only when a verifier
+// of the keys is set.
+//
+// For local testing, cmd/dev-token generates a development key pair on first
+// use, writes its public part as a JWKS file and signs tokens with its private
+// key. The token command of scripts/run.ps1 runs it.
+package auth
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/golang-jwt/jwt/v5"
+)
+
+// keys of the authenticated caller in the gin context
+const (
+    UserIdKey = "auth_user_id"
+    RoleKey   = "auth_user_role"
+)
+
+var signingMethods = []string{
+    "RS256", "RS384", "RS512",
+    "PS256", "PS384", "PS512",
+    "ES256", "ES384", "ES512",
+    "EdDSA",
+}
+
+type AuthenticatorConfig struct {
+    // JWKS is the path or http(s) URL of the JSON Web Key Set
+    JWKS string
+    // Issuer and Audience are verified when they are set
+    Issuer   string
+    Audience string
+    // UserClaim and RoleClaim name the claims holding the ID of the user and
+    // the role, nested claims are separated by dots, e.g. realm_access.roles
+    UserClaim string
+    RoleClaim string
+    // Roles lists the roles known to the API from the most to the least
+    // privileged. When the role claim is a list, the most privileged known
+    // role wins and the others, e.g. roles of other applications, are ignored
+    Roles  []string
+    Leeway time.Duration
+    // APIKeys verifies API keys, requests with a key are rejected when unset
+    APIKeys APIKeyVerifier
+}
+
+type Authenticator struct {
+    AuthenticatorConfig
+    keys   *keySet
+    parser *jwt.Parser
+}
+
+// NewAuthenticator creates the authenticator, unset fields of the config are
+// read from the environment. The key set is loaded eagerly, a failure is
+// fatal for files, while keys served by an URL are fetched again on the first
+// request when the identity provider is not available yet.
+func NewAuthenticator(config AuthenticatorConfig) (*Authenticator, error) {
+    enviro := func(name string, defaultValue string) string {
+        if value, ok := os.LookupEnv(name); ok {
+            return value
+        }
+        return defaultValue
+    }
+
+    a := &Authenticator{AuthenticatorConfig: config}
+    if a.JWKS == "" {
+        a.JWKS = enviro("AMBULANCE_API_AUTH_JWKS", "")
+    }
+    if a.Issuer == "" {
+        a.Issuer = enviro("AMBULANCE_API_AUTH_ISSUER", "")
+    }
+    if a.Audience == "" {
+        a.Audience = enviro("AMBULANCE_API_AUTH_AUDIENCE", "")
+    }
+    if a.UserClaim == "" {
+        a.UserClaim = enviro("AMBULANCE_API_AUTH_USER_CLAIM", "sub")
+    }
+    if a.RoleClaim == "" {
+        a.RoleClaim = enviro("AMBULANCE_API_AUTH_ROLE_CLAIM", "role")
+    }
+    if len(a.Roles) == 0 {
+        roles := enviro("AMBULANCE_API_AUTH_ROLES", "")
+        if strings.TrimSpace(roles) == "" {
+            roles = "admin,receptionist,doctor,patient"
+        }
+        for _, role := range strings.Split(roles, ",") {
+            if role = strings.TrimSpace(role); role != "" {
+                a.Roles = append(a.Roles, role)
+            }
+        }
+    }
+    if a.Leeway == 0 {
+        a.Leeway = 30 * time.Second
+    }
+    if a.JWKS == "" {
+        return nil, fmt.Errorf("AMBULANCE_API_AUTH_JWKS is not set")
+    }
+
+    options := []jwt.ParserOption{
+        jwt.WithValidMethods(signingMethods),
+        jwt.WithExpirationRequired(),
+        jwt.WithLeeway(a.Leeway),
+    }
+    if a.Issuer != "" {
+        options = append(options, jwt.WithIssuer(a.Issuer))
+    }
+    if a.Audience != "" {
+        options = append(options, jwt.WithAudience(a.Audience))
+    }
+    a.parser = jwt.NewParser(options...)
+
+    log.Printf("JWT authentication with keys from %v", a.JWKS)
+    a.keys = newKeySet(a.JWKS)
+    err := a.keys.load(context.Background())
+    if err != nil {
+        if !strings.Contains(a.JWKS, "://") {
+            return nil, fmt.Errorf("cannot load JWKS %v: %w", a.JWKS, err)
+        }
+        log.Printf("Cannot load JWKS %v yet: %v", a.JWKS, err)
+    }
+    return a, nil
+}
+
+// Middleware rejects requests without a valid bearer token or API key with
+// 401 Unauthorized and places the ID and role of the caller into the gin
+// context.
+// Requests of the public paths, e.g. the OpenAPI document, pass through.
+func (a *Authenticator) Middleware(publicPaths ...string) gin.HandlerFunc {
+    public := map[string]bool{}
+    for _, path := range publicPaths {
+        public[path] = true
+    }
+
+    return func(c *gin.Context) {
+        if public[c.FullPath()] {
+            c.Next()
+            return
+        }
+
+        var userId, role string
+        var err error
+        if key := c.GetHeader(APIKeyHeader); key != "" {
+            if a.APIKeys == nil {
+                err = fmt.Errorf("API keys are not accepted")
+            } else {
+                userId, role, err = a.authenticateAPIKey(c, key)
+            }
+        } else {
+            userId, role, err = a.authenticate(c)
+        }
+        if err != nil {
+            c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+            c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+                "error":   err.Error(),
+                "message": "valid bearer token or API key is required",
+                "status":  "Unauthorized",
+            })
+            return
+        }
+
+        c.Set(UserIdKey, userId)
+        c.Set(RoleKey, role)
+        c.Next()
+    }
+}
+
+// Caller returns the ID and role of the authenticated caller, ok is false
+// when the request was not authenticated.
+func Caller(c *gin.Context) (userId string, role string, ok bool) {
+    userId = c.GetString(UserIdKey)
+    role = c.GetString(RoleKey)
+    return userId, role, userId != ""
+}
+
+func (a *Authenticator) authenticate(c *gin.Context) (string, string, error) {
+    header := c.GetHeader("Authorization")
+    scheme, token, found := strings.Cut(header, " ")
+    if !found || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+        return "", "", fmt.Errorf("missing bearer token")
+    }
+
+    claims := jwt.MapClaims{}
+    _, err := a.parser.ParseWithClaims(strings.TrimSpace(token), claims, func(t *jwt.Token) (interface{}, error) {
+        kid, _ := t.Header["kid"].(string)
+        return a.keys.key(c.Request.Context(), kid)
+    })
+    if err != nil {
+        return "", "", err
+    }
+
+    userId, _ := claimValue(claims, a.UserClaim).(string)
+    if userId == "" {
+        return "", "", fmt.Errorf("token has no %v claim", a.UserClaim)
+    }
+    role := ""
+    switch value := claimValue(claims, a.RoleClaim).(type) {
+    case string:
+        role = value
+    case []interface{}:
+        role = a.mostPrivilegedRole(value)
+    }
+    if role == "" {
+        return "", "", fmt.Errorf("token has no known role in the %v claim", a.RoleClaim)
+    }
+    return userId, role, nil
+}
+
+// mostPrivilegedRole returns the first of the known roles present in the list
+// of roles, or an empty string when none of them is.
+func (a *Authenticator) mostPrivilegedRole(roles []interface{}) string {
+    for _, known := range a.Roles {
+        for _, role := range roles {
+            if role == known {
+                return known
+            }
+        }
+    }
+    return ""
+}
+
+// claimValue returns the value of a claim, nested claims are separated by
+// dots.
+func claimValue(claims jwt.MapClaims, name string) interface{} {
+    var value interface{} = map[string]interface{}(claims)
+    for _, part := range strings.Split(name, ".") {
+        object, ok := value.(map[string]interface{})
+        if !ok {
+            return nil
+        }
+        value = object[part]
+    }
+    return value
+}
@@ -0,0 +1,332 @@
+package auth
+
+import (
+    "context"
+    "crypto/ed25519"
+    "crypto/rand"
+    "crypto/rsa"
+    "encoding/base64"
+    "encoding/json"
+    "math/big"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/golang-jwt/jwt/v5"
+)
+
+const testIssuer = "https://issuer.test"
+
+// testKeys is a local key pair set, the public keys are published in a JWKS
+// file the way an identity provider would publish them.
+type testKeys struct {
+    rsa     *rsa.PrivateKey
+    ed25519 ed25519.PrivateKey
+    jwks    string
+}
+
+func newTestKeys(t *testing.T) *testKeys {
+    t.Helper()
+    rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+    if err != nil {
+        t.Fatal(err)
+    }
+    edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    encode := base64.RawURLEncoding.EncodeToString
+    set := map[string]interface{}{"keys": []map[string]string{
+        {
+            "kty": "RSA",
+            "kid": "rsa",
+            "use": "sig",
+            "n":   encode(rsaKey.N.Bytes()),
+            "e":   encode(big.NewInt(int64(rsaKey.E)).Bytes()),
+        },
+        {
+            "kty": "OKP",
+            "kid": "ed",
+            "crv": "Ed25519",
+            "x":   encode(edPublic),
+        },
+    }}
+    raw, err := json.Marshal(set)
+    if err != nil {
+        t.Fatal(err)
+    }
+    path := filepath.Join(t.TempDir(), "jwks.json")
+    if err := os.WriteFile(path, raw, 0o600); err != nil {
+        t.Fatal(err)
+    }
+    return &testKeys{rsa: rsaKey, ed25519: edKey, jwks: path}
+}
+
+func (k *testKeys) sign(t *testing.T, claims jwt.MapClaims) string {
+    t.Helper()
+    token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+    token.Header["kid"] = "rsa"
+    signed, err := token.SignedString(k.rsa)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return signed
+}
+
+func validClaims(changes map[string]interface{}) jwt.MapClaims {
+    claims := jwt.MapClaims{
+        "sub":  "user1",
+        "role": "patient",
+        "iss":  testIssuer,
+        "exp":  time.Now().Add(time.Hour).Unix(),
+    }
+    for name, value := range changes {
+        if value == nil {
+            delete(claims, name)
+        } else {
+            claims[name] = value
+        }
+    }
+    return claims
+}
+
+// testEngine returns an engine answering the caller as "user/role" and the
+// public path /public without authentication.
+func testEngine(t *testing.T, config AuthenticatorConfig) *gin.Engine {
+    t.Helper()
+    gin.SetMode(gin.TestMode)
+    authenticator, err := NewAuthenticator(config)
+    if err != nil {
+        t.Fatalf("NewAuthenticator: %v", err)
+    }
+    engine := gin.New()
+    engine.Use(authenticator.Middleware("/public"))
+    engine.GET("/caller", func(c *gin.Context) {
+        userId, role, _ := Caller(c)
+        c.String(http.StatusOK, userId+"/"+role)
+    })
+    engine.GET("/public", func(c *gin.Context) {
+        c.String(http.StatusOK, "public")
+    })
+    return engine
+}
+
+func call(engine *gin.Engine, path string, headers map[string]string) *httptest.ResponseRecorder {
+    request := httptest.NewRequest(http.MethodGet, path, nil)
+    for name, value := range headers {
+        request.Header.Set(name, value)
+    }
+    recorder := httptest.NewRecorder()
+    engine.ServeHTTP(recorder, request)
+    return recorder
+}
+
+func bearer(token string) map[string]string {
+    return map[string]string{"Authorization": "Bearer " + token}
+}
+
+func TestMiddlewareBearerTokens(t *testing.T) {
+    keys := newTestKeys(t)
+    engine := testEngine(t, AuthenticatorConfig{
+        JWKS:      keys.jwks,
+        Issuer:    testIssuer,
+        UserClaim: "sub",
+        RoleClaim: "role",
+        Roles:     []string{"admin", "receptionist", "doctor", "patient"},
+    })
+
+    otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+    if err != nil {
+        t.Fatal(err)
+    }
+    signedByOther := jwt.NewWithClaims(jwt.SigningMethodRS256, validClaims(nil))
+    signedByOther.Header["kid"] = "rsa"
+    forged, _ := signedByOther.SignedString(otherKey)
+
+    // the public key must not be usable as an HMAC secret
+    hmac := jwt.NewWithClaims(jwt.SigningMethodHS256, validClaims(nil))
+    hmac.Header["kid"] = "rsa"
+    confused, _ := hmac.SignedString(keys.rsa.PublicKey.N.Bytes())
+
+    ed := jwt.NewWithClaims(jwt.SigningMethodEdDSA, validClaims(map[string]interface{}{"sub": "user5", "role": "doctor"}))
+    ed.Header["kid"] = "ed"
+    edToken, _ := ed.SignedString(keys.ed25519)
+
+    unknownKid := jwt.NewWithClaims(jwt.SigningMethodRS256, validClaims(nil))
+    unknownKid.Header["kid"] = "other"
+    unknownKidToken, _ := unknownKid.SignedString(keys.rsa)
+
+    tests := []struct {
+        name    string
+        headers map[string]string
+        status  int
+        caller  string
+    }{
+        {"valid", bearer(keys.sign(t, validClaims(nil))), http.StatusOK, "user1/patient"},
+        {"scheme is case insensitive", map[string]string{"Authorization": "bearer " + keys.sign(t, validClaims(nil))}, http.StatusOK, "user1/patient"},
+        {"EdDSA", bearer(edToken), http.StatusOK, "user5/doctor"},
+        {"most privileged role of a list", bearer(keys.sign(t, validClaims(map[string]interface{}{
+            "role": []interface{}{"offline_access", "patient", "admin", "doctor"},
+        }))), http.StatusOK, "user1/admin"},
+        {"no known role in a list", bearer(keys.sign(t, validClaims(map[string]interface{}{
+            "role": []interface{}{"offline_access"},
+        }))), http.StatusUnauthorized, ""},
+        {"without token", nil, http.StatusUnauthorized, ""},
+        {"other scheme", map[string]string{"Authorization": "Basic dXNlcjE6cGFzcw=="}, http.StatusUnauthorized, ""},
+        {"expired", bearer(keys.sign(t, validClaims(map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()}))), http.StatusUnauthorized, ""},
+        {"without expiration", bearer(keys.sign(t, validClaims(map[string]interface{}{"exp": nil}))), http.StatusUnauthorized, ""},
+        {"other issuer", bearer(keys.sign(t, validClaims(map[string]interface{}{"iss": "https://other.test"}))), http.StatusUnauthorized, ""},
+        {"without user", bearer(keys.sign(t, validClaims(map[string]interface{}{"sub": nil}))), http.StatusUnauthorized, ""},
+        {"without role", bearer(keys.sign(t, validClaims(map[string]interface{}{"role": nil}))), http.StatusUnauthorized, ""},
+        {"signed by other key", bearer(forged), http.StatusUnauthorized, ""},
+        {"HMAC with the public key", bearer(confused), http.StatusUnauthorized, ""},
+        {"unknown key ID", bearer(unknownKidToken), http.StatusUnauthorized, ""},
+        {"malformed", bearer("not.a.token"), http.StatusUnauthorized, ""},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            response := call(engine, "/caller", test.headers)
+            if response.Code != test.status {
+                t.Fatalf("status = %v, want %v, body %v", response.Code, test.status, response.Body.String())
+            }
+            if test.status == http.StatusOK && response.Body.String() != test.caller {
+                t.Errorf("caller = %v, want %v", response.Body.String(), test.caller)
+            }
+            if test.status == http.StatusUnauthorized && !strings.HasPrefix(response.Header().Get("WWW-Authenticate"), "Bearer") {
+                t.Errorf("WWW-Authenticate = %q, want a Bearer challenge", response.Header().Get("WWW-Authenticate"))
+            }
+        })
+    }
+
+    t.Run("public path", func(t *testing.T) {
+        if response := call(engine, "/public", nil); response.Code != http.StatusOK {
+            t.Errorf("status of public path = %v, want 200", response.Code)
+        }
+    })
+}
+
+func TestMiddlewareNestedRoleClaim(t *testing.T) {
+    keys := newTestKeys(t)
+    t.Setenv("AMBULANCE_API_AUTH_ROLES", "")
+    engine := testEngine(t, AuthenticatorConfig{
+        JWKS:      keys.jwks,
+        UserClaim: "preferred_username",
+        RoleClaim: "realm_access.roles",
+    })
+
+    token := keys.sign(t, jwt.MapClaims{
+        "preferred_username": "recep",
+        "realm_access":       map[string]interface{}{"roles": []interface{}{"uma_authorization", "receptionist"}},
+        "exp":                time.Now().Add(time.Hour).Unix(),
+    })
+    response := call(engine, "/caller", bearer(token))
+    if response.Code != http.StatusOK || response.Body.String() != "recep/receptionist" {
+        t.Errorf("response = %v %v, want recep/receptionist", response.Code, response.Body.String())
+    }
+}
+
+func TestMiddlewareAPIKeys(t *testing.T) {
+    keys := newTestKeys(t)
+    key, _, hash, err := GenerateAPIKey()
+    if err != nil {
+        t.Fatal(err)
+    }
+    verifier := func(ctx context.Context, candidate string) (*APIKey, error) {
+        if candidate != hash {
+            return nil, nil
+        }
+        return &APIKey{Id: "key1", Scopes: []string{"appointments:read"}}, nil
+    }
+
+    gin.SetMode(gin.TestMode)
+    authenticator, err := NewAuthenticator(AuthenticatorConfig{JWKS: keys.jwks, APIKeys: verifier})
+    if err != nil {
+        t.Fatal(err)
+    }
+    engine := gin.New()
+    engine.Use(authenticator.Middleware())
+    engine.GET("/caller", func(c *gin.Context) {
+        userId, role, _ := Caller(c)
+        c.String(http.StatusOK, userId+"/"+role+"/"+strings.Join(Scopes(c), ","))
+    })
+
+    response := call(engine, "/caller", map[string]string{APIKeyHeader: key})
+    if response.Code != http.StatusOK || response.Body.String() != "key1/"+APIKeyRole+"/appointments:read" {
+        t.Errorf("response with valid key = %v %v", response.Code, response.Body.String())
+    }
+    if response := call(engine, "/caller", map[string]string{APIKeyHeader: key + "x"}); response.Code != http.StatusUnauthorized {
+        t.Errorf("status with invalid key = %v, want 401", response.Code)
+    }
+
+    withoutVerifier := testEngine(t, AuthenticatorConfig{JWKS: keys.jwks})
+    if response := call(withoutVerifier, "/caller", map[string]string{APIKeyHeader: key}); response.Code != http.StatusUnauthorized {
+        t.Errorf("status with key but without verifier = %v, want 401", response.Code)
+    }
+}
+
+func TestNewAuthenticatorRequiresKeys(t *testing.T) {
+    t.Setenv("AMBULANCE_API_AUTH_JWKS", "")
+    if _, err := NewAuthenticator(AuthenticatorConfig{}); err == nil {
+        t.Error("NewAuthenticator without JWKS succeeded, want an error")
+    }
+    if _, err := NewAuthenticator(AuthenticatorConfig{JWKS: filepath.Join(t.TempDir(), "missing.json")}); err == nil {
+        t.Error("NewAuthenticator with missing JWKS file succeeded, want an error")
+    }
+}
+
+func TestKnownKeysDuringSlowRefresh(t *testing.T) {
+    keys := newTestKeys(t)
+    jwks, err := os.ReadFile(keys.jwks)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var slow atomic.Bool
+    requested := make(chan struct{})
+    release := make(chan struct{})
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if slow.Load() {
+            requested <- struct{}{}
+            <-release
+        }
+        w.Write(jwks)
+    }))
+    defer server.Close()
+
+    set := newKeySet(server.URL)
+    if err := set.load(t.Context()); err != nil {
+        t.Fatal(err)
+    }
+    slow.Store(true)
+    set.lock.Lock()
+    set.loadedAt = time.Now().Add(-2 * jwksRefreshInterval)
+    set.attempted = time.Time{}
+    set.lock.Unlock()
+    refreshed := make(chan error)
+    go func() {
+        _, err := set.key(context.Background(), "rsa")
+        refreshed <- err
+    }()
+    <-requested
+
+    // the stale set is refreshed by the request above, other requests keep
+    // using the known key instead of waiting for the fetch
+    ctx, cancel := context.WithTimeout(t.Context(), time.Second)
+    defer cancel()
+    if _, err := set.key(ctx, "ed"); err != nil {
+        t.Errorf("key during refresh: %v", err)
+    }
+    if ctx.Err() != nil {
+        t.Errorf("key waited for the refresh")
+    }
+    close(release)
+    if err := <-refreshed; err != nil {
+        t.Errorf("refresh: %v", err)
+    }
+}
@@ -0,0 +1,283 @@
+package db_service
+
+import (
+    "context"
+    "fmt"
+    "reflect"
+    "sort"
+    "time"
+
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Operations of the changes reported by the auditing decorator.
+const (
+    OperationCreate  = "create"
+    OperationUpdate  = "update"
+    OperationDelete  = "delete"
+    OperationRestore = "restore"
+)
+
+var ErrAppendOnly = fmt.Errorf("documents of an append-only collection cannot be changed")
+
+// FieldChange is a changed stored field, nested fields are separated by dots.
+// Before or After is nil when the field was missing.
+type FieldChange struct {
+    Field  string
+    Before interface{}
+    After  interface{}
+}
+
+// Change is a successful write of a document.
+type Change struct {
+    Collection string
+    DocumentId string
+    Operation  string
+    Diff       []FieldChange
+}
+
+// ChangeRecorder receives the changes with the context of the write.
+type ChangeRecorder func(ctx context.Context, change Change)
+
+// RedactedValue replaces the values of redacted fields in the diff.
+const RedactedValue = "[redacted]"
+
+type auditingSvc[DocType interface{}] struct {
+    DbService[DocType]
+    collection string
+    record     ChangeRecorder
+    redacted   map[string]bool
+}
+
+// NewAuditing decorates the service so that every successful write is
+// reported to the recorder together with the changed stored fields. The
+// stored document is read before the write to compute the diff, the version
+// field is left out of it. Values of the redacted fields, e.g. secrets, are
+// replaced by RedactedValue, only the fact they changed is reported. Reads and
+// purges of deleted documents are passed through, the deletion itself was
+// reported.
+func NewAuditing[DocType interface{}](inner DbService[DocType], collection string, record ChangeRecorder, redacted ...string) DbService[DocType] {
+    s := &auditingSvc[DocType]{DbService: inner, collection: collection, record: record, redacted: map[string]bool{}}
+    for _, field := range redacted {
+        s.redacted[field] = true
+    }
+    return s
+}
+
+func (s *auditingSvc[DocType]) CreateDocument(ctx context.Context, id string, document *DocType) error {
+    if err := s.DbService.CreateDocument(ctx, id, document); err != nil {
+        return err
+    }
+    s.report(ctx, id, OperationCreate, nil, document)
+    return nil
+}
+
+func (s *auditingSvc[DocType]) UpdateDocument(ctx context.Context, id string, document *DocType) error {
+    before := s.stored(ctx, id)
+    if err := s.DbService.UpdateDocument(ctx, id, document); err != nil {
+        return err
+    }
+    s.report(ctx, id, OperationUpdate, before, document)
+    return nil
+}
+
+func (s *auditingSvc[DocType]) ReplaceDocumentIfVersion(ctx context.Context, id string, version int64, document *DocType) error {
+    before := s.stored(ctx, id)
+    if err := s.DbService.ReplaceDocumentIfVersion(ctx, id, version, document); err != nil {
+        return err
+    }
+    s.report(ctx, id, OperationUpdate, before, document)
+    return nil
+}
+
+func (s *auditingSvc[DocType]) DeleteDocument(ctx context.Context, id string) error {
+    before := s.stored(ctx, id)
+    if err := s.DbService.DeleteDocument(ctx, id); err != nil {
+        return err
+    }
+    s.report(ctx, id, OperationDelete, before, nil)
+    return nil
+}
+
+func (s *auditingSvc[DocType]) SoftDeleteDocument(ctx context.Context, id string, deletedBy string, version int64) error {
+    before := s.stored(ctx, id)
+    if err := s.DbService.SoftDeleteDocument(ctx, id, deletedBy, version); err != nil {
+        return err
+    }
+    diff := diffDocuments(flatten(before), flatten(s.stored(ctx, id)))
+    // documents which do not map the deletion fields are read without them,
+    // the deleter is reported anyway
+    if !hasField(diff, DeletedByField) {
+        diff = append(diff, FieldChange{Field: DeletedByField, After: deletedBy})
+    }
+    s.reportDiff(ctx, id, OperationDelete, diff)
+    return nil
+}
+
+func (s *auditingSvc[DocType]) RestoreDocument(ctx context.Context, id string) error {
+    before := s.stored(ctx, id)
+    if err := s.DbService.RestoreDocument(ctx, id); err != nil {
+        return err
+    }
+    s.report(ctx, id, OperationRestore, before, s.stored(ctx, id))
+    return nil
+}
+
+// stored returns the stored document including a deleted one, or nil.
+func (s *auditingSvc[DocType]) stored(ctx context.Context, id string) *DocType {
+    documents, err := s.DbService.FindDocuments(ctx, Query{Filter: Eq("id", id), Limit: 1, IncludeDeleted: true})
+    if err != nil || len(documents) == 0 {
+        return nil
+    }
+    return &documents[0]
+}
+
+func (s *auditingSvc[DocType]) report(ctx context.Context, id string, operation string, before *DocType, after *DocType) {
+    s.reportDiff(ctx, id, operation, diffDocuments(flatten(before), flatten(after)))
+}
+
+func (s *auditingSvc[DocType]) reportDiff(ctx context.Context, id string, operation string, diff []FieldChange) {
+    for i := range diff {
+        if !s.redacted[diff[i].Field] {
+            continue
+        }
+        if diff[i].Before != nil {
+            diff[i].Before = RedactedValue
+        }
+        if diff[i].After != nil {
+            diff[i].After = RedactedValue
+        }
+    }
+    s.record(ctx, Change{
+        Collection: s.collection,
+        DocumentId: id,
+        Operation:  operation,
+        Diff:       diff,
+    })
+}
+
+func hasField(diff []FieldChange, field string) bool {
+    for _, change := range diff {
+        if change.Field == field {
+            return true
+        }
+    }
+    return false
+}
+
+// flatten returns the stored fields of the document by their dotted names,
+// nested documents are flattened, arrays are kept as values.
+func flatten[DocType interface{}](document *DocType) map[string]interface{} {
+    fields := map[string]interface{}{}
+    if document == nil {
+        return fields
+    }
+    raw, err := bson.Marshal(document)
+    if err != nil {
+        return fields
+    }
+    stored := bson.D{}
+    if err := bson.Unmarshal(raw, &stored); err != nil {
+        return fields
+    }
+    flattenInto(fields, "", stored)
+    delete(fields, VersionField)
+    return fields
+}
+
+func flattenInto(fields map[string]interface{}, prefix string, document bson.D) {
+    for _, field := range document {
+        if nested, ok := field.Value.(bson.D); ok && len(nested) > 0 {
+            flattenInto(fields, prefix+field.Key+".", nested)
+            continue
+        }
+        fields[prefix+field.Key] = plainValue(field.Value)
+    }
+}
+
+// plainValue converts the decoded BSON value into maps, slices and times,
+// which encode to readable JSON.
+func plainValue(value interface{}) interface{} {
+    switch v := value.(type) {
+    case bson.D:
+        result := map[string]interface{}{}
+        for _, field := range v {
+            result[field.Key] = plainValue(field.Value)
+        }
+        return result
+    case bson.A:
+        result := make([]interface{}, 0, len(v))
+        for _, item := range v {
+            result = append(result, plainValue(item))
+        }
+        return result
+    case primitive.DateTime:
+        return v.Time().UTC()
+    case primitive.Null:
+        return nil
+    case time.Time:
+        return v.UTC()
+    }
+    return value
+}
+
+// diffDocuments returns the fields differing between the flattened documents
+// ordered by their names.
+func diffDocuments(before map[string]interface{}, after map[string]interface{}) []FieldChange {
+    names := map[string]bool{}
+    for name := range before {
+        names[name] = true
+    }
+    for name := range after {
+        names[name] = true
+    }
+
+    diff := []FieldChange{}
+    for name := range names {
+        previous, existed := before[name]
+        current, exists := after[name]
+        if existed == exists && reflect.DeepEqual(previous, current) {
+            continue
+        }
+        diff = append(diff, FieldChange{Field: name, Before: previous, After: current})
+    }
+    sort.Slice(diff, func(i, j int) bool {
+        return diff[i].Field < diff[j].Field
+    })
+    return diff
+}
+
+type appendOnlySvc[DocType interface{}] struct {
+    DbService[DocType]
+}
+
+// NewAppendOnly decorates the service so that stored documents can be neither
+// changed nor deleted, only new documents can be created.
+func NewAppendOnly[DocType interface{}](inner DbService[DocType]) DbService[DocType] {
+    return &appendOnlySvc[DocType]{DbService: inner}
+}
+
+func (s *appendOnlySvc[DocType]) UpdateDocument(ctx context.Context, id string, document *DocType) error {
+    return ErrAppendOnly
+}
+
+func (s *appendOnlySvc[DocType]) ReplaceDocumentIfVersion(ctx context.Context, id string, version int64, document *DocType) error {
+    return ErrAppendOnly
+}
+
+func (s *appendOnlySvc[DocType]) DeleteDocument(ctx context.Context, id string) error {
+    return ErrAppendOnly
+}
+
+func (s *appendOnlySvc[DocType]) SoftDeleteDocument(ctx context.Context, id string, deletedBy string, version int64) error {
+    return ErrAppendOnly
+}
+
+func (s *appendOnlySvc[DocType]) RestoreDocument(ctx context.Context, id string) error {
+    return ErrAppendOnly
+}
+
+func (s *appendOnlySvc[DocType]) PurgeDeletedDocuments(ctx context.Context, deletedBefore time.Time) (int64, error) {
+    return 0, ErrAppendOnly
+}
@@ -0,0 +1,75 @@
+package db_service
+
+import (
+    "context"
+    "testing"
+)
+
+// untrackedDocument does not map the soft delete fields.
+type untrackedDocument struct {
+    Id      string
+    Name    string
+    Secret  string
+    Version int64
+}
+
+func recordedChanges() (*[]Change, ChangeRecorder) {
+    changes := &[]Change{}
+    return changes, func(ctx context.Context, change Change) {
+        *changes = append(*changes, change)
+    }
+}
+
+func fieldChange(change Change, field string) (FieldChange, bool) {
+    for _, fieldChange := range change.Diff {
+        if fieldChange.Field == field {
+            return fieldChange, true
+        }
+    }
+    return FieldChange{}, false
+}
+
+func TestAuditingDiff(t *testing.T) {
+    ctx := context.Background()
+    changes, record := recordedChanges()
+    svc := NewAuditing(NewMemoryService[untrackedDocument](), "things", record, "secret")
+
+    svc.CreateDocument(ctx, "a", &untrackedDocument{Id: "a", Name: "first", Secret: "s1"})
+    svc.ReplaceDocumentIfVersion(ctx, "a", 1, &untrackedDocument{Id: "a", Name: "second", Secret: "s2"})
+    svc.ReplaceDocumentIfVersion(ctx, "a", 1, &untrackedDocument{Id: "a", Name: "stale"})
+    svc.SoftDeleteDocument(ctx, "a", "admin", AnyVersion)
+
+    if len(*changes) != 3 {
+        t.Fatalf("recorded %v changes, want 3 without the failed write: %+v", len(*changes), *changes)
+    }
+    created, updated, deleted := (*changes)[0], (*changes)[1], (*changes)[2]
+
+    if created.Operation != OperationCreate || created.Collection != "things" || created.DocumentId != "a" {
+        t.Errorf("create = %+v", created)
+    }
+    if _, found := fieldChange(created, VersionField); found {
+        t.Errorf("version is reported in the diff %+v", created.Diff)
+    }
+
+    name, _ := fieldChange(updated, "name")
+    if updated.Operation != OperationUpdate || name.Before != "first" || name.After != "second" {
+        t.Errorf("update = %+v", updated)
+    }
+    if _, found := fieldChange(updated, "id"); found {
+        t.Errorf("unchanged field is reported in the diff %+v", updated.Diff)
+    }
+
+    // secrets are reported as changed without their values
+    for _, change := range []Change{created, updated} {
+        secret, found := fieldChange(change, "secret")
+        if !found || secret.After != RedactedValue || (secret.Before != nil && secret.Before != RedactedValue) {
+            t.Errorf("redacted field in %v = %+v", change.Operation, secret)
+        }
+    }
+
+    // the deleter is reported even for documents without the deletion fields
+    deletedBy, found := fieldChange(deleted, DeletedByField)
+    if deleted.Operation != OperationDelete || !found || deletedBy.After != "admin" {
+        t.Errorf("soft delete = %+v", deleted)
+    }
+}
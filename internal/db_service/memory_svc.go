@@ -0,0 +1,213 @@
+package db_service
+
+import (
+    "context"
+    "sort"
+    "sync"
+    "time"
+
+    "go.mongodb.org/mongo-driver/bson"
+)
+
+// memorySvc keeps documents in process memory. Documents are stored in their
+// BSON form so that callers never share memory with the stored copy and the
+// documents look exactly the same as when they are read back from MongoDB.
+type memorySvc[DocType interface{}] struct {
+    lock      sync.RWMutex
+    documents map[string]bson.Raw
+    // ids keeps the insertion order, which mimics the natural order of MongoDB
+    ids []string
+}
+
+func NewMemoryService[DocType interface{}]() DbService[DocType] {
+    return &memorySvc[DocType]{
+        documents: map[string]bson.Raw{},
+    }
+}
+
+func (m *memorySvc[DocType]) Disconnect(ctx context.Context) error {
+    return nil
+}
+
+func (m *memorySvc[DocType]) CreateDocument(ctx context.Context, id string, document *DocType) error {
+    stored, err := versioned(document, 1)
+    if err != nil {
+        return err
+    }
+    raw, err := bson.Marshal(stored)
+    if err != nil {
+        return err
+    }
+
+    m.lock.Lock()
+    defer m.lock.Unlock()
+    if _, exists := m.documents[id]; exists {
+        return ErrConflict
+    }
+    m.documents[id] = raw
+    m.ids = append(m.ids, id)
+    return setVersion(document, 1)
+}
+
+func (m *memorySvc[DocType]) FindDocument(ctx context.Context, id string) (*DocType, error) {
+    m.lock.RLock()
+    raw, exists := m.documents[id]
+    m.lock.RUnlock()
+    if !exists || isDeleted(raw) {
+        return nil, ErrNotFound
+    }
+
+    var document *DocType
+    if err := bson.Unmarshal(raw, &document); err != nil {
+        return nil, err
+    }
+    return document, nil
+}
+
+func (m *memorySvc[DocType]) UpdateDocument(ctx context.Context, id string, document *DocType) error {
+    return m.ReplaceDocumentIfVersion(ctx, id, AnyVersion, document)
+}
+
+func (m *memorySvc[DocType]) ReplaceDocumentIfVersion(ctx context.Context, id string, version int64, document *DocType) error {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+    existing, exists := m.documents[id]
+    if !exists || isDeleted(existing) {
+        return ErrNotFound
+    }
+    current := documentVersion(existing)
+    if version != AnyVersion && version != current {
+        return ErrPreconditionFailed
+    }
+
+    stored, err := versioned(document, current+1)
+    if err != nil {
+        return err
+    }
+    raw, err := bson.Marshal(stored)
+    if err != nil {
+        return err
+    }
+    m.documents[id] = raw
+    return setVersion(document, current+1)
+}
+
+func (m *memorySvc[DocType]) DeleteDocument(ctx context.Context, id string) error {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+    if _, exists := m.documents[id]; !exists {
+        return ErrNotFound
+    }
+    delete(m.documents, id)
+    for i, existing := range m.ids {
+        if existing == id {
+            m.ids = append(m.ids[:i], m.ids[i+1:]...)
+            break
+        }
+    }
+    return nil
+}
+
+func (m *memorySvc[DocType]) FindDocuments(ctx context.Context, query Query) ([]DocType, error) {
+    m.lock.RLock()
+    selected := []bson.Raw{}
+    for _, id := range m.ids {
+        raw := m.documents[id]
+        if !query.IncludeDeleted && isDeleted(raw) {
+            continue
+        }
+        if query.Filter.matches(raw) {
+            selected = append(selected, raw)
+        }
+    }
+    m.lock.RUnlock()
+
+    if len(query.Sort) > 0 {
+        sort.SliceStable(selected, func(i, j int) bool {
+            for _, field := range query.Sort {
+                result := compareValues(lookupField(selected[i], field.Field), lookupField(selected[j], field.Field))
+                if result == 0 {
+                    continue
+                }
+                if field.Descending {
+                    return result > 0
+                }
+                return result < 0
+            }
+            return false
+        })
+    }
+
+    if query.Skip > 0 {
+        if query.Skip >= int64(len(selected)) {
+            selected = nil
+        } else {
+            selected = selected[query.Skip:]
+        }
+    }
+    if query.Limit > 0 && query.Limit < int64(len(selected)) {
+        selected = selected[:query.Limit]
+    }
+
+    documents := make([]DocType, 0, len(selected))
+    for _, raw := range selected {
+        var document DocType
+        if err := bson.Unmarshal(raw, &document); err != nil {
+            return nil, err
+        }
+        documents = append(documents, document)
+    }
+    return documents, nil
+}
+
+func (m *memorySvc[DocType]) SoftDeleteDocument(ctx context.Context, id string, deletedBy string, version int64) error {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+    raw, exists := m.documents[id]
+    if !exists || isDeleted(raw) {
+        return ErrNotFound
+    }
+    if version != AnyVersion && version != documentVersion(raw) {
+        return ErrPreconditionFailed
+    }
+    deletedAt := time.Now().UTC()
+    raw, err := setDeleted(raw, &deletedAt, deletedBy)
+    if err != nil {
+        return err
+    }
+    m.documents[id] = raw
+    return nil
+}
+
+func (m *memorySvc[DocType]) RestoreDocument(ctx context.Context, id string) error {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+    raw, exists := m.documents[id]
+    if !exists || !isDeleted(raw) {
+        return ErrNotFound
+    }
+    raw, err := setDeleted(raw, nil, "")
+    if err != nil {
+        return err
+    }
+    m.documents[id] = raw
+    return nil
+}
+
+func (m *memorySvc[DocType]) PurgeDeletedDocuments(ctx context.Context, deletedBefore time.Time) (int64, error) {
+    m.lock.Lock()
+    defer m.lock.Unlock()
+    purged := int64(0)
+    ids := make([]string, 0, len(m.ids))
+    for _, id := range m.ids {
+        raw := m.documents[id]
+        if isDeleted(raw) && Lt(DeletedAtField, deletedBefore).matches(raw) {
+            delete(m.documents, id)
+            purged++
+            continue
+        }
+        ids = append(ids, id)
+    }
+    m.ids = ids
+    return purged, nil
+}
@@ -0,0 +1,255 @@
+package db_service
+
+import (
+    "context"
+    "testing"
+    "time"
+)
+
+type testDocument struct {
+    Id        string
+    Name      string
+    Note      string `bson:",omitempty"`
+    Priority  *int32 `bson:",omitempty"`
+    Tags      []string
+    Version   int64
+    DeletedAt *time.Time `bson:",omitempty"`
+    DeletedBy string     `bson:",omitempty"`
+}
+
+func priority(value int32) *int32 {
+    return &value
+}
+
+// testDbServiceContract verifies the behavior every DbService backend must
+// provide. The factory returns an empty service for every subtest.
+func testDbServiceContract(t *testing.T, newService func() DbService[testDocument]) {
+    ctx := context.Background()
+
+    t.Run("create and find", func(t *testing.T) {
+        svc := newService()
+        document := testDocument{Id: "a", Name: "first", Tags: []string{"x"}}
+        if err := svc.CreateDocument(ctx, "a", &document); err != nil {
+            t.Fatalf("CreateDocument: %v", err)
+        }
+        if document.Version != 1 {
+            t.Errorf("version after create = %v, want 1", document.Version)
+        }
+        found, err := svc.FindDocument(ctx, "a")
+        if err != nil {
+            t.Fatalf("FindDocument: %v", err)
+        }
+        if found.Name != "first" || found.Version != 1 || len(found.Tags) != 1 {
+            t.Errorf("FindDocument = %+v", found)
+        }
+        if _, err := svc.FindDocument(ctx, "missing"); err != ErrNotFound {
+            t.Errorf("FindDocument of missing document = %v, want ErrNotFound", err)
+        }
+    })
+
+    t.Run("create existing", func(t *testing.T) {
+        svc := newService()
+        svc.CreateDocument(ctx, "a", &testDocument{Id: "a", Name: "first"})
+        if err := svc.CreateDocument(ctx, "a", &testDocument{Id: "a", Name: "second"}); err != ErrConflict {
+            t.Errorf("CreateDocument of existing document = %v, want ErrConflict", err)
+        }
+        found, _ := svc.FindDocument(ctx, "a")
+        if found.Name != "first" {
+            t.Errorf("existing document was changed to %+v", found)
+        }
+    })
+
+    t.Run("stored copies are not shared", func(t *testing.T) {
+        svc := newService()
+        document := testDocument{Id: "a", Tags: []string{"x"}}
+        svc.CreateDocument(ctx, "a", &document)
+        document.Tags[0] = "changed"
+        found, _ := svc.FindDocument(ctx, "a")
+        found.Tags[0] = "changed too"
+        again, _ := svc.FindDocument(ctx, "a")
+        if again.Tags[0] != "x" {
+            t.Errorf("stored document was changed through a caller copy: %+v", again)
+        }
+    })
+
+    t.Run("replace if version", func(t *testing.T) {
+        svc := newService()
+        svc.CreateDocument(ctx, "a", &testDocument{Id: "a", Name: "first"})
+
+        changed := testDocument{Id: "a", Name: "second"}
+        if err := svc.ReplaceDocumentIfVersion(ctx, "a", 1, &changed); err != nil {
+            t.Fatalf("ReplaceDocumentIfVersion: %v", err)
+        }
+        if changed.Version != 2 {
+            t.Errorf("version after replace = %v, want 2", changed.Version)
+        }
+        stale := testDocument{Id: "a", Name: "stale"}
+        if err := svc.ReplaceDocumentIfVersion(ctx, "a", 1, &stale); err != ErrPreconditionFailed {
+            t.Errorf("ReplaceDocumentIfVersion with stale version = %v, want ErrPreconditionFailed", err)
+        }
+        found, _ := svc.FindDocument(ctx, "a")
+        if found.Name != "second" || found.Version != 2 {
+            t.Errorf("FindDocument = %+v, want second in version 2", found)
+        }
+        if err := svc.ReplaceDocumentIfVersion(ctx, "missing", 1, &stale); err != ErrNotFound {
+            t.Errorf("ReplaceDocumentIfVersion of missing document = %v, want ErrNotFound", err)
+        }
+    })
+
+    t.Run("replace any version", func(t *testing.T) {
+        svc := newService()
+        svc.CreateDocument(ctx, "a", &testDocument{Id: "a", Name: "first"})
+        changed := testDocument{Id: "a", Name: "second"}
+        if err := svc.ReplaceDocumentIfVersion(ctx, "a", AnyVersion, &changed); err != nil {
+            t.Fatalf("ReplaceDocumentIfVersion with AnyVersion: %v", err)
+        }
+        if changed.Version != 2 {
+            t.Errorf("version after replace = %v, want 2", changed.Version)
+        }
+    })
+
+    t.Run("update replaces whole document", func(t *testing.T) {
+        svc := newService()
+        svc.CreateDocument(ctx, "a", &testDocument{Id: "a", Name: "first", Note: "removed on update"})
+        if err := svc.UpdateDocument(ctx, "a", &testDocument{Id: "a", Name: "second"}); err != nil {
+            t.Fatalf("UpdateDocument: %v", err)
+        }
+        found, _ := svc.FindDocument(ctx, "a")
+        if found.Name != "second" || found.Note != "" || found.Version != 2 {
+            t.Errorf("FindDocument = %+v, want second without note in version 2", found)
+        }
+    })
+
+    t.Run("delete", func(t *testing.T) {
+        svc := newService()
+        svc.CreateDocument(ctx, "a", &testDocument{Id: "a"})
+        if err := svc.DeleteDocument(ctx, "a"); err != nil {
+            t.Fatalf("DeleteDocument: %v", err)
+        }
+        if _, err := svc.FindDocument(ctx, "a"); err != ErrNotFound {
+            t.Errorf("FindDocument of deleted document = %v, want ErrNotFound", err)
+        }
+        if err := svc.DeleteDocument(ctx, "a"); err != ErrNotFound {
+            t.Errorf("DeleteDocument of missing document = %v, want ErrNotFound", err)
+        }
+        // the id can be used again
+        if err := svc.CreateDocument(ctx, "a", &testDocument{Id: "a"}); err != nil {
+            t.Errorf("CreateDocument after delete: %v", err)
+        }
+    })
+
+    t.Run("soft delete and restore", func(t *testing.T) {
+        svc := newService()
+        svc.CreateDocument(ctx, "a", &testDocument{Id: "a", Name: "first"})
+        svc.CreateDocument(ctx, "b", &testDocument{Id: "b", Name: "second"})
+
+        if err := svc.SoftDeleteDocument(ctx, "a", "admin", 2); err != ErrPreconditionFailed {
+            t.Errorf("SoftDeleteDocument with wrong version = %v, want ErrPreconditionFailed", err)
+        }
+        if err := svc.SoftDeleteDocument(ctx, "a", "admin", 1); err != nil {
+            t.Fatalf("SoftDeleteDocument: %v", err)
+        }
+        if _, err := svc.FindDocument(ctx, "a"); err != ErrNotFound {
+            t.Errorf("FindDocument of soft deleted document = %v, want ErrNotFound", err)
+        }
+        if err := svc.ReplaceDocumentIfVersion(ctx, "a", AnyVersion, &testDocument{Id: "a"}); err != ErrNotFound {
+            t.Errorf("ReplaceDocumentIfVersion of soft deleted document = %v, want ErrNotFound", err)
+        }
+        if err := svc.SoftDeleteDocument(ctx, "a", "admin", AnyVersion); err != ErrNotFound {
+            t.Errorf("SoftDeleteDocument of soft deleted document = %v, want ErrNotFound", err)
+        }
+
+        visible, _ := svc.FindDocuments(ctx, Query{})
+        if len(visible) != 1 || visible[0].Id != "b" {
+            t.Errorf("FindDocuments = %+v, want only b", visible)
+        }
+        all, _ := svc.FindDocuments(ctx, Query{IncludeDeleted: true, Filter: Eq("id", "a")})
+        if len(all) != 1 || all[0].DeletedAt == nil || all[0].DeletedBy != "admin" || all[0].Version != 2 {
+            t.Errorf("FindDocuments including deleted = %+v, want a deleted by admin in version 2", all)
+        }
+
+        if err := svc.RestoreDocument(ctx, "b"); err != ErrNotFound {
+            t.Errorf("RestoreDocument of not deleted document = %v, want ErrNotFound", err)
+        }
+        if err := svc.RestoreDocument(ctx, "a"); err != nil {
+            t.Fatalf("RestoreDocument: %v", err)
+        }
+        found, err := svc.FindDocument(ctx, "a")
+        if err != nil || found.DeletedAt != nil || found.DeletedBy != "" || found.Version != 3 {
+            t.Errorf("FindDocument after restore = %+v, %v", found, err)
+        }
+    })
+
+    t.Run("purge deleted", func(t *testing.T) {
+        svc := newService()
+        svc.CreateDocument(ctx, "a", &testDocument{Id: "a"})
+        svc.CreateDocument(ctx, "b", &testDocument{Id: "b"})
+        svc.SoftDeleteDocument(ctx, "a", "admin", AnyVersion)
+
+        purged, err := svc.PurgeDeletedDocuments(ctx, time.Now().Add(-time.Hour))
+        if err != nil || purged != 0 {
+            t.Errorf("PurgeDeletedDocuments before the deletion = %v, %v, want 0", purged, err)
+        }
+        purged, err = svc.PurgeDeletedDocuments(ctx, time.Now().Add(time.Hour))
+        if err != nil || purged != 1 {
+            t.Errorf("PurgeDeletedDocuments after the deletion = %v, %v, want 1", purged, err)
+        }
+        all, _ := svc.FindDocuments(ctx, Query{IncludeDeleted: true})
+        if len(all) != 1 || all[0].Id != "b" {
+            t.Errorf("FindDocuments after purge = %+v, want only b", all)
+        }
+    })
+
+    t.Run("find documents", func(t *testing.T) {
+        svc := newService()
+        for _, document := range []testDocument{
+            {Id: "a", Name: "x", Priority: priority(2)},
+            {Id: "b", Name: "y", Priority: priority(1)},
+            {Id: "c", Name: "x"},
+            {Id: "d", Name: "z", Priority: priority(3)},
+        } {
+            svc.CreateDocument(ctx, document.Id, &document)
+        }
+
+        documents, err := svc.FindDocuments(ctx, Query{
+            Filter: Eq("name", "x"),
+            Sort:   []SortField{{Field: "id", Descending: true}},
+        })
+        if err != nil {
+            t.Fatalf("FindDocuments: %v", err)
+        }
+        if ids := documentIds(documents); ids != "c,a" {
+            t.Errorf("filtered and sorted ids = %v, want c,a", ids)
+        }
+
+        // missing values sort before all others
+        documents, _ = svc.FindDocuments(ctx, Query{Sort: []SortField{{Field: "priority"}}})
+        if ids := documentIds(documents); ids != "c,b,a,d" {
+            t.Errorf("ids sorted by priority = %v, want c,b,a,d", ids)
+        }
+
+        documents, _ = svc.FindDocuments(ctx, Query{Sort: []SortField{{Field: "id"}}, Skip: 1, Limit: 2})
+        if ids := documentIds(documents); ids != "b,c" {
+            t.Errorf("ids of skipped and limited query = %v, want b,c", ids)
+        }
+        documents, _ = svc.FindDocuments(ctx, Query{Skip: 10})
+        if len(documents) != 0 {
+            t.Errorf("FindDocuments skipping all = %+v, want none", documents)
+        }
+    })
+}
+
+func documentIds(documents []testDocument) string {
+    ids := ""
+    for i, document := range documents {
+        if i > 0 {
+            ids += ","
+        }
+        ids += document.Id
+    }
+    return ids
+}
+
+func TestMemoryServiceContract(t *testing.T) {
+    testDbServiceContract(t, NewMemoryService[testDocument])
+}
@@ -18,15 +18,36 @@ import (
 type DbService[DocType interface{}] interface {
     CreateDocument(ctx context.Context, id string, document *DocType) error
     FindDocument(ctx context.Context, id string) (*DocType, error)
+    // UpdateDocument replaces the whole stored document, fields missing in the
+    // document are removed.
     UpdateDocument(ctx context.Context, id string, document *DocType) error
+    // ReplaceDocumentIfVersion replaces the document only if its stored version
+    // matches, otherwise it returns ErrPreconditionFailed. AnyVersion replaces
+    // the document whatever its version is.
+    ReplaceDocumentIfVersion(ctx context.Context, id string, version int64, document *DocType) error
     DeleteDocument(ctx context.Context, id string) error
     Disconnect(ctx context.Context) error
-    FindAllDocuments(ctx context.Context) (*mongo.Cursor, error)
+    FindDocuments(ctx context.Context, query Query) ([]DocType, error)
+    // SoftDeleteDocument marks the document as deleted, deleted documents are
+    // hidden from reads and updates unless Query.IncludeDeleted is set. The
+    // document is deleted only if its version matches, unless it is AnyVersion.
+    SoftDeleteDocument(ctx context.Context, id string, deletedBy string, version int64) error
+    RestoreDocument(ctx context.Context, id string) error
+    // PurgeDeletedDocuments permanently removes documents deleted before
+    // the given time and returns their count.
+    PurgeDeletedDocuments(ctx context.Context, deletedBefore time.Time) (int64, error)
 }
 
 var ErrNotFound = fmt.Errorf("document not found")
 var ErrConflict = fmt.Errorf("conflict: document already exists")
 
+// MongoIndex describes an index ensured on the collection when the service
+// connects to the database. Keys are stored field names in ascending order.
+type MongoIndex struct {
+    Keys   []string
+    Unique bool
+}
+
 type MongoServiceConfig struct {
     ServerHost string
     ServerPort int
@@ -35,6 +56,7 @@ type MongoServiceConfig struct {
     DbName     string
     Collection string
     Timeout    time.Duration
+    Indexes    []MongoIndex
 }
 
 type mongoSvc[DocType interface{}] struct {
@@ -102,6 +124,12 @@ func NewMongoService[DocType interface{}](config MongoServiceConfig) DbService[D
         svc.DbName,
         svc.Collection,
     )
+
+    // connect eagerly to ensure the indexes at startup, the connection is
+    // retried on the first request when the database is not available yet
+    if _, err := svc.connect(context.Background()); err != nil {
+        log.Printf("Cannot connect to collection %v yet: %v", svc.Collection, err)
+    }
     return svc
 }
 
@@ -132,12 +160,42 @@ func (m *mongoSvc[DocType]) connect(ctx context.Context) (*mongo.Client, error)
 
     if client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri).SetConnectTimeout(10*time.Second)); err != nil {
         return nil, err
+    } else if err := m.ensureIndexes(ctx, client); err != nil {
+        // the unique index on id guards against duplicate documents, do not
+        // operate without it
+        client.Disconnect(ctx)
+        return nil, err
     } else {
         m.client.Store(client)
         return client, nil
     }
 }
 
+// ensureIndexes creates the unique index on id, which makes creation of
+// documents atomic, and the indexes from the configuration.
+func (m *mongoSvc[DocType]) ensureIndexes(ctx context.Context, client *mongo.Client) error {
+    models := []mongo.IndexModel{{
+        Keys:    bson.D{{Key: "id", Value: 1}},
+        Options: options.Index().SetUnique(true),
+    }}
+    for _, index := range m.Indexes {
+        keys := bson.D{}
+        for _, key := range index.Keys {
+            keys = append(keys, bson.E{Key: key, Value: 1})
+        }
+        models = append(models, mongo.IndexModel{
+            Keys:    keys,
+            Options: options.Index().SetUnique(index.Unique),
+        })
+    }
+    collection := client.Database(m.DbName).Collection(m.Collection)
+    if _, err := collection.Indexes().CreateMany(ctx, models); err != nil {
+        log.Printf("Failed to create indexes on collection %v: %v", m.Collection, err)
+        return err
+    }
+    return nil
+}
+
 func (m *mongoSvc[DocType]) Disconnect(ctx context.Context) error {
     client := m.client.Load()
 
@@ -165,18 +223,18 @@ func (m *mongoSvc[DocType]) CreateDocument(ctx context.Context, id string, docum
     }
     db := client.Database(m.DbName)
     collection := db.Collection(m.Collection)
-    result := collection.FindOne(ctx, bson.D{{Key: "id", Value: id}})
-    switch result.Err() {
-    case nil: // no error means there is conflicting document
-        return ErrConflict
-    case mongo.ErrNoDocuments:
-        // do nothing, this is expected
-    default: // other errors - return them
-        return result.Err()
+    stored, err := versioned(document, 1)
+    if err != nil {
+        return err
     }
-
-    _, err = collection.InsertOne(ctx, document)
-    return err
+    // the unique index on id rejects conflicting documents atomically
+    if _, err = collection.InsertOne(ctx, stored); err != nil {
+        if mongo.IsDuplicateKeyError(err) {
+            return ErrConflict
+        }
+        return err
+    }
+    return setVersion(document, 1)
 }
 
 func (m *mongoSvc[DocType]) FindDocument(ctx context.Context, id string) (*DocType, error) {
@@ -188,7 +246,7 @@ func (m *mongoSvc[DocType]) FindDocument(ctx context.Context, id string) (*DocTy
     }
     db := client.Database(m.DbName)
     collection := db.Collection(m.Collection)
-    result := collection.FindOne(ctx, bson.D{{Key: "id", Value: id}})
+    result := collection.FindOne(ctx, bson.D{{Key: "id", Value: id}, {Key: DeletedAtField, Value: nil}})
     switch result.Err() {
     case nil:
     case mongo.ErrNoDocuments:
@@ -204,6 +262,10 @@ func (m *mongoSvc[DocType]) FindDocument(ctx context.Context, id string) (*DocTy
 }
 
 func (m *mongoSvc[DocType]) UpdateDocument(ctx context.Context, id string, document *DocType) error {
+    return m.ReplaceDocumentIfVersion(ctx, id, AnyVersion, document)
+}
+
+func (m *mongoSvc[DocType]) ReplaceDocumentIfVersion(ctx context.Context, id string, version int64, document *DocType) error {
     ctx, contextCancel := context.WithTimeout(ctx, m.Timeout)
     defer contextCancel()
     client, err := m.connect(ctx)
@@ -212,16 +274,63 @@ func (m *mongoSvc[DocType]) UpdateDocument(ctx context.Context, id string, docum
     }
     db := client.Database(m.DbName)
     collection := db.Collection(m.Collection)
-    result := collection.FindOne(ctx, bson.D{{Key: "id", Value: id}})
+    for {
+        expected := version
+        if version == AnyVersion {
+            // any version is replaced, the stored one is read only to
+            // increment it atomically with the replacement
+            if expected, err = storedVersion(ctx, collection, id); err != nil {
+                return err
+            }
+        }
+        replacement, err := versioned(document, expected+1)
+        if err != nil {
+            return err
+        }
+        filter := And(Eq("id", id), Eq(DeletedAtField, nil), versionFilter(expected))
+        result, err := collection.ReplaceOne(ctx, filter.toBson(), replacement)
+        if mongo.IsDuplicateKeyError(err) { // id changed to existing one
+            return ErrConflict
+        }
+        if err != nil {
+            return err
+        }
+        if result.MatchedCount > 0 {
+            return setVersion(document, expected+1)
+        }
+
+        count, err := collection.CountDocuments(ctx, bson.D{{Key: "id", Value: id}, {Key: DeletedAtField, Value: nil}})
+        switch {
+        case err != nil:
+            return err
+        case count == 0:
+            return ErrNotFound
+        case version != AnyVersion:
+            return ErrPreconditionFailed
+        }
+        // changed concurrently since its version was read, read it again
+    }
+}
+
+// storedVersion returns the version of the document which is not deleted.
+func storedVersion(ctx context.Context, collection *mongo.Collection, id string) (int64, error) {
+    result := collection.FindOne(
+        ctx,
+        bson.D{{Key: "id", Value: id}, {Key: DeletedAtField, Value: nil}},
+        options.FindOne().SetProjection(bson.D{{Key: VersionField, Value: 1}}),
+    )
     switch result.Err() {
     case nil:
     case mongo.ErrNoDocuments:
-        return ErrNotFound
-    default: // other errors - return them
-        return result.Err()
+        return 0, ErrNotFound
+    default:
+        return 0, result.Err()
     }
-    _, err = collection.ReplaceOne(ctx, bson.D{{Key: "id", Value: id}}, document)
-    return err
+    raw, err := result.Raw()
+    if err != nil {
+        return 0, err
+    }
+    return documentVersion(raw), nil
 }
 
 func (m *mongoSvc[DocType]) DeleteDocument(ctx context.Context, id string) error {
@@ -233,19 +342,17 @@ func (m *mongoSvc[DocType]) DeleteDocument(ctx context.Context, id string) error
     }
     db := client.Database(m.DbName)
     collection := db.Collection(m.Collection)
-    result := collection.FindOne(ctx, bson.D{{Key: "id", Value: id}})
-    switch result.Err() {
-    case nil:
-    case mongo.ErrNoDocuments:
+    result, err := collection.DeleteOne(ctx, bson.D{{Key: "id", Value: id}})
+    if err != nil {
+        return err
+    }
+    if result.DeletedCount == 0 {
         return ErrNotFound
-    default: // other errors - return them
-        return result.Err()
     }
-    _, err = collection.DeleteOne(ctx, bson.D{{Key: "id", Value: id}})
-    return err
+    return nil
 }
 
-func (m *mongoSvc[DocType]) FindAllDocuments(ctx context.Context) (*mongo.Cursor, error) {
+func (m *mongoSvc[DocType]) FindDocuments(ctx context.Context, query Query) ([]DocType, error) {
     ctx, contextCancel := context.WithTimeout(ctx, m.Timeout)
     defer contextCancel()
     client, err := m.connect(ctx)
@@ -254,5 +361,118 @@ func (m *mongoSvc[DocType]) FindAllDocuments(ctx context.Context) (*mongo.Cursor
     }
     db := client.Database(m.DbName)
     collection := db.Collection(m.Collection)
-    return collection.Find(ctx, bson.D{})
-}
\ No newline at end of file
+
+    findOptions := options.Find()
+    if len(query.Sort) > 0 {
+        findOptions.SetSort(sortToBson(query.Sort))
+    }
+    if query.Skip > 0 {
+        findOptions.SetSkip(query.Skip)
+    }
+    if query.Limit > 0 {
+        findOptions.SetLimit(query.Limit)
+    }
+
+    filter := query.Filter
+    if !query.IncludeDeleted {
+        filter = And(filter, Eq(DeletedAtField, nil))
+    }
+
+    cursor, err := collection.Find(ctx, filter.toBson(), findOptions)
+    if err != nil {
+        return nil, err
+    }
+    defer cursor.Close(ctx)
+
+    documents := []DocType{}
+    if err := cursor.All(ctx, &documents); err != nil {
+        return nil, err
+    }
+    return documents, nil
+}
+
+func (m *mongoSvc[DocType]) SoftDeleteDocument(ctx context.Context, id string, deletedBy string, version int64) error {
+    ctx, contextCancel := context.WithTimeout(ctx, m.Timeout)
+    defer contextCancel()
+    client, err := m.connect(ctx)
+    if err != nil {
+        return err
+    }
+    db := client.Database(m.DbName)
+    collection := db.Collection(m.Collection)
+    filter := And(Eq("id", id), Eq(DeletedAtField, nil))
+    if version != AnyVersion {
+        filter = And(filter, versionFilter(version))
+    }
+    result, err := collection.UpdateOne(
+        ctx,
+        filter.toBson(),
+        bson.D{
+            {Key: "$set", Value: bson.D{
+                {Key: DeletedAtField, Value: time.Now().UTC()},
+                {Key: DeletedByField, Value: deletedBy},
+            }},
+            {Key: "$inc", Value: bson.D{{Key: VersionField, Value: int64(1)}}},
+        },
+    )
+    if err != nil {
+        return err
+    }
+    if result.MatchedCount == 0 {
+        count, err := collection.CountDocuments(ctx, bson.D{{Key: "id", Value: id}, {Key: DeletedAtField, Value: nil}})
+        switch {
+        case err != nil:
+            return err
+        case count == 0 || version == AnyVersion:
+            return ErrNotFound
+        default:
+            return ErrPreconditionFailed
+        }
+    }
+    return nil
+}
+
+func (m *mongoSvc[DocType]) RestoreDocument(ctx context.Context, id string) error {
+    ctx, contextCancel := context.WithTimeout(ctx, m.Timeout)
+    defer contextCancel()
+    client, err := m.connect(ctx)
+    if err != nil {
+        return err
+    }
+    db := client.Database(m.DbName)
+    collection := db.Collection(m.Collection)
+    result, err := collection.UpdateOne(
+        ctx,
+        bson.D{{Key: "id", Value: id}, {Key: DeletedAtField, Value: bson.D{{Key: "$ne", Value: nil}}}},
+        bson.D{
+            {Key: "$unset", Value: bson.D{
+                {Key: DeletedAtField, Value: ""},
+                {Key: DeletedByField, Value: ""},
+            }},
+            {Key: "$inc", Value: bson.D{{Key: VersionField, Value: int64(1)}}},
+        },
+    )
+    if err != nil {
+        return err
+    }
+    if result.MatchedCount == 0 {
+        return ErrNotFound
+    }
+    return nil
+}
+
+func (m *mongoSvc[DocType]) PurgeDeletedDocuments(ctx context.Context, deletedBefore time.Time) (int64, error) {
+    ctx, contextCancel := context.WithTimeout(ctx, m.Timeout)
+    defer contextCancel()
+    client, err := m.connect(ctx)
+    if err != nil {
+        return 0, err
+    }
+    db := client.Database(m.DbName)
+    collection := db.Collection(m.Collection)
+    result, err := collection.DeleteMany(ctx, bson.D{{Key: DeletedAtField, Value: bson.D{{Key: "$lt", Value: deletedBefore}}}})
+    if err != nil {
+        return 0, err
+    }
+    return result.DeletedCount, nil
+}
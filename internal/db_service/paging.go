@@ -0,0 +1,144 @@
+package db_service
+
+import (
+    "context"
+    "encoding/base64"
+    "fmt"
+    "strings"
+
+    "go.mongodb.org/mongo-driver/bson"
+)
+
+var ErrInvalidPageToken = fmt.Errorf("invalid page token")
+
+// pageToken is the keyset cursor: sort order of the query and the values of
+// the sort fields of the last document on the previous page.
+type pageToken struct {
+    Sort   string        `bson:"s"`
+    Values []interface{} `bson:"v"`
+}
+
+// FindPage returns one page of documents selected by the query, at most
+// query.Limit documents, and the token of the next page, which is empty when
+// there are no more documents. Results are always ordered by "id" as the last
+// sort field, so that the order of documents is stable across pages.
+// Skip of the query is ignored, positions are carried by the page token.
+func FindPage[DocType interface{}](ctx context.Context, svc DbService[DocType], query Query, token string) ([]DocType, string, error) {
+    if !hasSortField(query.Sort, "id") {
+        query.Sort = append(append([]SortField{}, query.Sort...), SortField{Field: "id"})
+    }
+    sortKey := sortKey(query.Sort)
+
+    if token != "" {
+        decoded, err := decodePageToken(token)
+        if err != nil || decoded.Sort != sortKey || len(decoded.Values) != len(query.Sort) {
+            return nil, "", ErrInvalidPageToken
+        }
+        query.Filter = And(query.Filter, afterFilter(query.Sort, decoded.Values))
+    }
+
+    limit := query.Limit
+    query.Skip = 0
+    if limit > 0 {
+        query.Limit = limit + 1
+    }
+
+    documents, err := svc.FindDocuments(ctx, query)
+    if err != nil {
+        return nil, "", err
+    }
+    if limit <= 0 || int64(len(documents)) <= limit {
+        return documents, "", nil
+    }
+
+    documents = documents[:limit]
+    raw, err := bson.Marshal(&documents[limit-1])
+    if err != nil {
+        return nil, "", err
+    }
+    next := pageToken{Sort: sortKey}
+    for _, field := range query.Sort {
+        next.Values = append(next.Values, lookupField(raw, field.Field))
+    }
+    nextToken, err := encodePageToken(next)
+    if err != nil {
+        return nil, "", err
+    }
+    return documents, nextToken, nil
+}
+
+// afterFilter selects documents placed after the given sort field values:
+// (f1 > v1) or (f1 == v1 and f2 > v2) or ...
+// Missing fields are carried as nil in the page token. They sort before all
+// other values, as in MongoDB, but range operators never match them, so they
+// are compared explicitly.
+func afterFilter(sort []SortField, values []interface{}) Filter {
+    alternatives := []Filter{}
+    for i, field := range sort {
+        after, exists := afterValue(field, values[i])
+        if !exists {
+            continue
+        }
+        conditions := []Filter{}
+        for j := 0; j < i; j++ {
+            conditions = append(conditions, Eq(sort[j].Field, values[j]))
+        }
+        conditions = append(conditions, after)
+        alternatives = append(alternatives, And(conditions...))
+    }
+    return Or(alternatives...)
+}
+
+// afterValue selects values of the field placed after the given value, it
+// reports false when there are none, i.e. a missing value sorted descending.
+func afterValue(field SortField, value interface{}) (Filter, bool) {
+    switch {
+    case value == nil && field.Descending:
+        return Filter{}, false
+    case value == nil:
+        return Ne(field.Field, nil), true
+    case field.Descending:
+        return Or(Lt(field.Field, value), Eq(field.Field, nil)), true
+    default:
+        return Gt(field.Field, value), true
+    }
+}
+
+func hasSortField(sort []SortField, name string) bool {
+    for _, field := range sort {
+        if field.Field == name {
+            return true
+        }
+    }
+    return false
+}
+
+func sortKey(sort []SortField) string {
+    fields := make([]string, 0, len(sort))
+    for _, field := range sort {
+        if field.Descending {
+            fields = append(fields, "-"+field.Field)
+        } else {
+            fields = append(fields, field.Field)
+        }
+    }
+    return strings.Join(fields, ",")
+}
+
+func encodePageToken(token pageToken) (string, error) {
+    raw, err := bson.Marshal(token)
+    if err != nil {
+        return "", err
+    }
+    return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func decodePageToken(token string) (pageToken, error) {
+    decoded := pageToken{}
+    raw, err := base64.RawURLEncoding.DecodeString(token)
+    if err != nil {
+        return decoded, err
+    }
+    err = bson.Unmarshal(raw, &decoded)
+    return decoded, err
+}
@@ -0,0 +1,102 @@
+package db_service
+
+import (
+    "context"
+    "strings"
+    "testing"
+)
+
+// allPages reads all pages of the query and returns the ids of the documents
+// in the order they were returned.
+func allPages(t *testing.T, svc DbService[testDocument], query Query) string {
+    t.Helper()
+    ids := []string{}
+    token := ""
+    for pages := 0; ; pages++ {
+        if pages > 20 {
+            t.Fatalf("paging does not end, ids so far %v", ids)
+        }
+        documents, next, err := FindPage(context.Background(), svc, query, token)
+        if err != nil {
+            t.Fatalf("FindPage: %v", err)
+        }
+        if int64(len(documents)) > query.Limit {
+            t.Fatalf("page of %v documents exceeds limit %v", len(documents), query.Limit)
+        }
+        for _, document := range documents {
+            ids = append(ids, document.Id)
+        }
+        if next == "" {
+            return strings.Join(ids, ",")
+        }
+        token = next
+    }
+}
+
+func pagingTestService(t *testing.T) DbService[testDocument] {
+    svc := NewMemoryService[testDocument]()
+    // c, f and g have no priority, like documents stored before the field
+    // was introduced
+    for _, document := range []testDocument{
+        {Id: "a", Name: "x", Priority: priority(2)},
+        {Id: "b", Name: "y", Priority: priority(1)},
+        {Id: "c", Name: "x"},
+        {Id: "d", Name: "z", Priority: priority(3)},
+        {Id: "e", Name: "x", Priority: priority(2)},
+        {Id: "f", Name: "y"},
+        {Id: "g", Name: "x"},
+    } {
+        if err := svc.CreateDocument(context.Background(), document.Id, &document); err != nil {
+            t.Fatal(err)
+        }
+    }
+    return svc
+}
+
+func TestFindPage(t *testing.T) {
+    svc := pagingTestService(t)
+    tests := []struct {
+        name  string
+        query Query
+        want  string
+    }{
+        {"by id", Query{Limit: 3}, "a,b,c,d,e,f,g"},
+        {"by id descending", Query{Limit: 2, Sort: []SortField{{Field: "id", Descending: true}}}, "g,f,e,d,c,b,a"},
+        {"single page", Query{Limit: 10}, "a,b,c,d,e,f,g"},
+        {"filtered", Query{Limit: 1, Filter: Eq("name", "x")}, "a,c,e,g"},
+        {"by name", Query{Limit: 2, Sort: []SortField{{Field: "name"}}}, "a,c,e,g,b,f,d"},
+        {"missing sort values", Query{Limit: 2, Sort: []SortField{{Field: "priority"}}}, "c,f,g,b,a,e,d"},
+        {"missing sort values on page boundary", Query{Limit: 3, Sort: []SortField{{Field: "priority"}}}, "c,f,g,b,a,e,d"},
+        {"missing sort values descending", Query{Limit: 2, Sort: []SortField{{Field: "priority", Descending: true}}}, "d,a,e,b,c,f,g"},
+        {
+            "missing sort values descending by two fields",
+            Query{Limit: 1, Sort: []SortField{{Field: "priority", Descending: true}, {Field: "name", Descending: true}}},
+            "d,a,e,b,f,c,g",
+        },
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            if got := allPages(t, svc, test.query); got != test.want {
+                t.Errorf("ids = %v, want %v", got, test.want)
+            }
+        })
+    }
+}
+
+func TestFindPageInvalidToken(t *testing.T) {
+    svc := pagingTestService(t)
+    ctx := context.Background()
+
+    if _, _, err := FindPage(ctx, svc, Query{Limit: 2}, "not a token"); err != ErrInvalidPageToken {
+        t.Errorf("FindPage with garbage token = %v, want ErrInvalidPageToken", err)
+    }
+
+    // tokens are bound to the sort order they were issued for
+    _, next, err := FindPage(ctx, svc, Query{Limit: 2, Sort: []SortField{{Field: "name"}}}, "")
+    if err != nil || next == "" {
+        t.Fatalf("FindPage = %v, %q", err, next)
+    }
+    if _, _, err := FindPage(ctx, svc, Query{Limit: 2, Sort: []SortField{{Field: "priority"}}}, next); err != ErrInvalidPageToken {
+        t.Errorf("FindPage with token of other sort = %v, want ErrInvalidPageToken", err)
+    }
+}
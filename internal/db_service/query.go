@@ -0,0 +1,315 @@
+package db_service
+
+import (
+    "fmt"
+    "strings"
+    "time"
+
+    "go.mongodb.org/mongo-driver/bson"
+    "go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Filter is a backend neutral condition on stored documents. Field names are
+// the names of the stored (BSON) fields, nested fields are separated by dots,
+// e.g. "patient.id". The zero value matches every document.
+type Filter struct {
+    operator string
+    field    string
+    value    interface{}
+    filters  []Filter
+}
+
+const (
+    opEq  = "$eq"
+    opNe  = "$ne"
+    opGt  = "$gt"
+    opGte = "$gte"
+    opLt  = "$lt"
+    opLte = "$lte"
+    opIn  = "$in"
+    opNin = "$nin"
+    opAnd = "$and"
+    opOr  = "$or"
+)
+
+func Eq(field string, value interface{}) Filter {
+    return Filter{operator: opEq, field: field, value: value}
+}
+
+func Ne(field string, value interface{}) Filter {
+    return Filter{operator: opNe, field: field, value: value}
+}
+
+func Gt(field string, value interface{}) Filter {
+    return Filter{operator: opGt, field: field, value: value}
+}
+
+func Gte(field string, value interface{}) Filter {
+    return Filter{operator: opGte, field: field, value: value}
+}
+
+func Lt(field string, value interface{}) Filter {
+    return Filter{operator: opLt, field: field, value: value}
+}
+
+func Lte(field string, value interface{}) Filter {
+    return Filter{operator: opLte, field: field, value: value}
+}
+
+func In(field string, values ...interface{}) Filter {
+    return Filter{operator: opIn, field: field, value: values}
+}
+
+func Nin(field string, values ...interface{}) Filter {
+    return Filter{operator: opNin, field: field, value: values}
+}
+
+// And matches documents matching all filters. Empty filters are ignored.
+func And(filters ...Filter) Filter {
+    return combine(opAnd, filters)
+}
+
+// Or matches documents matching at least one of the filters. Empty filters
+// are ignored, so Or without any non-empty filter matches every document.
+func Or(filters ...Filter) Filter {
+    return combine(opOr, filters)
+}
+
+func combine(operator string, filters []Filter) Filter {
+    nonEmpty := make([]Filter, 0, len(filters))
+    for _, filter := range filters {
+        if !filter.IsEmpty() {
+            nonEmpty = append(nonEmpty, filter)
+        }
+    }
+    switch len(nonEmpty) {
+    case 0:
+        return Filter{}
+    case 1:
+        return nonEmpty[0]
+    default:
+        return Filter{operator: operator, filters: nonEmpty}
+    }
+}
+
+// IsEmpty reports whether the filter matches every document.
+func (f Filter) IsEmpty() bool {
+    return f.operator == ""
+}
+
+// SortField orders query results by a stored field.
+type SortField struct {
+    Field      string
+    Descending bool
+}
+
+// Query selects documents for DbService.FindDocuments. Zero Limit means that
+// the number of returned documents is not limited. Soft deleted documents
+// are returned only when IncludeDeleted is set.
+type Query struct {
+    Filter         Filter
+    Sort           []SortField
+    Skip           int64
+    Limit          int64
+    IncludeDeleted bool
+}
+
+func (f Filter) toBson() bson.D {
+    switch f.operator {
+    case "":
+        return bson.D{}
+    case opAnd, opOr:
+        filters := bson.A{}
+        for _, filter := range f.filters {
+            filters = append(filters, filter.toBson())
+        }
+        return bson.D{{Key: f.operator, Value: filters}}
+    default:
+        return bson.D{{Key: f.field, Value: bson.D{{Key: f.operator, Value: f.value}}}}
+    }
+}
+
+func sortToBson(sort []SortField) bson.D {
+    result := bson.D{}
+    for _, field := range sort {
+        direction := 1
+        if field.Descending {
+            direction = -1
+        }
+        result = append(result, bson.E{Key: field.Field, Value: direction})
+    }
+    return result
+}
+
+// matches evaluates the filter against a BSON document the same way MongoDB
+// does for the supported operators.
+func (f Filter) matches(document bson.Raw) bool {
+    switch f.operator {
+    case "":
+        return true
+    case opAnd:
+        for _, filter := range f.filters {
+            if !filter.matches(document) {
+                return false
+            }
+        }
+        return true
+    case opOr:
+        for _, filter := range f.filters {
+            if filter.matches(document) {
+                return true
+            }
+        }
+        return false
+    }
+
+    value := lookupField(document, f.field)
+    switch f.operator {
+    case opEq:
+        return equalsOrContains(value, f.value)
+    case opNe:
+        return !equalsOrContains(value, f.value)
+    case opIn:
+        for _, candidate := range f.value.([]interface{}) {
+            if equalsOrContains(value, candidate) {
+                return true
+            }
+        }
+        return false
+    case opNin:
+        for _, candidate := range f.value.([]interface{}) {
+            if equalsOrContains(value, candidate) {
+                return false
+            }
+        }
+        return true
+    }
+
+    // range operators never match missing fields or values of other types
+    if value == nil || f.value == nil || typeRank(normalizeValue(value)) != typeRank(normalizeValue(f.value)) {
+        return false
+    }
+    result := compareValues(value, f.value)
+    switch f.operator {
+    case opGt:
+        return result > 0
+    case opGte:
+        return result >= 0
+    case opLt:
+        return result < 0
+    case opLte:
+        return result <= 0
+    }
+    return false
+}
+
+// equalsOrContains reports whether the value equals the candidate, arrays
+// also match when one of their elements does, as in MongoDB.
+func equalsOrContains(value interface{}, candidate interface{}) bool {
+    if compareValues(value, candidate) == 0 {
+        return true
+    }
+    if array, ok := value.(primitive.A); ok {
+        for _, element := range array {
+            if compareValues(element, candidate) == 0 {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// lookupField returns the value of a (dotted) field or nil if it is missing.
+func lookupField(document bson.Raw, field string) interface{} {
+    raw, err := document.LookupErr(strings.Split(field, ".")...)
+    if err != nil {
+        return nil
+    }
+    var value interface{}
+    if err := raw.Unmarshal(&value); err != nil {
+        return nil
+    }
+    return value
+}
+
+func normalizeValue(value interface{}) interface{} {
+    switch v := value.(type) {
+    case time.Time:
+        return int64(primitive.NewDateTimeFromTime(v))
+    case primitive.DateTime:
+        return int64(v)
+    case int:
+        return float64(v)
+    case int32:
+        return float64(v)
+    case int64:
+        return float64(v)
+    case float32:
+        return float64(v)
+    case primitive.Null:
+        return nil
+    }
+    return value
+}
+
+// typeRank follows the MongoDB comparison order of BSON types.
+func typeRank(value interface{}) int {
+    switch value.(type) {
+    case nil:
+        return 0
+    case float64:
+        return 1
+    case string:
+        return 2
+    case bool:
+        return 3
+    case int64: // dates are normalized to milliseconds
+        return 4
+    default:
+        return 5
+    }
+}
+
+// compareValues returns negative, zero or positive number when a is lower,
+// equal or greater than b.
+func compareValues(a interface{}, b interface{}) int {
+    a, b = normalizeValue(a), normalizeValue(b)
+    if rankA, rankB := typeRank(a), typeRank(b); rankA != rankB {
+        return rankA - rankB
+    }
+    switch va := a.(type) {
+    case float64:
+        vb := b.(float64)
+        switch {
+        case va < vb:
+            return -1
+        case va > vb:
+            return 1
+        }
+        return 0
+    case int64:
+        vb := b.(int64)
+        switch {
+        case va < vb:
+            return -1
+        case va > vb:
+            return 1
+        }
+        return 0
+    case string:
+        return strings.Compare(va, b.(string))
+    case bool:
+        vb := b.(bool)
+        switch {
+        case va == vb:
+            return 0
+        case !va:
+            return -1
+        }
+        return 1
+    case nil:
+        return 0
+    }
+    // embedded documents and arrays are only compared for equality
+    return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
@@ -0,0 +1,97 @@
+package db_service
+
+import (
+    "testing"
+    "time"
+
+    "go.mongodb.org/mongo-driver/bson"
+)
+
+func TestFilterMatches(t *testing.T) {
+    at := time.Date(2030, 1, 1, 9, 0, 0, 0, time.UTC)
+    document, err := bson.Marshal(bson.D{
+        {Key: "id", Value: "a"},
+        {Key: "status", Value: "scheduled"},
+        {Key: "priority", Value: int32(3)},
+        {Key: "datetime", Value: at},
+        {Key: "patient", Value: bson.D{{Key: "id", Value: "user1"}}},
+        {Key: "tags", Value: bson.A{"x", "y"}},
+        {Key: "note", Value: nil},
+    })
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    tests := []struct {
+        name   string
+        filter Filter
+        want   bool
+    }{
+        {"empty", Filter{}, true},
+        {"eq", Eq("status", "scheduled"), true},
+        {"eq other value", Eq("status", "cancelled"), false},
+        {"eq nested", Eq("patient.id", "user1"), true},
+        {"eq number of other width", Eq("priority", int64(3)), true},
+        {"eq array element", Eq("tags", "y"), true},
+        {"eq missing as nil", Eq("deletedat", nil), true},
+        {"eq null as nil", Eq("note", nil), true},
+        {"eq present as nil", Eq("status", nil), false},
+        {"ne", Ne("status", "cancelled"), true},
+        {"ne missing", Ne("deletedat", nil), false},
+        {"ne array element", Ne("tags", "x"), false},
+        {"gt", Gt("priority", 2), true},
+        {"gt equal", Gt("priority", 3), false},
+        {"gte equal", Gte("priority", 3), true},
+        {"lt", Lt("priority", 4), true},
+        {"lte", Lte("priority", 2), false},
+        {"gt date", Gt("datetime", at.Add(-time.Minute)), true},
+        {"lt date", Lt("datetime", at), false},
+        {"range on missing field", Lt("deletedat", at), false},
+        {"range against nil", Gt("priority", nil), false},
+        {"range of other type", Gt("status", 1), false},
+        {"in", In("status", "cancelled", "scheduled"), true},
+        {"in with nil", In("deletedat", "x", nil), true},
+        {"in none", In("status", "cancelled"), false},
+        {"nin", Nin("status", "cancelled"), true},
+        {"nin matching", Nin("id", "a", "b"), false},
+        {"and", And(Eq("status", "scheduled"), Gt("priority", 1)), true},
+        {"and failing", And(Eq("status", "scheduled"), Gt("priority", 5)), false},
+        {"and ignores empty", And(Filter{}, Eq("id", "a")), true},
+        {"or", Or(Eq("status", "cancelled"), Eq("id", "a")), true},
+        {"or failing", Or(Eq("status", "cancelled"), Eq("id", "b")), false},
+        {"or without filters", Or(), true},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            if got := test.filter.matches(document); got != test.want {
+                t.Errorf("matches = %v, want %v", got, test.want)
+            }
+        })
+    }
+}
+
+func TestCompareValues(t *testing.T) {
+    early := time.Date(2030, 1, 1, 9, 0, 0, 0, time.UTC)
+    tests := []struct {
+        name string
+        a, b interface{}
+        want int
+    }{
+        {"numbers", int32(1), 2.5, -1},
+        {"numbers of other width", int64(2), int32(2), 0},
+        {"strings", "b", "a", 1},
+        {"booleans", false, true, -1},
+        {"dates", early.Add(time.Hour), early, 1},
+        {"missing before numbers", nil, 0, -1},
+        {"numbers before strings", 10, "1", -1},
+        {"strings before booleans", "z", false, -1},
+    }
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            got := compareValues(test.a, test.b)
+            if (got < 0) != (test.want < 0) || (got > 0) != (test.want > 0) {
+                t.Errorf("compareValues(%v, %v) = %v, want sign of %v", test.a, test.b, got, test.want)
+            }
+        })
+    }
+}
@@ -0,0 +1,41 @@
+package db_service
+
+import (
+    "time"
+
+    "go.mongodb.org/mongo-driver/bson"
+)
+
+// Stored fields marking soft deleted documents. Document types supporting
+// soft delete map them with fields DeletedAt (*time.Time) and DeletedBy.
+const (
+    DeletedAtField = "deletedat"
+    DeletedByField = "deletedby"
+)
+
+func isDeleted(document bson.Raw) bool {
+    return normalizeValue(lookupField(document, DeletedAtField)) != nil
+}
+
+// setDeleted returns a copy of the document with the deletion marker set,
+// or removed when deletedAt is nil, and with the version incremented.
+func setDeleted(document bson.Raw, deletedAt *time.Time, deletedBy string) (bson.Raw, error) {
+    fields := bson.D{}
+    if err := bson.Unmarshal(document, &fields); err != nil {
+        return nil, err
+    }
+    updated := bson.D{}
+    for _, field := range fields {
+        if field.Key != DeletedAtField && field.Key != DeletedByField && field.Key != VersionField {
+            updated = append(updated, field)
+        }
+    }
+    updated = append(updated, bson.E{Key: VersionField, Value: documentVersion(document) + 1})
+    if deletedAt != nil {
+        updated = append(updated,
+            bson.E{Key: DeletedAtField, Value: *deletedAt},
+            bson.E{Key: DeletedByField, Value: deletedBy},
+        )
+    }
+    return bson.Marshal(updated)
+}
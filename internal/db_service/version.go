@@ -0,0 +1,71 @@
+package db_service
+
+import (
+    "fmt"
+
+    "go.mongodb.org/mongo-driver/bson"
+)
+
+// VersionField is the stored field with the version of the document. The
+// version is 1 after the document is created and it is incremented on every
+// change. Document types exposing it map it with field Version (int64).
+const VersionField = "version"
+
+// AnyVersion disables the version check of the conditional operations.
+const AnyVersion int64 = -1
+
+var ErrPreconditionFailed = fmt.Errorf("precondition failed: document version does not match")
+
+// versioned returns the document with its version set to the given value.
+func versioned[DocType interface{}](document *DocType, version int64) (bson.D, error) {
+    fields, err := unversioned(document)
+    if err != nil {
+        return nil, err
+    }
+    return append(fields, bson.E{Key: VersionField, Value: version}), nil
+}
+
+// unversioned returns the fields of the document without its version.
+func unversioned[DocType interface{}](document *DocType) (bson.D, error) {
+    raw, err := bson.Marshal(document)
+    if err != nil {
+        return nil, err
+    }
+    fields := bson.D{}
+    if err := bson.Unmarshal(raw, &fields); err != nil {
+        return nil, err
+    }
+    result := bson.D{}
+    for _, field := range fields {
+        if field.Key != VersionField {
+            result = append(result, field)
+        }
+    }
+    return result, nil
+}
+
+// setVersion stores the version into the version field of the document.
+func setVersion[DocType interface{}](document *DocType, version int64) error {
+    raw, err := bson.Marshal(bson.D{{Key: VersionField, Value: version}})
+    if err != nil {
+        return err
+    }
+    return bson.Unmarshal(raw, document)
+}
+
+// documentVersion returns the version of a stored document, documents stored
+// before versioning was introduced have version 0.
+func documentVersion(document bson.Raw) int64 {
+    if version, ok := normalizeValue(lookupField(document, VersionField)).(float64); ok {
+        return int64(version)
+    }
+    return 0
+}
+
+// versionFilter matches documents with the given version.
+func versionFilter(version int64) Filter {
+    if version == 0 {
+        return In(VersionField, int64(0), nil)
+    }
+    return Eq(VersionField, version)
+}
@@ -12,6 +12,14 @@ $env:AMBULANCE_API_ENVIRONMENT="Development"
 $env:AMBULANCE_API_PORT="8080"
 $env:AMBULANCE_API_MONGODB_USERNAME="ja"
 $env:AMBULANCE_API_MONGODB_PASSWORD="WAC-studeN-025"
+# development key pair, generated on first use and ignored by git, tokens
+# signed by its private key are accepted locally, see the token command
+$DevAuth = "${ProjectRoot}/.dev-auth"
+$env:AMBULANCE_API_AUTH_JWKS="${DevAuth}/jwks.json"
+
+function devToken {
+    go run ${ProjectRoot}/cmd/dev-token -dir $DevAuth $args
+}
 
 function mongo {
     docker compose --file ${ProjectRoot}/deployments/docker-compose/compose.yaml $args
@@ -24,11 +32,25 @@ switch ($command) {
     "start" {
         try {
             mongo up --detach
+            devToken
             go run ${ProjectRoot}/cmd/ambulance-api-service
         } finally {
             mongo down
         }
     }
+    "start-memory" {
+        $env:AMBULANCE_API_DB_BACKEND="memory"
+        $env:AMBULANCE_API_AUTH_DISABLED="true"
+        go run ${ProjectRoot}/cmd/ambulance-api-service
+    }
+    "token" {
+        # prints a bearer token of the development key pair valid for a day,
+        # e.g. ./scripts/run.ps1 token user1 patient
+        $user, $role = $args
+        if (-not $user) { $user = "admin" }
+        if (-not $role) { $role = "admin" }
+        devToken $user $role
+    }
     "mongo" {
         mongo up
     }